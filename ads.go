@@ -0,0 +1,46 @@
+package main
+
+import (
+	"context"
+	"github.com/rajder/gosha1/hashwalk"
+	"os"
+)
+
+// dataStream is a named NTFS alternate data stream of a file, hashed with
+// -ads as path:name.
+type dataStream struct {
+	name string
+	size int64
+}
+
+// streamInfo is the os.FileInfo of a stream, its file's with the stream's
+// name and size.
+type streamInfo struct {
+	os.FileInfo
+	name string
+	size int64
+}
+
+func (s streamInfo) Name() string { return s.name }
+func (s streamInfo) Size() int64  { return s.size }
+
+// sendStreams sends a job to jobs for each alternate data stream of the
+// file of j. Files whose streams can't be listed are only warned about,
+// their main stream is still hashed.
+func sendStreams(ctx context.Context, jobs chan<- job, j job) error {
+	streams, err := alternateStreams(j.Path)
+	if err != nil {
+		logWarning("alternate data streams of", j.Path+":", err)
+	}
+	for _, s := range streams {
+		if opts.emptyFiles == "skip" && s.size == 0 || !wantSize(s.size) {
+			continue
+		}
+		info := streamInfo{j.Info, j.Info.Name() + ":" + s.name, s.size}
+		f := hashwalk.File{Path: j.Path + ":" + s.name, Info: info, Root: j.Root}
+		if err := sendJob(ctx, jobs, job{File: f}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,12 @@
+//go:build !windows
+// +build !windows
+
+package main
+
+const adsSupported = false
+
+// alternateStreams lists nothing, alternate data streams are an NTFS
+// feature only Windows exposes.
+func alternateStreams(path string) ([]dataStream, error) {
+	return nil, nil
+}
@@ -0,0 +1,59 @@
+//go:build windows
+// +build windows
+
+package main
+
+import (
+	"github.com/rajder/gosha1/hashwalk"
+	"strings"
+	"syscall"
+	"unsafe"
+)
+
+const adsSupported = true
+
+var (
+	modkernel32          = syscall.NewLazyDLL("kernel32.dll")
+	procFindFirstStreamW = modkernel32.NewProc("FindFirstStreamW")
+	procFindNextStreamW  = modkernel32.NewProc("FindNextStreamW")
+)
+
+// win32FindStreamData is WIN32_FIND_STREAM_DATA.
+type win32FindStreamData struct {
+	StreamSize int64
+	StreamName [syscall.MAX_PATH + 36]uint16
+}
+
+// alternateStreams lists the named $DATA streams of the file at path, not
+// the unnamed main stream.
+func alternateStreams(path string) ([]dataStream, error) {
+	p, err := syscall.UTF16PtrFromString(hashwalk.LongPath(path))
+	if err != nil {
+		return nil, err
+	}
+	var d win32FindStreamData
+	// 0 is FindStreamInfoStandard.
+	h, _, e := procFindFirstStreamW.Call(uintptr(unsafe.Pointer(p)), 0, uintptr(unsafe.Pointer(&d)), 0)
+	if syscall.Handle(h) == syscall.InvalidHandle {
+		if e == syscall.ERROR_HANDLE_EOF {
+			return nil, nil
+		}
+		return nil, e
+	}
+	defer syscall.FindClose(syscall.Handle(h))
+	var streams []dataStream
+	for {
+		// Names are like :name:$DATA, the main stream is ::$DATA.
+		name := syscall.UTF16ToString(d.StreamName[:])
+		if name != "::$DATA" && strings.HasSuffix(name, ":$DATA") {
+			streams = append(streams, dataStream{strings.TrimSuffix(name[1:], ":$DATA"), d.StreamSize})
+		}
+		r, _, e := procFindNextStreamW.Call(h, uintptr(unsafe.Pointer(&d)))
+		if r == 0 {
+			if e == syscall.ERROR_HANDLE_EOF {
+				return streams, nil
+			}
+			return streams, e
+		}
+	}
+}
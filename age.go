@@ -0,0 +1,66 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// timeBound is a flag of a point in time for -newer-than and -older-than:
+// an age before now, a Go duration such as 6h or a number of days or weeks
+// such as 30d or 2w, or an RFC 3339 timestamp or date.
+type timeBound struct {
+	t *time.Time
+}
+
+func (v timeBound) String() string {
+	if v.t == nil || v.t.IsZero() {
+		return ""
+	}
+	return v.t.Format(time.RFC3339)
+}
+
+func (v timeBound) Set(s string) error {
+	s = strings.TrimSpace(s)
+	for _, layout := range []string{time.RFC3339Nano, "2006-01-02T15:04:05", "2006-01-02"} {
+		if t, err := time.ParseInLocation(layout, s, time.Local); err == nil {
+			*v.t = t
+			return nil
+		}
+	}
+	d, err := parseAge(s)
+	if err != nil {
+		return fmt.Errorf("invalid time %q, e.g. 30d, 6h, 2024-05-01 or 2024-05-01T12:00:00Z", s)
+	}
+	*v.t = time.Now().Add(-d)
+	return nil
+}
+
+// parseAge parses a Go duration or a number of days (d) or weeks (w).
+func parseAge(s string) (time.Duration, error) {
+	for _, u := range []struct {
+		suffix string
+		unit   time.Duration
+	}{{"d", 24 * time.Hour}, {"w", 7 * 24 * time.Hour}} {
+		if strings.HasSuffix(s, u.suffix) {
+			n, err := strconv.ParseFloat(strings.TrimSuffix(s, u.suffix), 64)
+			if err != nil || n < 0 {
+				return 0, fmt.Errorf("invalid age %q", s)
+			}
+			return time.Duration(n * float64(u.unit)), nil
+		}
+	}
+	d, err := time.ParseDuration(s)
+	if err != nil || d < 0 {
+		return 0, fmt.Errorf("invalid age %q", s)
+	}
+	return d, nil
+}
+
+// wantModTime reports whether a file modified at t is within -newer-than
+// and -older-than, unset bounds being no limit.
+func wantModTime(t time.Time) bool {
+	return (opts.newerThan.IsZero() || t.After(opts.newerThan)) &&
+		(opts.olderThan.IsZero() || t.Before(opts.olderThan))
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"context"
+	"fmt"
+	"github.com/rajder/gosha1/hashwalk"
+	"io"
+	"os"
+	"path"
+	"strings"
+)
+
+// archiveSep separates the path of an archive from the path of a member
+// inside it, as in backup.zip!/docs/a.txt.
+const archiveSep = "!/"
+
+var archiveSuffixes = []string{".zip", ".jar", ".tar", ".tgz", ".tar.gz"}
+
+// isArchive reports whether -archives looks inside the file at p, going by
+// its name.
+func isArchive(p string) bool {
+	lower := strings.ToLower(p)
+	for _, sfx := range archiveSuffixes {
+		if strings.HasSuffix(lower, sfx) {
+			return true
+		}
+	}
+	return false
+}
+
+// archiveMembers hashes each regular file inside the archive at p, without
+// unpacking it, and passes a result per member to each until it returns
+// false. Archives inside archives are hashed but not opened.
+func archiveMembers(ctx context.Context, p string, root int, algos []string, each func(r result) bool) error {
+	lower := strings.ToLower(p)
+	if strings.HasSuffix(lower, ".zip") || strings.HasSuffix(lower, ".jar") {
+		return zipMembers(ctx, p, root, algos, each)
+	}
+	return tarMembers(ctx, p, root, algos, each)
+}
+
+// memberPath is the virtual path of the archive member name.
+func memberPath(archive, name string) string {
+	return archive + archiveSep + strings.TrimPrefix(path.Clean("/"+name), "/")
+}
+
+// wantMember applies -empty-files skip, -min-size and -max-size to members.
+func wantMember(size int64) bool {
+	return !(opts.emptyFiles == "skip" && size == 0) && wantSize(size)
+}
+
+func zipMembers(ctx context.Context, p string, root int, algos []string, each func(r result) bool) error {
+	zr, err := zip.OpenReader(p)
+	if err != nil {
+		return fmt.Errorf("%s: %v", p, err)
+	}
+	defer zr.Close()
+	for _, f := range zr.File {
+		if !f.Mode().IsRegular() || !wantMember(int64(f.UncompressedSize64)) {
+			continue
+		}
+		rc, err := f.Open()
+		if err != nil {
+			return fmt.Errorf("%s: %v", memberPath(p, f.Name), err)
+		}
+		sums, size, err := hashwalk.SumReaderContext(ctx, limitRead(ctx, rc), algos, nil)
+		rc.Close()
+		if err != nil {
+			return fmt.Errorf("%s: %v", memberPath(p, f.Name), err)
+		}
+		r := result{Path: memberPath(p, f.Name), Sum: sums[0], Sums: sums, Size: size,
+			ModTime: f.Modified, Root: root}
+		if !each(r) {
+			return nil
+		}
+	}
+	return nil
+}
+
+func tarMembers(ctx context.Context, p string, root int, algos []string, each func(r result) bool) error {
+	f, err := os.Open(p)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	in := limitRead(ctx, f)
+	if lower := strings.ToLower(p); strings.HasSuffix(lower, ".tgz") || strings.HasSuffix(lower, ".gz") {
+		gz, err := gzip.NewReader(in)
+		if err != nil {
+			return fmt.Errorf("%s: %v", p, err)
+		}
+		defer gz.Close()
+		in = gz
+	}
+	tr := tar.NewReader(in)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("%s: %v", p, err)
+		}
+		if !hdr.FileInfo().Mode().IsRegular() || !wantMember(hdr.Size) {
+			continue
+		}
+		sums, size, err := hashwalk.SumReaderContext(ctx, tr, algos, nil)
+		if err != nil {
+			return fmt.Errorf("%s: %v", memberPath(p, hdr.Name), err)
+		}
+		r := result{Path: memberPath(p, hdr.Name), Sum: sums[0], Sums: sums, Size: size,
+			ModTime: hdr.ModTime, Root: root}
+		if !each(r) {
+			return nil
+		}
+	}
+}
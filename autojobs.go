@@ -0,0 +1,99 @@
+package main
+
+import (
+	"os"
+	"sort"
+	"time"
+)
+
+type storageKind int
+
+const (
+	storageUnknown storageKind = iota
+	storageSolid
+	storageRotational
+	storageNetwork
+)
+
+// storage is what probeStorage found out about a root, detail names the
+// device or network file system.
+type storage struct {
+	kind   storageKind
+	detail string
+}
+
+// slowLatency is the metadata latency above which a root is treated like
+// a network file system, whatever it is mounted as.
+const slowLatency = 2 * time.Millisecond
+
+// statLatency is the median time to stat up to 16 entries of the directory
+// root, 0 if there are none.
+func statLatency(root string) time.Duration {
+	d, err := os.Open(root)
+	if err != nil {
+		return 0
+	}
+	names, _ := d.Readdirnames(16)
+	d.Close()
+	var times []time.Duration
+	for _, n := range names {
+		start := time.Now()
+		os.Lstat(root + string(os.PathSeparator) + n)
+		times = append(times, time.Since(start))
+	}
+	if len(times) == 0 {
+		return 0
+	}
+	sort.Slice(times, func(i, j int) bool { return times[i] < times[j] })
+	return times[len(times)/2]
+}
+
+// autoTune picks -j, -prefetch and -buffer-size for -auto-jobs from the
+// storage of the roots, leaving those given on the command line alone:
+// one file at a time with large reads on spinning disks, where parallel
+// reads seek back and forth, two workers per CPU on SSDs, which need a
+// deep queue, and many workers reading ahead on network file systems and
+// other high latency storage, where the round trips dominate. The slowest
+// kind of storage among the roots wins.
+func autoTune(roots []string) {
+	kind := storageUnknown
+	var detail string
+	for _, root := range roots {
+		s := probeStorage(root)
+		if s.kind != storageNetwork {
+			if lat := statLatency(root); lat >= slowLatency {
+				s = storage{storageNetwork, lat.Round(time.Microsecond).String() + " latency"}
+			}
+		}
+		if s.kind > kind {
+			kind, detail = s.kind, root+": "+s.detail
+		}
+	}
+	jobs, prefetch, buffer := 0, 0, 0
+	var what string
+	switch kind {
+	case storageRotational:
+		jobs, buffer, what = 1, 1<<20, "rotational disk"
+	case storageSolid:
+		jobs, what = 2*numCPU, "SSD"
+	case storageNetwork:
+		jobs = 4 * numCPU
+		if jobs < 16 {
+			jobs = 16
+		}
+		prefetch, buffer, what = jobs, 1<<20, "network or high latency storage"
+	default:
+		log("Auto jobs    : storage unknown, keeping the defaults")
+		return
+	}
+	if !flagGiven("j") {
+		opts.jobs = jobs
+	}
+	if prefetch > 0 && !flagGiven("prefetch") && opts.limitRate == 0 && !opts.noCachePollution {
+		opts.prefetch = prefetch
+	}
+	if buffer > 0 && !flagGiven("buffer-size") {
+		opts.bufferSize = byteSize(buffer)
+	}
+	log("Auto jobs    :", workerCount(), "workers,", opts.prefetch, "prefetched,", int64(opts.bufferSize), "byte reads for", what, "("+detail+")")
+}
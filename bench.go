@@ -0,0 +1,174 @@
+package main
+
+import (
+	"fmt"
+	"github.com/rajder/gosha1/hashwalk"
+	"io"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// benchAlgos are the algorithms -bench recommends from, those fit to find
+// duplicates: md5, crc32c and xxh64 are measured too, but collisions can be
+// made or happen by chance.
+var benchAlgos = []string{"blake2b", "blake3", "sha1", "sha256", "sha512"}
+
+// benchReadBytes is how much of the directory each read pass reads.
+const benchReadBytes = 256 << 20
+
+// runBench measures the hash throughput of each algorithm on one core and,
+// given a directory, the read throughput of its files with 1, 2, 4 and up to
+// 4 workers per CPU, then prints the -j and -algo it recommends. Returns the
+// exit code.
+func runBench(dir string) int {
+	fastest, fastestMBps := "", 0.0
+	hashMBps := make(map[string]float64)
+	names := make([]string, 0, len(hashwalk.Algorithms))
+	for name := range hashwalk.Algorithms {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		hashMBps[name] = hashThroughput(name)
+		fmt.Fprintf(stdout, "hash\t%s\t%.0f MB/s\n", name, hashMBps[name])
+	}
+	for _, name := range benchAlgos {
+		if hashMBps[name] > fastestMBps {
+			fastest, fastestMBps = name, hashMBps[name]
+		}
+	}
+	if dir == "" {
+		log("Bench        :", "no directory given, -j depends on the storage")
+		fmt.Fprintf(stdout, "recommend\t-j %d -algo %s\n", numCPU, fastest)
+		return 0
+	}
+	files, err := benchFiles(dir)
+	if err != nil {
+		logError(err)
+		return exitError
+	}
+	if len(files) == 0 {
+		logError(fmt.Errorf("%s has no files to read", dir))
+		return exitError
+	}
+	jobs, readMBps := 1, 0.0
+	for j := 1; j <= 4*numCPU || j == 1; j *= 2 {
+		MBps, err := readThroughput(files, j)
+		if err != nil {
+			logError(err)
+			return exitError
+		}
+		fmt.Fprintf(stdout, "read\t-j %d\t%.0f MB/s\n", j, MBps)
+		// More workers only pay if they read at least 10% faster.
+		if MBps > readMBps*1.1 {
+			jobs, readMBps = j, MBps
+		}
+	}
+	cores := jobs
+	if cores > numCPU {
+		cores = numCPU
+	}
+	algo := fastest
+	if hashMBps[fastest]*float64(cores) < readMBps {
+		log("Bench        :", fmt.Sprintf("CPU bound, %s hashes %.0f MB/s on %d cores, the disk reads %.0f MB/s",
+			fastest, hashMBps[fastest]*float64(cores), cores, readMBps))
+	} else {
+		// Read bound: all that keep up are as fast, take the default if it does.
+		if hashMBps["sha1"]*float64(cores) >= readMBps {
+			algo = "sha1"
+		}
+		log("Bench        :", fmt.Sprintf("read bound, any of %s above %.0f MB/s per core keeps up",
+			strings.Join(benchAlgos, ","), readMBps/float64(cores)))
+	}
+	fmt.Fprintf(stdout, "recommend\t-j %d -algo %s\n", jobs, algo)
+	return 0
+}
+
+// hashThroughput hashes random data from memory with algo for a quarter of
+// a second and returns the MB/s.
+func hashThroughput(algo string) float64 {
+	buf := make([]byte, 1<<20)
+	rand.New(rand.NewSource(1)).Read(buf)
+	h := hashwalk.Algorithms[algo]()
+	start := time.Now()
+	n := 0
+	for n < 8 || time.Since(start) < 250*time.Millisecond {
+		h.Write(buf)
+		n++
+	}
+	return float64(n) / time.Since(start).Seconds()
+}
+
+// benchFiles returns the regular files below dir, up to benchReadBytes of
+// them.
+func benchFiles(dir string) ([]string, error) {
+	var files []string
+	var total int64
+	err := filepath.Walk(dir, func(p string, fi os.FileInfo, err error) error {
+		if err != nil {
+			logWarning(err)
+			return nil
+		}
+		if total >= benchReadBytes {
+			return filepath.SkipDir
+		}
+		if fi.Mode().IsRegular() && fi.Size() > 0 {
+			files = append(files, p)
+			total += fi.Size()
+		}
+		return nil
+	})
+	return files, err
+}
+
+// readThroughput reads files with jobs workers and returns the MB/s. The
+// files are dropped from the page cache first where the platform allows, so
+// each pass reads from the disk.
+func readThroughput(files []string, jobs int) (float64, error) {
+	paths := make(chan string)
+	var mu sync.Mutex
+	var total int64
+	var firstErr error
+	var wg sync.WaitGroup
+	start := time.Now()
+	for i := 0; i < jobs; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			buf := make([]byte, 1<<20)
+			for p := range paths {
+				n, err := benchRead(p, buf)
+				mu.Lock()
+				total += n
+				if err != nil && firstErr == nil {
+					firstErr = err
+				}
+				mu.Unlock()
+			}
+		}()
+	}
+	for _, p := range files {
+		paths <- p
+	}
+	close(paths)
+	wg.Wait()
+	if firstErr != nil {
+		return 0, firstErr
+	}
+	return float64(total) / 1024 / 1024 / time.Since(start).Seconds(), nil
+}
+
+func benchRead(path string, buf []byte) (int64, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+	dropCache(f, 0, 0)
+	return io.CopyBuffer(io.Discard, struct{ io.Reader }{f}, buf)
+}
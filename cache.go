@@ -0,0 +1,209 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const cacheHeader = "# gosha1 cache v1"
+
+type cacheEntry struct {
+	Sum     []byte
+	Size    int64
+	ModTime time.Time
+}
+
+// Results of earlier runs keyed by absolute path.
+type cache map[string]cacheEntry
+
+// loadCache reads a cache file and the algorithm of its sums, a missing
+// file gives an empty cache. Caches without an algo line are sha1.
+func loadCache(path string) (cache, string, error) {
+	c := make(cache)
+	algo := "sha1"
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return c, opts.algos[0], nil
+	}
+	if err != nil {
+		return nil, "", err
+	}
+	defer f.Close()
+	sc := bufio.NewScanner(f)
+	sc.Buffer(make([]byte, 64*1024), 1024*1024)
+	n := 0
+	for sc.Scan() {
+		n++
+		line := sc.Text()
+		if n == 1 && line != cacheHeader {
+			return nil, "", fmt.Errorf("%s: not a gosha1 cache file", path)
+		}
+		if strings.HasPrefix(line, "# algo:") {
+			algo = strings.TrimSpace(strings.TrimPrefix(line, "# algo:"))
+			continue
+		}
+		if n == 1 || line == "" {
+			continue
+		}
+		cols := strings.SplitN(line, "\t", 4)
+		if len(cols) != 4 {
+			return nil, "", fmt.Errorf("%s:%d: malformed cache line", path, n)
+		}
+		sum, err1 := hex.DecodeString(cols[0])
+		size, err2 := strconv.ParseInt(cols[1], 10, 64)
+		mtime, err3 := strconv.ParseInt(cols[2], 10, 64)
+		if err1 != nil || err2 != nil || err3 != nil {
+			return nil, "", fmt.Errorf("%s:%d: malformed cache line", path, n)
+		}
+		c[cols[3]] = cacheEntry{sum, size, time.Unix(0, mtime)}
+	}
+	return c, algo, sc.Err()
+}
+
+// save writes the cache of algo sums to path, replacing it atomically.
+func (c cache) save(path, algo string) error {
+	keys := make([]string, 0, len(c))
+	for k := range c {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".gosha1-cache-*")
+	if err != nil {
+		return err
+	}
+	bw := bufio.NewWriter(tmp)
+	fmt.Fprintln(bw, cacheHeader)
+	fmt.Fprintln(bw, "# algo:", algo)
+	for _, k := range keys {
+		e := c[k]
+		fmt.Fprintf(bw, "%x\t%d\t%d\t%s\n", e.Sum, e.Size, e.ModTime.UnixNano(), k)
+	}
+	err = bw.Flush()
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), path)
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+	}
+	return err
+}
+
+func cacheKey(path string) (string, error) {
+	return filepath.Abs(path)
+}
+
+// lookup returns the cached sum of the file at path if its size and mtime
+// are unchanged.
+func (c cache) lookup(path string, info os.FileInfo) ([]byte, bool) {
+	if len(c) == 0 {
+		return nil, false
+	}
+	k, err := cacheKey(path)
+	if err != nil {
+		return nil, false
+	}
+	e, ok := c[k]
+	if !ok || e.Size != info.Size() || !e.ModTime.Equal(info.ModTime()) {
+		return nil, false
+	}
+	return e.Sum, true
+}
+
+// isUnder reports whether the absolute path p is root or inside it.
+func isUnder(p, root string) bool {
+	if p == root {
+		return true
+	}
+	return strings.HasPrefix(p, strings.TrimSuffix(root, string(filepath.Separator))+string(filepath.Separator))
+}
+
+func countCached(rs resultSlice) int {
+	n := 0
+	for _, r := range rs {
+		if r.Cached {
+			n++
+		}
+	}
+	return n
+}
+
+// cacheChange is a difference between the cache and the current scan.
+type cacheChange struct {
+	Status string // new, changed or deleted
+	Sum    []byte
+	Path   string
+}
+
+// update replaces the entries below the roots with rs and returns what
+// changed, sorted by path.
+func (c cache) update(roots []string, rs resultSlice) ([]cacheChange, error) {
+	absRoots := make([]string, len(roots))
+	for i, root := range roots {
+		abs, err := cacheKey(root)
+		if err != nil {
+			return nil, err
+		}
+		absRoots[i] = abs
+	}
+	var changes []cacheChange
+	seen := make(map[string]bool, len(rs))
+	for _, r := range rs {
+		k, err := cacheKey(r.Path)
+		if err != nil {
+			return nil, err
+		}
+		seen[k] = true
+		old, ok := c[k]
+		if !ok {
+			changes = append(changes, cacheChange{"new", r.Sum, r.Path})
+		} else if !bytes.Equal(old.Sum, r.Sum) {
+			changes = append(changes, cacheChange{"changed", r.Sum, r.Path})
+		}
+		c[k] = cacheEntry{r.Sum, r.Size, r.ModTime}
+	}
+	for k, e := range c {
+		if seen[k] {
+			continue
+		}
+		for i, absRoot := range absRoots {
+			if !isUnder(k, absRoot) {
+				continue
+			}
+			rel, err := filepath.Rel(absRoot, k)
+			if err != nil {
+				return nil, err
+			}
+			changes = append(changes, cacheChange{"deleted", e.Sum, filepath.Join(roots[i], rel)})
+			delete(c, k)
+			break
+		}
+	}
+	sort.Slice(changes, func(i, j int) bool {
+		return changes[i].Path < changes[j].Path
+	})
+	return changes, nil
+}
+
+// printChanges prints the -changed-only report.
+func printChanges(basepath string, changes []cacheChange) {
+	counts := make(map[string]int)
+	found = found || len(changes) > 0
+	for _, ch := range changes {
+		counts[ch.Status]++
+		printLine("%s\t%x\t%s", ch.Status, ch.Sum, relPath(basepath, ch.Path))
+	}
+	log("New          :", counts["new"])
+	log("Changed      :", counts["changed"])
+	log("Deleted      :", counts["deleted"])
+}
@@ -0,0 +1,38 @@
+package main
+
+import (
+	"encoding/hex"
+	"os"
+	"path/filepath"
+)
+
+// skipInCAS leaves out the results already in the -cas-skip store.
+func skipInCAS(rs resultSlice) resultSlice {
+	kept := rs[:0]
+	for _, r := range rs {
+		if !inCAS(opts.casSkip, r.Sum) {
+			kept = append(kept, r)
+		}
+	}
+	log("In CAS       :", len(rs)-len(kept))
+	return kept
+}
+
+// inCAS reports whether a blob with the given sum exists in a content
+// addressed store sharded on the first two hex digits, either as
+// DIR/ab/cdef... (git style) or as DIR/ab/abcdef....
+func inCAS(dir string, sum []byte) bool {
+	h := hex.EncodeToString(sum)
+	if len(h) < 3 {
+		return false
+	}
+	for _, p := range []string{
+		filepath.Join(dir, h[:2], h[2:]),
+		filepath.Join(dir, h[:2], h),
+	} {
+		if fi, err := os.Stat(p); err == nil && fi.Mode().IsRegular() {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"sort"
+	"strings"
+)
+
+// caseCollisions returns the sets of paths of rs, relative to basepath,
+// that differ only in case and so would overwrite each other when copied to
+// a case-insensitive file system like FAT, NTFS or APFS. Each set is sorted
+// and the sets are ordered by their folded path.
+func caseCollisions(basepath string, rs resultSlice) [][]string {
+	byFolded := make(map[string][]string)
+	for _, r := range rs {
+		p := relPath(basepath, r.Path)
+		k := strings.ToLower(p)
+		byFolded[k] = append(byFolded[k], p)
+	}
+	var keys []string
+	for k, ps := range byFolded {
+		if len(ps) > 1 {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+	sets := make([][]string, len(keys))
+	for i, k := range keys {
+		sets[i] = byFolded[k]
+		sort.Strings(sets[i])
+	}
+	return sets
+}
+
+// printCaseCollisions prints the paths differing only in case in a section
+// of their own, a line with the lower case path of each set followed by an
+// indented line per path.
+func printCaseCollisions(basepath string, rs resultSlice) {
+	sets := caseCollisions(basepath, rs)
+	found = found || len(sets) > 0
+	log("Case clashes :", len(sets))
+	if len(sets) == 0 {
+		return
+	}
+	printLine("# case collisions: %d", len(sets))
+	for _, set := range sets {
+		printLine("%s", strings.ToLower(set[0]))
+		for _, p := range set {
+			printLine("\t%s", p)
+		}
+	}
+}
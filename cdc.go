@@ -0,0 +1,195 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"hash"
+	"hash/crc64"
+	"sort"
+)
+
+// Content-defined chunking. A gear-based rolling hash picks the chunk
+// boundaries, so an insert or delete in a large file only changes the chunks
+// around the edit and the rest of the fingerprint stays the same.
+const (
+	cdcMinChunk = 16 * 1024
+	cdcMaxChunk = 256 * 1024
+	cdcMask     = uint64(0xffff) << 48 // ~64 KiB average chunk size.
+)
+
+var gearTable = makeGearTable()
+
+// Deterministic pseudo random table (splitmix64) so fingerprints are stable
+// between runs and machines.
+func makeGearTable() (t [256]uint64) {
+	var x uint64
+	for i := range t {
+		x += 0x9e3779b97f4a7c15
+		z := x
+		z = (z ^ (z >> 30)) * 0xbf58476d1ce4e5b9
+		z = (z ^ (z >> 27)) * 0x94d049bb133111eb
+		t[i] = z ^ (z >> 31)
+	}
+	return
+}
+
+type chunk struct {
+	Sum  uint64
+	Size uint32
+}
+
+// chunker is an io.Writer splitting everything written to it into content
+// defined chunks of min to max bytes, cutting where the bits of maskS of
+// the rolling hash are 0 before avg bytes and those of maskL after.
+type chunker struct {
+	fp           uint64
+	n            int
+	min, avg     int
+	max          int
+	maskS, maskL uint64
+	h            hash.Hash64
+	chunks       []chunk
+}
+
+var crc64Table = crc64.MakeTable(crc64.ECMA)
+
+// newChunker returns the chunker of the -cdc fingerprints.
+func newChunker() *chunker {
+	return &chunker{min: cdcMinChunk, avg: cdcMaxChunk, max: cdcMaxChunk, maskS: cdcMask, maskL: cdcMask,
+		h: crc64.New(crc64Table)}
+}
+
+// newFastCDC returns a chunker with FastCDC's normalized chunking around
+// avg, a power of two: two bits more in the mask before avg and two less
+// after, so most chunks end up close to avg, between a quarter of it and
+// eight times it.
+func newFastCDC(avg int) *chunker {
+	bits := 0
+	for 1<<uint(bits+1) <= avg {
+		bits++
+	}
+	mask := func(ones int) uint64 { return (uint64(1)<<uint(ones) - 1) << uint(64-ones) }
+	return &chunker{min: avg / 4, avg: avg, max: 8 * avg, maskS: mask(bits + 2), maskL: mask(bits - 2),
+		h: crc64.New(crc64Table)}
+}
+
+func (c *chunker) Write(p []byte) (int, error) {
+	start := 0
+	for i, b := range p {
+		c.fp = (c.fp << 1) + gearTable[b]
+		c.n++
+		if c.n < c.min {
+			continue
+		}
+		mask := c.maskS
+		if c.n >= c.avg {
+			mask = c.maskL
+		}
+		if c.fp&mask == 0 || c.n >= c.max {
+			c.h.Write(p[start : i+1])
+			c.cut()
+			start = i + 1
+		}
+	}
+	c.h.Write(p[start:])
+	return len(p), nil
+}
+
+func (c *chunker) cut() {
+	c.chunks = append(c.chunks, chunk{c.h.Sum64(), uint32(c.n)})
+	c.h.Reset()
+	c.fp = 0
+	c.n = 0
+}
+
+// Chunks flushes the trailing partial chunk and returns the fingerprint.
+func (c *chunker) Chunks() []chunk {
+	if c.n > 0 {
+		c.cut()
+	}
+	return c.chunks
+}
+
+// Distinct chunks of a fingerprint and their total size.
+func uniqueChunks(cs []chunk) (map[uint64]uint32, int64) {
+	m := make(map[uint64]uint32, len(cs))
+	var size int64
+	for _, c := range cs {
+		if _, ok := m[c.Sum]; ok {
+			continue
+		}
+		m[c.Sum] = c.Size
+		size += int64(c.Size)
+	}
+	return m, size
+}
+
+type similarPair struct {
+	A, B    string
+	Percent float64
+}
+
+// findSimilar reports pairs of files that are not identical but share at
+// least minPercent of their chunk bytes. Only one file per distinct sum is
+// considered since identical files are already reported as duplicates.
+func findSimilar(rs resultSlice, minPercent float64) []similarPair {
+	type fileChunks struct {
+		path   string
+		chunks map[uint64]uint32
+		size   int64
+	}
+	var files []fileChunks
+	var prev []byte
+	for _, r := range rs {
+		if len(r.Chunks) == 0 || bytes.Equal(prev, r.Sum) {
+			continue
+		}
+		prev = r.Sum
+		m, size := uniqueChunks(r.Chunks)
+		files = append(files, fileChunks{r.Path, m, size})
+	}
+	index := make(map[uint64][]int)
+	for i, f := range files {
+		for sum := range f.chunks {
+			index[sum] = append(index[sum], i)
+		}
+	}
+	var pairs []similarPair
+	for i, f := range files {
+		shared := make(map[int]int64)
+		for sum, size := range f.chunks {
+			for _, j := range index[sum] {
+				if j > i {
+					shared[j] += int64(size)
+				}
+			}
+		}
+		for j, n := range shared {
+			g := files[j]
+			p := 200 * float64(n) / float64(f.size+g.size)
+			if p >= minPercent {
+				pairs = append(pairs, similarPair{f.path, g.path, p})
+			}
+		}
+	}
+	sort.Slice(pairs, func(i, j int) bool {
+		if pairs[i].Percent != pairs[j].Percent {
+			return pairs[i].Percent > pairs[j].Percent
+		}
+		if pairs[i].A != pairs[j].A {
+			return pairs[i].A < pairs[j].A
+		}
+		return pairs[i].B < pairs[j].B
+	})
+	return pairs
+}
+
+func printSimilar(basepath string, rs resultSlice, minPercent float64) {
+	pairs := findSimilar(rs, minPercent)
+	log("Similar pairs:", len(pairs))
+	for _, p := range pairs {
+		a := relPath(basepath, p.A)
+		b := relPath(basepath, p.B)
+		fmt.Fprintf(stderr, "%.1f%%\t%s\t%s\n", p.Percent, a, b)
+	}
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// cgroupLimit returns the lowest limit read finds in the cgroup directories
+// of the process and their parents, for cgroup v2 and the v1 hierarchy of
+// controller. Inside a container, where the path in /proc/self/cgroup may
+// be that of the host, the directories that don't exist are skipped and
+// the root of the mount is the container's own cgroup.
+func cgroupLimit(controller string, read func(dir string) (int64, bool)) (int64, bool) {
+	data, err := os.ReadFile("/proc/self/cgroup")
+	if err != nil {
+		return 0, false
+	}
+	var limit int64
+	found := false
+	for _, line := range strings.Split(strings.TrimSpace(string(data)), "\n") {
+		parts := strings.SplitN(line, ":", 3)
+		if len(parts) != 3 || !strings.HasPrefix(parts[2], "/") {
+			continue
+		}
+		base := "/sys/fs/cgroup"
+		if parts[1] != "" {
+			ours := false
+			for _, c := range strings.Split(parts[1], ",") {
+				ours = ours || c == controller
+			}
+			if !ours {
+				continue
+			}
+			base = filepath.Join(base, parts[1])
+		}
+		for p := parts[2]; ; p = path.Dir(p) {
+			if v, ok := read(filepath.Join(base, p)); ok && (!found || v < limit) {
+				limit, found = v, true
+			}
+			if p == "/" {
+				break
+			}
+		}
+	}
+	return limit, found
+}
+
+func readCgroupInt(file string) (int64, bool) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return 0, false
+	}
+	n, err := strconv.ParseInt(strings.TrimSpace(string(data)), 10, 64)
+	return n, err == nil
+}
+
+// cgroupCPUs returns the CPU quota of the cgroup of the process in
+// thousandths of a CPU, from cpu.max on cgroup v2 or cpu.cfs_quota_us and
+// cpu.cfs_period_us on v1, and false if there is none.
+func cgroupCPUs() (int64, bool) {
+	return cgroupLimit("cpu", func(dir string) (int64, bool) {
+		var quota, period int64
+		if data, err := os.ReadFile(filepath.Join(dir, "cpu.max")); err == nil {
+			// "max 100000" is no quota.
+			f := strings.Fields(string(data))
+			if len(f) != 2 {
+				return 0, false
+			}
+			var err1, err2 error
+			quota, err1 = strconv.ParseInt(f[0], 10, 64)
+			period, err2 = strconv.ParseInt(f[1], 10, 64)
+			if err1 != nil || err2 != nil {
+				return 0, false
+			}
+		} else {
+			var ok1, ok2 bool
+			quota, ok1 = readCgroupInt(filepath.Join(dir, "cpu.cfs_quota_us"))
+			period, ok2 = readCgroupInt(filepath.Join(dir, "cpu.cfs_period_us"))
+			if !ok1 || !ok2 {
+				return 0, false
+			}
+		}
+		// A quota of -1 is none on v1.
+		if quota <= 0 || period <= 0 {
+			return 0, false
+		}
+		return quota * 1000 / period, true
+	})
+}
+
+// cgroupMemory returns the memory limit of the cgroup of the process in
+// bytes, from memory.max on cgroup v2 or memory.limit_in_bytes on v1, and
+// false if there is none.
+func cgroupMemory() (int64, bool) {
+	return cgroupLimit("memory", func(dir string) (int64, bool) {
+		n, ok := readCgroupInt(filepath.Join(dir, "memory.max"))
+		if !ok {
+			n, ok = readCgroupInt(filepath.Join(dir, "memory.limit_in_bytes"))
+		}
+		// v1 reports no limit as the largest multiple of the page size.
+		if !ok || n <= 0 || n >= 1<<62 {
+			return 0, false
+		}
+		return n, true
+	})
+}
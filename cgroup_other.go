@@ -0,0 +1,16 @@
+//go:build !linux
+// +build !linux
+
+package main
+
+// cgroupCPUs returns the CPU quota of the process in thousandths of a CPU,
+// there are no cgroups outside Linux.
+func cgroupCPUs() (int64, bool) {
+	return 0, false
+}
+
+// cgroupMemory returns the memory limit of the process in bytes, there are
+// no cgroups outside Linux.
+func cgroupMemory() (int64, bool) {
+	return 0, false
+}
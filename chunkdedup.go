@@ -0,0 +1,60 @@
+package main
+
+import (
+	"fmt"
+	"sync"
+)
+
+// chunkDedup collects the chunks of every file hashed for -chunk-dedup,
+// nil without it.
+var chunkDedup *chunkSet
+
+// chunkSet is the set of distinct chunks seen so far, to estimate what a
+// block level dedup, or a backup tool like restic or borg, would store.
+type chunkSet struct {
+	mu     sync.Mutex
+	seen   map[uint64]bool
+	chunks int64
+	bytes  int64 // Of all chunks.
+	stored int64 // Of the distinct chunks.
+}
+
+// chunkAverage is the -chunk-size, 1M by default like restic's.
+func chunkAverage() int {
+	if opts.chunkSize == 0 {
+		return 1 << 20
+	}
+	return int(opts.chunkSize)
+}
+
+func newChunkSet() *chunkSet {
+	return &chunkSet{seen: make(map[uint64]bool)}
+}
+
+func (s *chunkSet) add(cs []chunk) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, c := range cs {
+		s.chunks++
+		s.bytes += int64(c.Size)
+		if !s.seen[c.Sum] {
+			s.seen[c.Sum] = true
+			s.stored += int64(c.Size)
+		}
+	}
+}
+
+// print logs the chunk level savings next to those of whole file dedup in
+// files.
+func (s *chunkSet) print(files summary) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	log("Chunks       :", fmt.Sprintf("%d of %d bytes on average, %d distinct",
+		s.chunks, chunkAverage(), len(s.seen)))
+	log("Chunk MB     :", fmt.Sprintf("%g stored of %g, %.1f%% saved (%.1f%% by whole files)",
+		float64(s.stored)/1024/1024, float64(s.bytes)/1024/1024, percent(s.bytes-s.stored, s.bytes),
+		percent(files.DupBytes, files.TotalBytes)))
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"os"
+)
+
+// compareFiles hashes a and b and reports whether they are identical. With
+// verify set, equal sums are confirmed by a byte-by-byte comparison.
+func compareFiles(a, b string, verify bool) (bool, error) {
+	sumsA, sizeA, err := calcSums(context.Background(), a, opts.algos, nil)
+	if err != nil {
+		return false, err
+	}
+	sumsB, sizeB, err := calcSums(context.Background(), b, opts.algos, nil)
+	if err != nil {
+		return false, err
+	}
+	same := sizeA == sizeB
+	for i := range sumsA {
+		prefix := ""
+		if len(sumsA) > 1 {
+			prefix = opts.algos[i] + "\t"
+		}
+		fmt.Fprintf(stdout, "%s%x\t%s\n%s%x\t%s\n", prefix, sumsA[i], a, prefix, sumsB[i], b)
+		same = same && bytes.Equal(sumsA[i], sumsB[i])
+	}
+	if !same {
+		return false, nil
+	}
+	if !verify {
+		return true, nil
+	}
+	return sameContent(a, b)
+}
+
+// sameContent compares two files byte by byte.
+func sameContent(a, b string) (bool, error) {
+	fa, err := os.Open(a)
+	if err != nil {
+		return false, err
+	}
+	defer fa.Close()
+	fb, err := os.Open(b)
+	if err != nil {
+		return false, err
+	}
+	defer fb.Close()
+	bufA := make([]byte, 64*1024)
+	bufB := make([]byte, 64*1024)
+	for {
+		na, errA := io.ReadFull(fa, bufA)
+		nb, errB := io.ReadFull(fb, bufB)
+		if !bytes.Equal(bufA[:na], bufB[:nb]) {
+			return false, nil
+		}
+		endA := errA == io.EOF || errA == io.ErrUnexpectedEOF
+		endB := errB == io.EOF || errB == io.ErrUnexpectedEOF
+		if errA != nil && !endA {
+			return false, errA
+		}
+		if errB != nil && !endB {
+			return false, errB
+		}
+		if endA || endB {
+			return endA == endB, nil
+		}
+	}
+}
+
+// Runs -cmp and returns the exit code: 0 identical, 1 different, 2 error.
+// Two directories are compared as trees, either may be a remote root.
+func runCompare(a, b string, verify bool) int {
+	if isDir(a) && isDir(b) || hasRemoteRoot([]string{a, b}) {
+		n, err := compareTrees(a, b, opts.cmpContent, verify)
+		if err != nil {
+			logError(err)
+			return 2
+		}
+		if n > 0 {
+			return 1
+		}
+		return 0
+	}
+	same, err := compareFiles(a, b, verify)
+	if err != nil {
+		logError(err)
+		return 2
+	}
+	if !same {
+		log("Identical    : no")
+		return 1
+	}
+	log("Identical    : yes")
+	return 0
+}
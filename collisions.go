@@ -0,0 +1,63 @@
+package main
+
+import (
+	"fmt"
+	"github.com/rajder/gosha1/hashwalk"
+	"io"
+	"os"
+)
+
+// collisionFreeGroups reads the files of each sha1 duplicate group again
+// with collision detection for -detect-collisions, and leaves out those
+// containing half of a SHA-1 collision, such as the SHAttered PDFs: they
+// share their sum with another file on purpose, not by having the same
+// contents. Files that can't be read again are left out as well.
+func collisionFreeGroups(groups []resultSlice) []resultSlice {
+	var out []resultSlice
+	var files, crafted, unread int
+	for _, g := range groups {
+		var keep resultSlice
+		for _, r := range g {
+			files++
+			bad, err := sha1Collision(r.Path)
+			if err != nil {
+				logWarning(err)
+				unread++
+				continue
+			}
+			if bad {
+				logWarning(fmt.Sprintf("%s: contains a SHA-1 collision attack, not grouped with the other copies of %x", r.Path, r.Sum))
+				crafted++
+				continue
+			}
+			keep = append(keep, r)
+		}
+		if len(keep) > 1 {
+			out = append(out, keep)
+		}
+	}
+	log("Collisions   :", files, "files in", len(groups), "groups checked")
+	if crafted > 0 {
+		log("Crafted      :", crafted, "files made to collide, left out")
+	}
+	if unread > 0 {
+		log("Unconfirmed  :", unread, "files couldn't be read back, left out")
+	}
+	return out
+}
+
+// sha1Collision reports whether the file at path contains half of a SHA-1
+// collision.
+func sha1Collision(path string) (bool, error) {
+	f, err := os.Open(hashwalk.LongPath(path))
+	if err != nil {
+		return false, err
+	}
+	defer f.Close()
+	h := hashwalk.NewSHA1DC()
+	if _, err := io.Copy(h, f); err != nil {
+		return false, err
+	}
+	h.Sum(nil)
+	return h.Collision(), nil
+}
@@ -0,0 +1,237 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// configSetting is one "key = value" of the config file. An array sets a
+// repeatable flag like exclude once per element.
+type configSetting struct {
+	key    string
+	values []string
+	line   int
+}
+
+// configPath is $GOSHA1_CONFIG, or gosha1/config.toml in the user's config
+// directory, ~/.config on Linux.
+func configPath() string {
+	if p, ok := os.LookupEnv("GOSHA1_CONFIG"); ok {
+		return p
+	}
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(dir, "gosha1", "config.toml")
+}
+
+// loadConfig sets the flags named in the config file at path as defaults,
+// before the command line is parsed. A missing file is fine. Flags given
+// on the command line override the file, repeatable ones add to it.
+func loadConfig(path string) error {
+	if path == "" {
+		return nil
+	}
+	f, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	settings, err := parseConfig(f, path)
+	if err != nil {
+		return err
+	}
+	for _, s := range settings {
+		// TOML keys are usually snake_case, the flags use dashes.
+		key := strings.Replace(s.key, "_", "-", -1)
+		if flag.Lookup(key) == nil {
+			return fmt.Errorf("%s:%d: unknown option %q", path, s.line, s.key)
+		}
+		for _, v := range s.values {
+			if err := flag.Set(key, v); err != nil {
+				return fmt.Errorf("%s:%d: %s: %v", path, s.line, s.key, err)
+			}
+		}
+	}
+	return nil
+}
+
+var configKey = regexp.MustCompile(`^[A-Za-z0-9_-]+$`)
+
+// parseConfig reads the subset of TOML a flat config needs: key = value
+// lines with strings, numbers, booleans and arrays of them, which may span
+// lines, and # comments. Tables aren't supported.
+func parseConfig(r io.Reader, name string) ([]configSetting, error) {
+	var settings []configSetting
+	sc := bufio.NewScanner(r)
+	n := 0
+	for sc.Scan() {
+		n++
+		line := stripConfigComment(sc.Text())
+		if line == "" {
+			continue
+		}
+		if line[0] == '[' {
+			return nil, fmt.Errorf("%s:%d: tables aren't supported, set the options at the top level", name, n)
+		}
+		i := strings.Index(line, "=")
+		if i < 0 {
+			return nil, fmt.Errorf("%s:%d: expected key = value", name, n)
+		}
+		key, rest := strings.TrimSpace(line[:i]), strings.TrimSpace(line[i+1:])
+		if !configKey.MatchString(key) {
+			return nil, fmt.Errorf("%s:%d: invalid key %q", name, n, key)
+		}
+		start := n
+		// Arrays may continue on the following lines.
+		for strings.HasPrefix(rest, "[") && !arrayClosed(rest) && sc.Scan() {
+			n++
+			rest += " " + stripConfigComment(sc.Text())
+		}
+		if strings.HasPrefix(rest, "[") && !arrayClosed(rest) {
+			return nil, fmt.Errorf("%s:%d: %s: unterminated array", name, start, key)
+		}
+		values, err := parseConfigValue(rest)
+		if err != nil {
+			return nil, fmt.Errorf("%s:%d: %s: %v", name, start, key, err)
+		}
+		settings = append(settings, configSetting{key, values, start})
+	}
+	return settings, sc.Err()
+}
+
+// stripConfigComment trims line and drops a # comment outside strings.
+func stripConfigComment(line string) string {
+	var q byte
+	for i := 0; i < len(line); i++ {
+		c := line[i]
+		switch {
+		case q != 0 && c == '\\' && q == '"':
+			i++
+		case q != 0 && c == q:
+			q = 0
+		case q == 0 && (c == '"' || c == '\''):
+			q = c
+		case q == 0 && c == '#':
+			return strings.TrimSpace(line[:i])
+		}
+	}
+	return strings.TrimSpace(line)
+}
+
+// arrayClosed reports whether the array starting s ends in it, ignoring
+// brackets in strings.
+func arrayClosed(s string) bool {
+	for len(s) > 0 {
+		switch s[0] {
+		case '"', '\'':
+			_, rest, err := configString(s)
+			if err != nil {
+				return false
+			}
+			s = rest
+			continue
+		case ']':
+			return true
+		}
+		s = s[1:]
+	}
+	return false
+}
+
+// parseConfigValue parses a value, or the elements of an array, as the
+// strings to set the flag to.
+func parseConfigValue(s string) ([]string, error) {
+	if !strings.HasPrefix(s, "[") {
+		v, rest, err := configScalar(s)
+		if err != nil {
+			return nil, err
+		}
+		if rest = strings.TrimSpace(rest); rest != "" {
+			return nil, fmt.Errorf("unexpected %q after the value", rest)
+		}
+		return []string{v}, nil
+	}
+	var values []string
+	s = strings.TrimSpace(s[1:])
+	for {
+		if strings.HasPrefix(s, "]") {
+			break
+		}
+		v, rest, err := configScalar(s)
+		if err != nil {
+			return nil, err
+		}
+		values = append(values, v)
+		s = strings.TrimSpace(rest)
+		if strings.HasPrefix(s, ",") {
+			s = strings.TrimSpace(s[1:])
+		} else if !strings.HasPrefix(s, "]") {
+			return nil, errors.New("expected , or ] in the array")
+		}
+	}
+	if rest := strings.TrimSpace(s[1:]); rest != "" {
+		return nil, fmt.Errorf("unexpected %q after the array", rest)
+	}
+	return values, nil
+}
+
+// configScalar parses a string, boolean or number at the start of s.
+func configScalar(s string) (string, string, error) {
+	if strings.HasPrefix(s, "\"") || strings.HasPrefix(s, "'") {
+		return configString(s)
+	}
+	end := strings.IndexAny(s, " \t,]")
+	if end < 0 {
+		end = len(s)
+	}
+	v := s[:end]
+	if v == "true" || v == "false" {
+		return v, s[end:], nil
+	}
+	if _, err := strconv.ParseFloat(strings.Replace(v, "_", "", -1), 64); err != nil || v == "" {
+		return "", "", fmt.Errorf("invalid value %q, strings must be quoted", v)
+	}
+	return strings.Replace(v, "_", "", -1), s[end:], nil
+}
+
+// configString parses a "basic" string with backslash escapes or a
+// 'literal' one at the start of s.
+func configString(s string) (string, string, error) {
+	q := s[0]
+	var b strings.Builder
+	for i := 1; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case c == q:
+			return b.String(), s[i+1:], nil
+		case c == '\\' && q == '"' && i+1 < len(s):
+			i++
+			switch s[i] {
+			case 'n':
+				b.WriteByte('\n')
+			case 't':
+				b.WriteByte('\t')
+			case '"', '\\':
+				b.WriteByte(s[i])
+			default:
+				return "", "", fmt.Errorf("unsupported escape \\%c", s[i])
+			}
+		default:
+			b.WriteByte(c)
+		}
+	}
+	return "", "", errors.New("unterminated string")
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"io"
+	"mime"
+	"net/http"
+	"os"
+	"strings"
+)
+
+// sniffLen is as much as http.DetectContentType looks at.
+const sniffLen = 512
+
+// unknownType is what http.DetectContentType returns when nothing matched.
+const unknownType = "application/octet-stream"
+
+// parseTypes splits the -type list. An entry is either a top level type like
+// image or a full media type like image/png.
+func parseTypes(s string) []string {
+	var types []string
+	for _, t := range strings.Split(s, ",") {
+		t = strings.ToLower(strings.TrimSpace(t))
+		if t != "" {
+			types = append(types, t)
+		}
+	}
+	return types
+}
+
+// sniffType returns the media type of the file at path from its first bytes,
+// without any parameters, e.g. "text/plain" for "text/plain; charset=utf-8".
+func sniffType(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	buf := make([]byte, sniffLen)
+	n, err := io.ReadFull(f, buf)
+	if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+		return "", err
+	}
+	t, _, err := mime.ParseMediaType(http.DetectContentType(buf[:n]))
+	if err != nil {
+		return unknownType, nil
+	}
+	return t, nil
+}
+
+func typeMatches(types []string, t string) bool {
+	for _, want := range types {
+		if want == t || !strings.Contains(want, "/") && strings.HasPrefix(t, want+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+// wantType reports whether the file at path should be hashed under -type.
+// Files that can't be read are kept so the error is reported when hashing.
+func wantType(path string) bool {
+	if len(opts.types) == 0 {
+		return true
+	}
+	t, err := sniffType(path)
+	if err != nil {
+		return true
+	}
+	if t == unknownType && !typeMatches(opts.types, t) {
+		return opts.typeUnknown == "include"
+	}
+	return typeMatches(opts.types, t)
+}
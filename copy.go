@@ -0,0 +1,186 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"github.com/rajder/gosha1/hashwalk"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+// copier copies the files hashed for -copy: each file is written to its
+// place below dst while it is read for its sums, so it is read only once.
+// A copy is written to a temporary file next to its target and only
+// renamed over it once complete, with -copy-verify after reading it back.
+type copier struct {
+	src, dst string
+	file     bool // src is a single file, copied to dst itself.
+	verify   bool
+}
+
+// copyTarget is a copy being written, the tee of the file's hashing.
+type copyTarget struct {
+	f    *os.File
+	path string
+	info os.FileInfo
+}
+
+func (t *copyTarget) Write(p []byte) (int, error) {
+	return t.f.Write(p)
+}
+
+// target returns where the file at path is copied to.
+func (c *copier) target(path string) string {
+	if c.file {
+		return c.dst
+	}
+	rel, err := filepath.Rel(c.src, path)
+	if err != nil {
+		rel = filepath.Base(path)
+	}
+	return filepath.Join(c.dst, rel)
+}
+
+// create starts the copy of the file of j.
+func (c *copier) create(j job) (*copyTarget, error) {
+	path := c.target(j.Path)
+	dir := filepath.Dir(path)
+	if err := os.MkdirAll(dir, 0777); err != nil {
+		return nil, err
+	}
+	f, err := os.CreateTemp(dir, "."+filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return nil, err
+	}
+	return &copyTarget{f, path, j.Info}, nil
+}
+
+// finish completes the copy t once its source was hashed with err, or
+// removes it if that failed. The copy gets the mode and modification time
+// of the source. With -copy-verify the copy is read back and its sum
+// compared to that of the source first.
+func (c *copier) finish(ctx context.Context, t *copyTarget, sums [][]byte, err error) error {
+	tmp := t.f.Name()
+	if err == nil {
+		err = t.f.Sync()
+	}
+	if cerr := t.f.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil && c.verify {
+		var got [][]byte
+		got, _, err = calcSums(ctx, tmp, opts.algos[:1], nil)
+		if err == nil && !bytes.Equal(got[0], sums[0]) {
+			err = fmt.Errorf("%s: the copy read back differs from %s", t.path, tmp)
+		}
+	}
+	if err == nil {
+		err = os.Chmod(tmp, t.info.Mode().Perm())
+	}
+	if err == nil {
+		err = os.Chtimes(tmp, t.info.ModTime(), t.info.ModTime())
+	}
+	if err == nil {
+		err = os.Rename(tmp, t.path)
+	}
+	if err != nil {
+		os.Remove(tmp)
+	}
+	return err
+}
+
+// link makes the copy of the hard link alias j a hard link to the copy of
+// its link, so the copy keeps the links of the source, or a copy of it
+// where the destination doesn't support links. The copy of the link is
+// complete by the time the alias has its sums.
+func (c *copier) link(j job) error {
+	path, from := c.target(j.Path), c.target(j.Alias)
+	if err := os.MkdirAll(filepath.Dir(path), 0777); err != nil {
+		return err
+	}
+	os.Remove(path)
+	if os.Link(from, path) == nil {
+		return nil
+	}
+	src, err := os.Open(from)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+	t, err := c.create(j)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(t.f, src)
+	// The copy of the link was verified already.
+	plain := *c
+	plain.verify = false
+	return plain.finish(context.Background(), t, nil, err)
+}
+
+// runCopy copies the file or tree src to dst for -copy, hashing it on the
+// way, and prints the manifest of what was copied, paths relative to src
+// and so to dst as well.
+func runCopy(src, dst string) error {
+	fi, err := os.Stat(src)
+	if err != nil {
+		return err
+	}
+	c := &copier{src: src, dst: dst, verify: opts.copyVerify}
+	base := src
+	walk := walkRoots([]string{src}, nil)
+	if !fi.IsDir() {
+		c.file = true
+		base = filepath.Dir(src)
+		if isDir(dst) {
+			c.dst = filepath.Join(dst, filepath.Base(src))
+		}
+		walk = jobList([]job{{File: hashwalk.File{Path: src, Info: fi}}})
+	} else {
+		absSrc, err := filepath.Abs(src)
+		if err != nil {
+			return err
+		}
+		absDst, err := filepath.Abs(dst)
+		if err != nil {
+			return err
+		}
+		if isUnder(absDst, absSrc) {
+			return errors.New("-copy can't copy a directory into itself")
+		}
+	}
+	ctx, cancel := runContext()
+	defer cancel()
+	var failed []result
+	rs, err := collect(produceConcurrent(ctx, hashSpec{algos: opts.algos, copy: c}, walk), nil, &failed)
+	if serr := stopError(ctx, err); serr != nil {
+		return serr
+	}
+	if err != nil {
+		return err
+	}
+	// Empty directories have no file to create them.
+	for _, d := range emptyDirs {
+		if err := os.MkdirAll(c.target(d), 0777); err != nil {
+			logWarning(err)
+		}
+	}
+	sort.Sort(rs)
+	if err := printResultBuffer(base, rs); err != nil {
+		return err
+	}
+	var size int64
+	for _, r := range rs {
+		size += r.Size
+	}
+	log("Copied       :", len(rs), "files to", dst)
+	log("Copied MB    :", float64(size)/1024/1024)
+	if c.verify {
+		log("Verified     :", len(rs), "copies read back")
+	}
+	return failedFilesError(failed)
+}
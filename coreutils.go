@@ -0,0 +1,103 @@
+package main
+
+import (
+	"encoding/hex"
+	"regexp"
+	"strings"
+)
+
+// tagNames are the algorithm names BSD style (--tag) lines use, as printed
+// by shasum, md5sum --tag and b2sum --tag.
+var tagNames = map[string]string{
+	"blake2b": "BLAKE2b",
+	"blake3":  "BLAKE3",
+	"crc32c":  "CRC32C",
+	"md5":     "MD5",
+	"sha1":    "SHA1",
+	"sha256":  "SHA256",
+	"sha512":  "SHA512",
+	"xxh64":   "XXH64",
+}
+
+// escapeCoreutils escapes backslashes and newlines in a path the way
+// sha1sum does. Escaped lines start with a backslash. NUL terminated
+// records (-print0, like sha1sum -z) aren't escaped.
+func escapeCoreutils(p string) (string, bool) {
+	if opts.print0 || !strings.ContainsAny(p, "\\\n") {
+		return p, false
+	}
+	p = strings.Replace(p, "\\", "\\\\", -1)
+	return strings.Replace(p, "\n", "\\n", -1), true
+}
+
+func unescapeCoreutils(p string) string {
+	var b strings.Builder
+	for i := 0; i < len(p); i++ {
+		if p[i] == '\\' && i+1 < len(p) {
+			i++
+			if p[i] == 'n' {
+				b.WriteByte('\n')
+				continue
+			}
+		}
+		b.WriteByte(p[i])
+	}
+	return b.String()
+}
+
+// printCoreutils prints r as "<hex>  <path>" for sha1sum -c and friends, or
+// with -tag as "SHA1 (path) = <hex>" lines, one per algorithm.
+func printCoreutils(rel string, r *result) {
+	p, escaped := escapeCoreutils(rel)
+	prefix := ""
+	if escaped {
+		prefix = "\\"
+	}
+	if !opts.tag {
+		printLine("%s%x  %s", prefix, r.Sum, p)
+		return
+	}
+	for i, algo := range opts.algos {
+		printLine("%s%s (%s) = %x", prefix, tagNames[algo], p, r.Sums[i])
+	}
+}
+
+var tagLine = regexp.MustCompile(`^([A-Za-z0-9]+) \((.*)\) = ([0-9a-fA-F]+)$`)
+
+// parseCoreutilsLine reads a "<hex>  <path>", "<hex> *<path>" or BSD tag line
+// as written by the coreutils and BSD sum tools. ok is false for anything
+// else.
+func parseCoreutilsLine(line string) (e manifestEntry, ok bool) {
+	e.Size = -1
+	escaped := strings.HasPrefix(line, "\\")
+	if escaped {
+		line = line[1:]
+	}
+	var sum, p string
+	if m := tagLine.FindStringSubmatch(line); m != nil {
+		for algo, name := range tagNames {
+			if strings.EqualFold(name, m[1]) {
+				e.Algo = algo
+			}
+		}
+		if e.Algo == "" {
+			return e, false
+		}
+		sum, p = m[3], m[2]
+	} else {
+		i := strings.Index(line, " ")
+		if i <= 0 || i+1 >= len(line) || (line[i+1] != ' ' && line[i+1] != '*') {
+			return e, false
+		}
+		sum, p = line[:i], line[i+2:]
+	}
+	b, err := hex.DecodeString(sum)
+	if err != nil || len(b) == 0 || p == "" {
+		return e, false
+	}
+	if escaped {
+		p = unescapeCoreutils(p)
+	}
+	e.Sum, e.Path = b, p
+	return e, true
+}
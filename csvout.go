@@ -0,0 +1,23 @@
+package main
+
+import (
+	"encoding/csv"
+)
+
+// csvFormat reports whether -format is csv or tsv.
+func csvFormat() bool {
+	return opts.format == "csv" || opts.format == "tsv"
+}
+
+// printCSVRecord writes one row for -format csv or tsv. Fields with the
+// separator, quotes or line breaks are quoted as RFC 4180 says, which
+// spreadsheets and most CSV readers expect, for tsv too.
+func printCSVRecord(record []string) error {
+	w := csv.NewWriter(stdout)
+	if opts.format == "tsv" {
+		w.Comma = '\t'
+	}
+	w.Write(record)
+	w.Flush()
+	return w.Error()
+}
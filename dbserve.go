@@ -0,0 +1,277 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// dbServer answers queries about the -db manifest table over HTTP for
+// -serve-db, so other tools can look up files without scanning, and
+// rescans subtrees below its roots on request.
+type dbServer struct {
+	db     *sql.DB    // Held open for the lifetime of the server.
+	roots  []string   // Rescans are only allowed below these.
+	rescan sync.Mutex // One rescan at a time.
+	ctx    context.Context
+}
+
+// dbRow is a row of the manifest table, see manifestSQL.
+type dbRow struct {
+	Path      string `json:"path"`
+	Hash      string `json:"hash"`
+	Size      int64  `json:"size"`
+	MTime     int64  `json:"mtime"`
+	FirstSeen int64  `json:"first_seen"`
+	LastSeen  int64  `json:"last_seen"`
+}
+
+// dbGroup is a set of paths with the same hash, for /dupes.
+type dbGroup struct {
+	Hash  string   `json:"hash"`
+	Size  int64    `json:"size"`
+	Paths []string `json:"paths"`
+}
+
+// rescanReport is the answer to a /rescan.
+type rescanReport struct {
+	Path    string   `json:"path"`
+	Files   int      `json:"files"`
+	New     int      `json:"new"`
+	Changed []string `json:"changed"`
+	Missing []string `json:"missing"`
+	Failed  []string `json:"failed"`
+}
+
+const dbColumns = "path, hash, size, mtime, first_seen, last_seen"
+
+// queryDB runs the manifest query with args and returns its rows.
+func queryDB(db *sql.DB, query string, args ...interface{}) ([]dbRow, error) {
+	res, err := db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Close()
+	rows := []dbRow{}
+	for res.Next() {
+		var row dbRow
+		if err := res.Scan(&row.Path, &row.Hash, &row.Size, &row.MTime, &row.FirstSeen, &row.LastSeen); err != nil {
+			return nil, err
+		}
+		rows = append(rows, row)
+	}
+	return rows, res.Err()
+}
+
+// runServeDB serves the database dbpath on addr until interrupted:
+//
+//	GET  /hash/SUM      the rows with that hash
+//	GET  /path?path=P   the row of the absolute path P
+//	GET  /dupes         the hashes with several paths, most wasted space first
+//	POST /rescan?path=D hashes the directory D, below one of roots, again
+//	                    and updates its rows, reporting what changed
+//
+// A rescan needs the GOSHA1_TOKEN secret as an "Authorization: Bearer"
+// header, which also keeps web pages from sending one, as that header
+// makes a cross-origin request need a preflight the server doesn't answer.
+// The GET lookups are unauthenticated.
+func runServeDB(addr, dbpath string, roots []string) error {
+	// Opening would create a missing database.
+	if _, err := os.Stat(dbpath); err != nil {
+		return err
+	}
+	db, err := openSQLite(dbpath)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	s := &dbServer{db: db}
+	for _, r := range roots {
+		abs, err := filepath.Abs(r)
+		if err == nil {
+			abs, err = filepath.EvalSymlinks(abs)
+		}
+		if err != nil {
+			return err
+		}
+		s.roots = append(s.roots, abs)
+	}
+	// Fail early if the database can't be read.
+	if _, err := queryDB(db, "SELECT "+dbColumns+" FROM manifest LIMIT 1"); err != nil {
+		return err
+	}
+	ln, err := net.Listen(listenNetwork(addr), addr)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := runContext()
+	defer cancel()
+	s.ctx = ctx
+	mux := http.NewServeMux()
+	mux.HandleFunc("/hash/", s.byHash)
+	mux.HandleFunc("/path", s.byPath)
+	mux.HandleFunc("/dupes", s.dupes)
+	mux.HandleFunc("/rescan", s.rescanTree)
+	srv := &http.Server{Handler: mux}
+	go func() {
+		<-ctx.Done()
+		srv.Close()
+	}()
+	log("Serving DB   :", dbpath, "on", ln.Addr())
+	err = srv.Serve(ln)
+	if ctx.Err() != nil {
+		return nil
+	}
+	return err
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+func httpError(w http.ResponseWriter, status int, err error) {
+	writeJSON(w, status, map[string]string{"error": err.Error()})
+}
+
+func (s *dbServer) byHash(w http.ResponseWriter, r *http.Request) {
+	sum := strings.ToLower(strings.TrimPrefix(r.URL.Path, "/hash/"))
+	if _, err := hex.DecodeString(sum); err != nil || sum == "" {
+		httpError(w, http.StatusBadRequest, fmt.Errorf("invalid hash %q", sum))
+		return
+	}
+	rows, err := queryDB(s.db, "SELECT "+dbColumns+" FROM manifest WHERE hash = ? ORDER BY path", sum)
+	if err != nil {
+		httpError(w, http.StatusInternalServerError, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, rows)
+}
+
+func (s *dbServer) byPath(w http.ResponseWriter, r *http.Request) {
+	p := r.URL.Query().Get("path")
+	if !filepath.IsAbs(p) {
+		httpError(w, http.StatusBadRequest, fmt.Errorf("path %q isn't absolute", p))
+		return
+	}
+	rows, err := queryDB(s.db, "SELECT "+dbColumns+" FROM manifest WHERE path = ?", filepath.Clean(p))
+	if err != nil {
+		httpError(w, http.StatusInternalServerError, err)
+		return
+	}
+	if len(rows) == 0 {
+		httpError(w, http.StatusNotFound, fmt.Errorf("%s isn't in the database", p))
+		return
+	}
+	writeJSON(w, http.StatusOK, rows[0])
+}
+
+func (s *dbServer) dupes(w http.ResponseWriter, r *http.Request) {
+	rows, err := queryDB(s.db, "SELECT "+dbColumns+" FROM manifest WHERE hash IN "+
+		"(SELECT hash FROM manifest GROUP BY hash HAVING COUNT(*) > 1) ORDER BY hash, path")
+	if err != nil {
+		httpError(w, http.StatusInternalServerError, err)
+		return
+	}
+	groups := []dbGroup{}
+	for _, row := range rows {
+		if n := len(groups); n == 0 || groups[n-1].Hash != row.Hash {
+			groups = append(groups, dbGroup{Hash: row.Hash, Size: row.Size})
+		}
+		g := &groups[len(groups)-1]
+		g.Paths = append(g.Paths, row.Path)
+	}
+	// Most wasted space first, then by hash as queried.
+	wasted := func(g dbGroup) int64 { return g.Size * int64(len(g.Paths)-1) }
+	sort.SliceStable(groups, func(i, j int) bool { return wasted(groups[i]) > wasted(groups[j]) })
+	writeJSON(w, http.StatusOK, groups)
+}
+
+func (s *dbServer) rescanTree(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		httpError(w, http.StatusMethodNotAllowed, fmt.Errorf("a rescan needs POST"))
+		return
+	}
+	if !tokenOK(strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")) {
+		httpError(w, http.StatusUnauthorized, fmt.Errorf("a rescan needs the %s secret as a bearer token", tokenEnv))
+		return
+	}
+	dir := r.URL.Query().Get("path")
+	if !filepath.IsAbs(dir) || !isDir(dir) {
+		httpError(w, http.StatusBadRequest, fmt.Errorf("path %q isn't an absolute directory", dir))
+		return
+	}
+	// The resolved path is the one scanned, a symlink swapped after the
+	// check can't lead the rescan outside the roots.
+	real, err := filepath.EvalSymlinks(filepath.Clean(dir))
+	if err != nil || !belowRoots(real, s.roots) {
+		httpError(w, http.StatusForbidden, fmt.Errorf("%s isn't below the roots given to -serve-db", dir))
+		return
+	}
+	s.rescan.Lock()
+	defer s.rescan.Unlock()
+	rep, err := s.rescanDir(real)
+	if err != nil {
+		httpError(w, http.StatusInternalServerError, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, rep)
+}
+
+// rescanDir hashes dir, compares it with its rows and upserts the results.
+// Hard links take the sum of the link that was hashed, collect resolves
+// them before the diff. Rows of files that are gone are reported as missing
+// but kept, their last_seen tells how long ago they were.
+func (s *dbServer) rescanDir(dir string) (*rescanReport, error) {
+	// The paths below dir sort between dir and its separator and the next
+	// byte.
+	base := strings.TrimSuffix(dir, string(filepath.Separator))
+	old, err := queryDB(s.db, "SELECT "+dbColumns+" FROM manifest WHERE path > ? AND path < ?",
+		base+string(filepath.Separator), base+string(filepath.Separator+1))
+	if err != nil {
+		return nil, err
+	}
+	logVerbose("Rescanning   :", dir)
+	var failed []result
+	rs, err := collect(produceConcurrent(s.ctx, hashSpec{algos: opts.algos[:1]}, walkRoots([]string{dir}, nil)), nil, &failed)
+	if err != nil {
+		return nil, err
+	}
+	rep := &rescanReport{Path: dir, Files: len(rs), Changed: []string{}, Missing: []string{}, Failed: []string{}}
+	before := make(map[string]string, len(old))
+	for _, row := range old {
+		before[row.Path] = row.Hash
+	}
+	seen := make(map[string]bool, len(rs))
+	for _, r := range rs {
+		p, _ := filepath.Abs(r.Path)
+		seen[p] = true
+		if h, ok := before[p]; !ok {
+			rep.New++
+		} else if h != hex.EncodeToString(r.Sum) {
+			rep.Changed = append(rep.Changed, p)
+		}
+	}
+	for _, row := range old {
+		if !seen[row.Path] {
+			rep.Missing = append(rep.Missing, row.Path)
+		}
+	}
+	for _, r := range failed {
+		rep.Failed = append(rep.Failed, r.Err.Error())
+	}
+	if err := upsertManifest(s.db, rs); err != nil {
+		return nil, err
+	}
+	return rep, nil
+}
@@ -0,0 +1,234 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// dedupAction is what -link-dupes and -delete-dupes do to a redundant copy.
+type dedupAction struct {
+	name        string // LINK, REFLINK or DELETE in the action log.
+	done, would string // Stats labels after acting and with -dry-run.
+	apply       func(keep, path string) error
+}
+
+var (
+	linkAction    = dedupAction{"LINK", "Linked       :", "Would link   :", replaceWithLink}
+	reflinkAction = dedupAction{"REFLINK", "Reflinked    :", "Would reflink:", replaceWithClone}
+	// The kept file is only there to be checked against, deleting doesn't
+	// need it.
+	deleteAction = dedupAction{"DELETE", "Deleted      :", "Would delete :",
+		func(keep, path string) error { return os.Remove(path) }}
+)
+
+// linkDupes replaces the redundant copies in each duplicate group (see
+// planGroup) with hard links to the kept file.
+func linkDupes(basepath string, rs resultSlice) error {
+	return dedupGroups(basepath, rs, linkAction)
+}
+
+// reflinkDupes replaces the redundant copies in each duplicate group with
+// copy-on-write clones of the kept file. They share its storage but stay
+// independent files, writing to one doesn't change the others.
+func reflinkDupes(basepath string, rs resultSlice) error {
+	return dedupGroups(basepath, rs, reflinkAction)
+}
+
+// deleteDupes deletes the redundant copies in each duplicate group.
+func deleteDupes(basepath string, rs resultSlice) error {
+	return dedupGroups(basepath, rs, deleteAction)
+}
+
+// dedupGroups applies a to the redundant copies in each duplicate group, or
+// with -dry-run only prints what it would do. With -confirm each group is
+// shown on stderr and only acted on if the answer on stdin is yes. Each
+// action is logged on stdout as "ACTION<TAB>size<TAB>path<TAB>kept path".
+// Files that changed since they were hashed, aren't regular files, are
+// the kept file under another name or are outside the scan roots are
+// skipped. With -trash the copies are kept there for -undo. A group with
+// copies makes the run exit with exitFound.
+func dedupGroups(basepath string, rs resultSlice, a dedupAction) error {
+	name := a.name
+	if opts.dryRun {
+		name = "WOULD-" + a.name
+	}
+	if !opts.dryRun {
+		if err := openTrash(); err != nil {
+			return err
+		}
+	}
+	var in *bufio.Reader
+	if opts.confirm {
+		in = bufio.NewReader(os.Stdin)
+	}
+	all := false
+	var n, failed int
+	var reclaim int64
+	for _, g := range reportedGroups(rs) {
+		plan, err := planGroup(g)
+		if err != nil {
+			return err
+		}
+		keep := plan.Keep
+		k := relPath(basepath, keep.Path)
+		found = found || len(plan.Remove) > 0
+		if in != nil && !all && len(plan.Remove) > 0 {
+			answer := confirmGroup(in, basepath, a, plan)
+			if answer == "q" {
+				break
+			}
+			if answer == "n" {
+				for _, r := range plan.Remove {
+					printLine("SKIP\t%d\t%s\t%s: declined", r.Size, relPath(basepath, r.Path), k)
+				}
+				continue
+			}
+			all = answer == "a"
+		}
+		for _, r := range plan.Remove {
+			p := relPath(basepath, r.Path)
+			skip, err := dedupTarget(keep, r)
+			if err != nil {
+				logWarning(err)
+				failed++
+				continue
+			}
+			if skip != "" {
+				printLine("SKIP\t%d\t%s\t%s: %s", r.Size, p, k, skip)
+				continue
+			}
+			if !opts.dryRun {
+				if err := applyAction(a, keep.Path, r.Path); err != nil {
+					logWarning(err)
+					failed++
+					continue
+				}
+			}
+			printLine("%s\t%d\t%s\t%s", name, r.Size, p, k)
+			n++
+			reclaim += r.Size
+		}
+	}
+	if opts.dryRun {
+		log(a.would, n)
+		if !flagGiven("dry-run") {
+			log("Dry run, give -dry-run=false or -confirm to " + strings.ToLower(a.name))
+		}
+	} else {
+		log(a.done, n)
+	}
+	log("Reclaim MB   :", float64(reclaim)/1024/1024)
+	if failed > 0 {
+		return fmt.Errorf("%d duplicates could not be handled (%s)", failed, strings.ToLower(a.name))
+	}
+	return nil
+}
+
+// confirmGroup asks whether to act on a group: y(es), n(o), a(ll remaining
+// groups) or q(uit). Anything else counts as no, EOF as quit.
+func confirmGroup(in *bufio.Reader, basepath string, a dedupAction, plan keepPlan) string {
+	fmt.Fprintf(stderr, "KEEP\t%s\n", relPath(basepath, plan.Keep.Path))
+	for _, r := range plan.Remove {
+		fmt.Fprintf(stderr, "%s\t%s\n", a.name, relPath(basepath, r.Path))
+	}
+	fmt.Fprintf(stderr, "%s %d copies? [y/N/a/q] ", strings.ToLower(a.name), len(plan.Remove))
+	line, err := in.ReadString('\n')
+	if err != nil && line == "" {
+		fmt.Fprintln(stderr)
+		return "q"
+	}
+	switch strings.ToLower(strings.TrimSpace(line)) {
+	case "y", "yes":
+		return "y"
+	case "a", "all":
+		return "a"
+	case "q", "quit":
+		return "q"
+	}
+	return "n"
+}
+
+// dedupTarget checks that r can still be replaced or deleted in favour of
+// keep. It returns why r is skipped, or an empty string if it can go.
+func dedupTarget(keep, r *result) (string, error) {
+	kfi, err := os.Lstat(keep.Path)
+	if err != nil {
+		return "", err
+	}
+	fi, err := os.Lstat(r.Path)
+	if err != nil {
+		return "", err
+	}
+	if outsideRoots(r.Path) {
+		return "outside the scan roots", nil
+	}
+	if !kfi.Mode().IsRegular() || !fi.Mode().IsRegular() {
+		return "not a regular file", nil
+	}
+	if os.SameFile(kfi, fi) {
+		return "same file as the kept one", nil
+	}
+	if changedSince(kfi, keep) || changedSince(fi, r) {
+		return "changed since it was hashed", nil
+	}
+	return "", nil
+}
+
+func changedSince(fi os.FileInfo, r *result) bool {
+	return fi.Size() != r.Size || !fi.ModTime().Equal(r.ModTime)
+}
+
+// replaceWithLink makes a hard link to keep next to path and renames it over
+// path, so path is never missing. Linking fails across file systems.
+func replaceWithLink(keep, path string) error {
+	tmp := filepath.Join(filepath.Dir(path), "."+filepath.Base(path)+".gosha1-link")
+	if err := os.Link(keep, tmp); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return nil
+}
+
+// replaceWithClone clones keep into a new file next to path with the mode
+// and mtime of path and renames it over path. Cloning fails on file systems
+// without reflinks and across file systems.
+func replaceWithClone(keep, path string) error {
+	fi, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+	src, err := os.Open(keep)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+	tmp := filepath.Join(filepath.Dir(path), "."+filepath.Base(path)+".gosha1-clone")
+	dst, err := os.OpenFile(tmp, os.O_WRONLY|os.O_CREATE|os.O_EXCL, fi.Mode().Perm())
+	if err != nil {
+		return err
+	}
+	err = cloneFile(dst, src)
+	if cerr := dst.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Chmod(tmp, fi.Mode().Perm())
+	}
+	if err == nil {
+		err = os.Chtimes(tmp, fi.ModTime(), fi.ModTime())
+	}
+	if err == nil {
+		err = os.Rename(tmp, path)
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return fmt.Errorf("%s: %v", path, err)
+	}
+	return nil
+}
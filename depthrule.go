@@ -0,0 +1,94 @@
+package main
+
+import (
+	"fmt"
+	"path"
+	"strconv"
+	"strings"
+)
+
+// depthRule limits how deep the walker goes below directories matching
+// Pattern: Max 1 hashes only the files directly in them, 0 skips them.
+// A pattern without a slash matches directory names anywhere, one with a
+// slash matches the path relative to the root.
+type depthRule struct {
+	Pattern string
+	Max     int
+}
+
+// depthRules is a repeatable -depth-rule PATTERN=N flag.
+type depthRules []depthRule
+
+func (d *depthRules) String() string {
+	var s []string
+	for _, r := range *d {
+		s = append(s, fmt.Sprintf("%s=%d", r.Pattern, r.Max))
+	}
+	return strings.Join(s, ",")
+}
+
+func (d *depthRules) Set(s string) error {
+	i := strings.LastIndex(s, "=")
+	if i <= 0 {
+		return fmt.Errorf("expected PATTERN=N, got %q", s)
+	}
+	pattern := strings.Trim(s[:i], "/")
+	n, err := strconv.Atoi(s[i+1:])
+	if err != nil || n < 0 {
+		return fmt.Errorf("invalid depth in %q", s)
+	}
+	if _, err := path.Match(pattern, ""); err != nil {
+		return fmt.Errorf("invalid pattern in %q: %v", s, err)
+	}
+	*d = append(*d, depthRule{pattern, n})
+	return nil
+}
+
+func (r depthRule) matches(rel string) bool {
+	name := rel
+	if !strings.Contains(r.Pattern, "/") {
+		name = path.Base(rel)
+	}
+	ok, _ := path.Match(r.Pattern, name)
+	return ok
+}
+
+// match returns the most specific rule for the directory rel (slash
+// separated, relative to the root), the longest matching pattern.
+func (d depthRules) match(rel string) (depthRule, bool) {
+	var best depthRule
+	found := false
+	for _, r := range d {
+		if r.matches(rel) && (!found || len(r.Pattern) > len(best.Pattern)) {
+			best = r
+			found = true
+		}
+	}
+	return best, found
+}
+
+// mayMatchBelow reports whether a slash pattern could match a directory
+// below rel, so the walker has to descend even past the current limit.
+func (d depthRules) mayMatchBelow(rel string) bool {
+	segs := strings.Split(rel, "/")
+	for _, r := range d {
+		if !strings.Contains(r.Pattern, "/") {
+			return true
+		}
+		pat := strings.Split(r.Pattern, "/")
+		if len(pat) <= len(segs) {
+			continue
+		}
+		prefix := true
+		for i, s := range segs {
+			if ok, _ := path.Match(pat[i], s); !ok {
+				prefix = false
+				break
+			}
+		}
+		if prefix {
+			return true
+		}
+	}
+	return false
+}
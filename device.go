@@ -0,0 +1,71 @@
+package main
+
+import (
+	"context"
+	"github.com/rajder/gosha1/hashwalk"
+	"os"
+	"strings"
+	"time"
+)
+
+// isStream reports whether path is read as a single stream instead of
+// walked: "-" for stdin, a block or character device or a named pipe, e.g.
+// from process substitution (<(cmd) is /dev/fd/63).
+func isStream(path string) bool {
+	if path == "-" {
+		return true
+	}
+	fi, err := os.Stat(path)
+	return err == nil && fi.Mode()&(os.ModeDevice|os.ModeNamedPipe) != 0
+}
+
+// allStreams reports whether every path is a stream.
+func allStreams(paths []string) bool {
+	for _, p := range paths {
+		if !isStream(p) {
+			return false
+		}
+	}
+	return len(paths) > 0
+}
+
+// processStreams hashes each stream, e.g. /dev/sdb or stdin, by reading it
+// to EOF. Streams report no useful size up front, so the size printed is
+// the number of bytes read, named by the "# fields:" header like any other
+// column.
+func processStreams(paths []string) error {
+	ctx, cancel := runContext()
+	defer cancel()
+	printAlgosHeader()
+	var total int64
+	for _, path := range paths {
+		var modTime time.Time
+		var sums [][]byte
+		var size int64
+		var err error
+		if path == "-" {
+			sums, size, err = withTimeout(ctx, path, func(ctx context.Context) ([][]byte, int64, error) {
+				return hashwalk.SumReaderContext(ctx, limitRead(ctx, os.Stdin), opts.algos, nil)
+			})
+		} else {
+			var fi os.FileInfo
+			fi, err = os.Stat(path)
+			if err != nil {
+				return err
+			}
+			modTime = fi.ModTime()
+			sums, size, err = calcSumsTimeout(ctx, path, opts.algos, nil)
+		}
+		if serr := stopError(ctx, err); serr != nil {
+			return serr
+		}
+		if err != nil {
+			return err
+		}
+		r := result{Path: path, Sum: sums[0], Sums: sums, Size: size, ModTime: modTime}
+		printLine("%s", strings.Join(formatFields(opts.fields, &r, path), "\t"))
+		total += size
+	}
+	log("Total MB     :", float64(total)/1024/1024)
+	return nil
+}
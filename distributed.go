@@ -0,0 +1,261 @@
+package main
+
+import (
+	"context"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"github.com/rajder/gosha1/hashwalk"
+	"net"
+	"net/rpc"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Distributed hashing: with -workers the walk runs here and each file is
+// hashed by one of the agents started with -serve on hosts close to the
+// storage, which see the same paths, e.g. on the nodes of a NAS cluster.
+// Only the sums cross the network. The agents speak net/rpc, gRPC isn't in
+// the standard library. Every call carries the shared secret from
+// GOSHA1_TOKEN, which agents listening on TCP require.
+
+// Agent is the net/rpc service of -serve.
+type Agent struct {
+	roots []string // Only files below these are hashed.
+	slots chan struct{}
+	auth  bool // Require the shared secret.
+}
+
+// tokenEnv names the environment variable with the shared secret of the
+// agents, the coordinators and the POST /rescan calls of -serve-db.
+const tokenEnv = "GOSHA1_TOKEN"
+
+func sharedToken() string {
+	return os.Getenv(tokenEnv)
+}
+
+// tokenOK reports whether got is the shared secret, which must be set.
+func tokenOK(got string) bool {
+	want := sharedToken()
+	return want != "" && subtle.ConstantTimeCompare([]byte(got), []byte(want)) == 1
+}
+
+// InfoArgs asks an agent for its AgentInfo.
+type InfoArgs struct {
+	Token string
+}
+
+// AgentInfo describes an agent to the coordinator.
+type AgentInfo struct {
+	Jobs int // Files the agent hashes at the same time.
+}
+
+// HashArgs asks an agent to hash the file at Path.
+type HashArgs struct {
+	Path  string
+	Algos []string
+	Token string
+}
+
+// HashReply is the result of HashArgs, Err is set instead of returning an
+// error so that the coordinator can tell file errors from broken agents.
+type HashReply struct {
+	Sums [][]byte
+	Size int64
+	Err  string
+}
+
+// Info returns the number of files the agent hashes at the same time.
+func (a *Agent) Info(args InfoArgs, info *AgentInfo) error {
+	if err := a.check(args.Token); err != nil {
+		return err
+	}
+	info.Jobs = cap(a.slots)
+	return nil
+}
+
+// Hash hashes a file below the agent's roots. It opens the path with the
+// symlinks resolved, the one checked against the roots, so a link swapped
+// in meanwhile can't lead outside them.
+func (a *Agent) Hash(args HashArgs, reply *HashReply) error {
+	if err := a.check(args.Token); err != nil {
+		return err
+	}
+	real, ok := a.allowed(args.Path)
+	if !ok {
+		return fmt.Errorf("%s isn't below the roots this agent serves", args.Path)
+	}
+	if _, err := hashwalk.ParseAlgorithms(strings.Join(args.Algos, ",")); err != nil {
+		return err
+	}
+	a.slots <- struct{}{}
+	defer func() { <-a.slots }()
+	logDebug("agent hashing %s", args.Path)
+	sums, size, err := calcSumsTimeout(context.Background(), real, args.Algos, nil)
+	reply.Sums, reply.Size = sums, size
+	if err != nil {
+		reply.Err = err.Error()
+	}
+	return nil
+}
+
+// check returns an error unless the agent takes calls without the secret
+// or token is it.
+func (a *Agent) check(token string) error {
+	if a.auth && !tokenOK(token) {
+		return errors.New("wrong or missing " + tokenEnv)
+	}
+	return nil
+}
+
+// allowed returns p with symlinks, which could point anywhere, resolved and
+// whether that is below one of the roots.
+func (a *Agent) allowed(p string) (string, bool) {
+	p, err := filepath.EvalSymlinks(p)
+	if err != nil {
+		return "", false
+	}
+	return p, belowRoots(p, a.roots)
+}
+
+// belowRoots reports whether the path p, with symlinks resolved, is one of
+// the roots or below one.
+func belowRoots(p string, roots []string) bool {
+	for _, root := range roots {
+		if isUnder(p, root) {
+			return true
+		}
+	}
+	return false
+}
+
+// runServe serves the files below roots to -workers coordinators until
+// interrupted. On TCP the calls need the shared secret, a unix socket is
+// guarded by its file permissions.
+func runServe(addr string, roots []string) error {
+	a := &Agent{slots: make(chan struct{}, workerCount()), auth: listenNetwork(addr) == "tcp"}
+	for _, r := range roots {
+		abs, err := filepath.Abs(r)
+		if err == nil {
+			abs, err = filepath.EvalSymlinks(abs)
+		}
+		if err != nil {
+			return err
+		}
+		a.roots = append(a.roots, abs)
+	}
+	srv := rpc.NewServer()
+	if err := srv.Register(a); err != nil {
+		return err
+	}
+	ln, err := net.Listen(listenNetwork(addr), addr)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := runContext()
+	defer cancel()
+	go func() {
+		<-ctx.Done()
+		ln.Close()
+	}()
+	log("Serving      :", strings.Join(a.roots, ", "), "on", ln.Addr(), "with", workerCount(), "jobs")
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			return err
+		}
+		logVerbose("Coordinator  :", conn.RemoteAddr())
+		go srv.ServeConn(conn)
+	}
+}
+
+// agentPool hands out a slot per file an agent hashes at the same time, so
+// the faster agents get more of the files.
+type agentPool struct {
+	slots chan *agentConn
+}
+
+type agentConn struct {
+	addr   string
+	client *rpc.Client
+}
+
+// agents is nil without -workers.
+var agents *agentPool
+
+// dialAgents connects to the -workers agents.
+func dialAgents(addrs []string) (*agentPool, error) {
+	var conns []*agentConn
+	var jobs []int
+	for _, addr := range addrs {
+		client, err := rpc.Dial(listenNetwork(addr), addr)
+		if err != nil {
+			return nil, fmt.Errorf("agent %s: %v", addr, err)
+		}
+		var info AgentInfo
+		if err := client.Call("Agent.Info", InfoArgs{sharedToken()}, &info); err != nil {
+			return nil, fmt.Errorf("agent %s: %v", addr, err)
+		}
+		if info.Jobs < 1 {
+			info.Jobs = 1
+		}
+		conns = append(conns, &agentConn{addr, client})
+		jobs = append(jobs, info.Jobs)
+		log("Agent        :", addr, "with", info.Jobs, "jobs")
+	}
+	total := 0
+	for _, n := range jobs {
+		total += n
+	}
+	p := &agentPool{slots: make(chan *agentConn, total)}
+	// Interleave the slots so the first files spread over all agents.
+	for more := true; more; {
+		more = false
+		for i, c := range conns {
+			if jobs[i] > 0 {
+				p.slots <- c
+				jobs[i]--
+				more = true
+			}
+		}
+	}
+	return p, nil
+}
+
+// size is the number of files all agents hash at the same time.
+func (p *agentPool) size() int {
+	return cap(p.slots)
+}
+
+// hash has the file at path hashed by the next free agent.
+func (p *agentPool) hash(ctx context.Context, path string, algos []string) ([][]byte, int64, error) {
+	var c *agentConn
+	select {
+	case c = <-p.slots:
+	case <-ctx.Done():
+		return nil, 0, ctx.Err()
+	}
+	defer func() { p.slots <- c }()
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return nil, 0, err
+	}
+	var reply HashReply
+	call := c.client.Go("Agent.Hash", HashArgs{abs, algos, sharedToken()}, &reply, make(chan *rpc.Call, 1))
+	select {
+	case <-call.Done:
+	case <-ctx.Done():
+		return nil, 0, ctx.Err()
+	}
+	if call.Error != nil {
+		return nil, 0, fmt.Errorf("agent %s: %v", c.addr, call.Error)
+	}
+	if reply.Err != "" {
+		return nil, 0, errors.New(reply.Err)
+	}
+	return reply.Sums, reply.Size, nil
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// dupeDir is a directory with its -tree-digest rollup sum, which only
+// matches for directories with the same files by sum and relative path.
+type dupeDir struct {
+	dirDigest
+	size  int64
+	files int
+}
+
+// dupeDirGroup is a set of directories with equal digests.
+type dupeDirGroup struct {
+	dirs []dupeDir
+	// Bytes beyond one copy, without those already counted for the copies
+	// of a parent directory.
+	wasted int64
+}
+
+// dupeDirGroups returns the sets of directories with equal digests, most
+// wasted bytes first. Subdirectories of duplicated directories are left
+// out, unless they have a copy elsewhere, so a copied tree is one group.
+// Directories holding only empty files are no waste and left out too.
+func dupeDirGroups(basepath string, rs resultSlice) []dupeDirGroup {
+	sizes := make(map[string]int64)
+	files := make(map[string]int)
+	for _, r := range rs {
+		p := filepath.ToSlash(relPath(basepath, r.Path))
+		for d := pathDir(p); ; d = pathDir(d) {
+			sizes[d] += r.Size
+			files[d]++
+			if pathDir(d) == d {
+				break
+			}
+		}
+	}
+	bySum := make(map[string][]dupeDir)
+	for _, d := range treeDigests(basepath, rs) {
+		k := string(d.Sum)
+		bySum[k] = append(bySum[k], dupeDir{d, sizes[d.Path], files[d.Path]})
+	}
+	duplicated := make(map[string]bool)
+	for _, g := range bySum {
+		if len(g) > 1 {
+			for _, d := range g {
+				duplicated[d.Path] = true
+			}
+		}
+	}
+	var groups []dupeDirGroup
+	for _, g := range bySum {
+		if len(g) < 2 || g[0].size == 0 {
+			continue
+		}
+		inside := 0
+		for _, d := range g {
+			if parent := pathDir(d.Path); parent != d.Path && duplicated[parent] {
+				inside++
+			}
+		}
+		if inside == len(g) {
+			continue
+		}
+		sort.Slice(g, func(i, j int) bool { return g[i].Path < g[j].Path })
+		copies := len(g) - 1
+		if inside > 0 {
+			// The parents' group already counts all but one of the copies
+			// inside.
+			copies = len(g) - inside
+		}
+		groups = append(groups, dupeDirGroup{g, g[0].size * int64(copies)})
+	}
+	sort.Slice(groups, func(i, j int) bool {
+		if groups[i].wasted != groups[j].wasted {
+			return groups[i].wasted > groups[j].wasted
+		}
+		return groups[i].dirs[0].Path < groups[j].dirs[0].Path
+	})
+	return groups
+}
+
+// printDupeDirs prints each set of duplicated directories as a header line
+// with the digest, number of copies, wasted bytes, size and file count of
+// one copy, followed by one indented line per directory.
+func printDupeDirs(basepath string, rs resultSlice) {
+	groups := dupeDirGroups(basepath, rs)
+	found = found || len(groups) > 0
+	var total int64
+	for _, g := range groups {
+		d := g.dirs[0]
+		printLine("%x\t%d copies\twasted %d\tsize %d\t%d files", d.Sum, len(g.dirs), g.wasted, d.size, d.files)
+		for _, d := range g.dirs {
+			p := d.Path
+			if !strings.HasSuffix(p, "/") {
+				p += "/"
+			}
+			printLine("\t%s", p)
+		}
+		total += g.wasted
+	}
+	log("Dupe dirs    :", len(groups), "sets of directories")
+	log("Wasted MB    :", float64(total)/1024/1024)
+	printSummary(rs)
+}
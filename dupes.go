@@ -0,0 +1,173 @@
+package main
+
+import (
+	"bytes"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+// duplicateGroups returns the runs of equal sums in the sorted rs that have
+// more than one member.
+func duplicateGroups(rs resultSlice) []resultSlice {
+	var groups []resultSlice
+	for i := 0; i < len(rs); {
+		j := i + 1
+		for j < len(rs) && bytes.Equal(rs[i].Sum, rs[j].Sum) {
+			j++
+		}
+		if j-i > 1 {
+			groups = append(groups, rs[i:j])
+		}
+		i = j
+	}
+	return groups
+}
+
+// reportedGroups returns the duplicate groups of the sorted rs that
+// -dupes and -keep-under report, without the -ignore-dupes-file copies,
+// filtered by -cross-dir-only, cut to the -top most wasteful and ordered
+// by -sort.
+func reportedGroups(rs resultSlice) []resultSlice {
+	groups := duplicateGroups(rs)
+	if intendedDupes != nil {
+		groups = withoutIntended(groups)
+	}
+	if opts.detectCollisions {
+		groups = collisionFreeGroups(groups)
+	}
+	if opts.paranoid {
+		groups = confirmGroups(groups)
+	}
+	if opts.crossDirOnly {
+		groups = crossDirGroups(groups)
+	}
+	by := opts.sort
+	if by == "" && (opts.dupes || opts.top > 0) {
+		by = "wasted"
+	}
+	if opts.top > 0 && len(groups) > opts.top {
+		sortGroups(groups, "wasted")
+		log("Top groups   :", opts.top, "of", len(groups))
+		groups = groups[:opts.top]
+	}
+	sortGroups(groups, by)
+	return groups
+}
+
+// confirmGroups compares the files of each group byte by byte for
+// -paranoid, splitting it into the sets that really are the same and
+// dropping files that can't be read, so nothing is linked or deleted on the
+// word of the sum alone.
+func confirmGroups(groups []resultSlice) []resultSlice {
+	var out []resultSlice
+	var files, differ, unread int
+	for _, g := range groups {
+		var sets []resultSlice
+	members:
+		for _, r := range g {
+			files++
+			for i, set := range sets {
+				same, err := sameContent(set[0].Path, r.Path)
+				if err != nil {
+					logWarning(err)
+					unread++
+					continue members
+				}
+				if same {
+					sets[i] = append(set, r)
+					continue members
+				}
+			}
+			if len(sets) > 0 {
+				differ++
+			}
+			sets = append(sets, resultSlice{r})
+		}
+		for _, set := range sets {
+			if len(set) > 1 {
+				out = append(out, set)
+			}
+		}
+	}
+	log("Paranoid     :", files, "files in", len(groups), "groups compared byte by byte")
+	if differ > 0 {
+		log("Collisions   :", differ, "files differ from others with the same sum, left out")
+	}
+	if unread > 0 {
+		log("Unconfirmed  :", unread, "files couldn't be read back, left out")
+	}
+	return out
+}
+
+// crossDirGroups drops the groups whose copies all live in one directory.
+func crossDirGroups(groups []resultSlice) []resultSlice {
+	var keep []resultSlice
+	for _, g := range groups {
+		if spansDirs(g) {
+			keep = append(keep, g)
+		}
+	}
+	log("Same dir     :", len(groups)-len(keep), "groups left out")
+	return keep
+}
+
+func spansDirs(group resultSlice) bool {
+	dir := filepath.Dir(group[0].Path)
+	for _, r := range group[1:] {
+		if filepath.Dir(r.Path) != dir {
+			return true
+		}
+	}
+	return false
+}
+
+// sortGroups orders duplicate groups for -sort: by sum (as they come), by
+// number of copies or by wasted bytes, most first.
+func sortGroups(groups []resultSlice, by string) {
+	switch by {
+	case "copies":
+		sort.SliceStable(groups, func(i, j int) bool {
+			return len(groups[i]) > len(groups[j])
+		})
+	case "wasted":
+		sort.SliceStable(groups, func(i, j int) bool {
+			return wasted(groups[i]) > wasted(groups[j])
+		})
+	}
+}
+
+// wasted returns the bytes taken by the copies beyond the first.
+func wasted(group resultSlice) int64 {
+	return group[0].Size * int64(len(group)-1)
+}
+
+// mtimeSpan returns the oldest and newest modification time in group.
+func mtimeSpan(group resultSlice) (oldest, newest time.Time) {
+	for i, r := range group {
+		if i == 0 || r.ModTime.Before(oldest) {
+			oldest = r.ModTime
+		}
+		if i == 0 || r.ModTime.After(newest) {
+			newest = r.ModTime
+		}
+	}
+	return
+}
+
+// printDupes prints each duplicate group as a header line with the sum,
+// number of copies, wasted bytes and the mtime span, followed by one
+// indented line per file.
+func printDupes(basepath string, rs resultSlice) {
+	groups := reportedGroups(rs)
+	found = found || len(groups) > 0
+	for _, g := range groups {
+		oldest, newest := mtimeSpan(g)
+		printLine("%x\t%d copies\twasted %d\toldest %s\tnewest %s", g[0].Sum, len(g),
+			wasted(g), oldest.UTC().Format(time.RFC3339), newest.UTC().Format(time.RFC3339))
+		for _, r := range g {
+			printLine("\t%s", relPath(basepath, r.Path))
+		}
+	}
+	printSummary(rs)
+}
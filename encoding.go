@@ -0,0 +1,153 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"strings"
+)
+
+// sumEncodings are the ways -encoding prints sums: hex as the sum tools
+// do, multihash for content addressed stores like IPFS, and sri for the
+// integrity attribute of web pages.
+var sumEncodings = []string{"hex", "multihash", "sri"}
+
+// multihashCodes are the multicodec codes of the algorithms that have one,
+// blake2b being blake2b-512.
+var multihashCodes = map[string]uint64{
+	"blake2b": 0xb240,
+	"blake3":  0x1e,
+	"md5":     0xd5,
+	"sha1":    0x11,
+	"sha256":  0x12,
+	"sha512":  0x13,
+}
+
+// sriAlgos are the algorithms Subresource Integrity allows, sha384 being
+// the third.
+var sriAlgos = map[string]bool{"sha256": true, "sha512": true}
+
+const base58Alphabet = "123456789ABCDEFGHJKLMNPQRSTUVWXYZabcdefghijkmnopqrstuvwxyz"
+
+// base58 encodes b in the base58btc alphabet of Bitcoin and IPFS, with a 1
+// for each leading zero byte.
+func base58(b []byte) string {
+	zeros := 0
+	for zeros < len(b) && b[zeros] == 0 {
+		zeros++
+	}
+	// Digits in base 58, least significant first.
+	var digits []byte
+	for _, c := range b[zeros:] {
+		carry := int(c)
+		for i := range digits {
+			carry += int(digits[i]) << 8
+			digits[i] = byte(carry % 58)
+			carry /= 58
+		}
+		for carry > 0 {
+			digits = append(digits, byte(carry%58))
+			carry /= 58
+		}
+	}
+	out := make([]byte, zeros, zeros+len(digits))
+	for i := range out {
+		out[i] = '1'
+	}
+	for i := len(digits) - 1; i >= 0; i-- {
+		out = append(out, base58Alphabet[digits[i]])
+	}
+	return string(out)
+}
+
+func unbase58(s string) ([]byte, bool) {
+	zeros := 0
+	for zeros < len(s) && s[zeros] == '1' {
+		zeros++
+	}
+	// Bytes, least significant first.
+	var num []byte
+	for i := zeros; i < len(s); i++ {
+		d := strings.IndexByte(base58Alphabet, s[i])
+		if d < 0 {
+			return nil, false
+		}
+		carry := d
+		for j := range num {
+			carry += int(num[j]) * 58
+			num[j] = byte(carry)
+			carry >>= 8
+		}
+		for carry > 0 {
+			num = append(num, byte(carry))
+			carry >>= 8
+		}
+	}
+	out := make([]byte, zeros, zeros+len(num))
+	for i := len(num) - 1; i >= 0; i-- {
+		out = append(out, num[i])
+	}
+	return out, true
+}
+
+// encodeSum prints sum, of algo, in the -encoding: hex, a base58btc
+// multihash (the code of algo, the length and the sum, as in the Qm...
+// names of IPFS) or an SRI string like sha256-<base64>.
+func encodeSum(algo string, sum []byte) string {
+	switch opts.sumEncoding {
+	case "multihash":
+		b := binary.AppendUvarint(nil, multihashCodes[algo])
+		b = binary.AppendUvarint(b, uint64(len(sum)))
+		return base58(append(b, sum...))
+	case "sri":
+		return algo + "-" + base64.StdEncoding.EncodeToString(sum)
+	}
+	return hex.EncodeToString(sum)
+}
+
+// decodeSum reads a sum printed with any -encoding, and the algorithm for
+// those that name it. ok is false if s is none of them.
+func decodeSum(s string) (algo string, sum []byte, ok bool) {
+	if b, err := hex.DecodeString(s); err == nil && len(b) > 0 {
+		return "", b, true
+	}
+	if i := strings.IndexByte(s, '-'); i > 0 && sriAlgos[s[:i]] {
+		b, err := base64.StdEncoding.DecodeString(s[i+1:])
+		if err != nil || len(b) == 0 {
+			return "", nil, false
+		}
+		return s[:i], b, true
+	}
+	b, ok := unbase58(s)
+	if !ok {
+		return "", nil, false
+	}
+	code, n := binary.Uvarint(b)
+	if n <= 0 {
+		return "", nil, false
+	}
+	size, m := binary.Uvarint(b[n:])
+	if m <= 0 || size == 0 || uint64(len(b[n+m:])) != size {
+		return "", nil, false
+	}
+	for a, c := range multihashCodes {
+		if c == code {
+			return a, b[n+m:], true
+		}
+	}
+	return "", nil, false
+}
+
+// checkEncoding reports the -algos that can't be printed in the -encoding.
+func checkEncoding(encoding string, algos []string) error {
+	for _, a := range algos {
+		if _, ok := multihashCodes[a]; encoding == "multihash" && !ok {
+			return fmt.Errorf("-encoding multihash has no code for %s", a)
+		}
+		if encoding == "sri" && !sriAlgos[a] {
+			return fmt.Errorf("-encoding sri only allows sha256 and sha512, not %s", a)
+		}
+	}
+	return nil
+}
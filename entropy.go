@@ -0,0 +1,50 @@
+package main
+
+import "math"
+
+// Files at or above this many bits of entropy per byte are counted as high
+// entropy, typical of compressed or encrypted data.
+const highEntropy = 7.5
+
+// entropyCounter is an io.Writer counting byte frequencies for Shannon
+// entropy.
+type entropyCounter struct {
+	counts [256]int64
+	n      int64
+}
+
+func (e *entropyCounter) Write(p []byte) (int, error) {
+	for _, b := range p {
+		e.counts[b]++
+	}
+	e.n += int64(len(p))
+	return len(p), nil
+}
+
+// Entropy in bits per byte, 0 (empty or a single repeated byte) to 8.
+func (e *entropyCounter) Entropy() float64 {
+	var h float64
+	for _, c := range e.counts {
+		if c == 0 {
+			continue
+		}
+		p := float64(c) / float64(e.n)
+		h -= p * math.Log2(p)
+	}
+	return h
+}
+
+// withEntropy adds the entropy field before the path to the default fields.
+func withEntropy(fields []string) []string {
+	return insertBeforePath(fields, "entropy")
+}
+
+func countHighEntropy(rs resultSlice) int {
+	n := 0
+	for _, r := range rs {
+		if r.Entropy >= highEntropy {
+			n++
+		}
+	}
+	return n
+}
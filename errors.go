@@ -0,0 +1,90 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/rajder/gosha1/hashwalk"
+	"os"
+	"syscall"
+)
+
+type fileError struct {
+	Path  string `json:"path"`
+	Class string `json:"class"`
+	Error string `json:"error"`
+}
+
+// errorClass sorts the error of a failed file for -list-errors, the JSON
+// output and -check: "missing" if it went away during the run, "permission"
+// if it can't be opened, "io" for a read error of the device, "timeout" for
+// -file-timeout and "other" for the rest.
+func errorClass(err error) string {
+	var te *hashwalk.TimeoutError
+	switch {
+	case errors.As(err, &te):
+		return "timeout"
+	case errors.Is(err, os.ErrNotExist):
+		return "missing"
+	case errors.Is(err, os.ErrPermission):
+		return "permission"
+	case errors.Is(err, syscall.EIO):
+		return "io"
+	}
+	return "other"
+}
+
+// failedPath is the path of a failed result, taken from its error for the
+// directories the walker couldn't read.
+func failedPath(r *result) string {
+	var pe *os.PathError
+	if r.Path == "" && errors.As(r.Err, &pe) {
+		return pe.Path
+	}
+	return r.Path
+}
+
+// printFailed prints the failed files for -list-errors after the results,
+// as "# error: CLASS<TAB>PATH<TAB>MESSAGE" lines, comments to -check and
+// the other manifest readers.
+func printFailed(basepath string, failed []result) {
+	if !opts.listErrors {
+		return
+	}
+	for i := range failed {
+		r := &failed[i]
+		printLine("# error: %s\t%s\t%s", errorClass(r.Err), relPath(basepath, failedPath(r)), r.Err)
+	}
+}
+
+// failedFilesError lists the failed files on stderr and returns an error
+// counting them, nil if there are none.
+func failedFilesError(failed []result) error {
+	if len(failed) == 0 {
+		return nil
+	}
+	log("Failed       :", len(failed))
+	for _, r := range failed {
+		log("\t" + r.Err.Error())
+	}
+	return fmt.Errorf("%d files or directories could not be read", len(failed))
+}
+
+// writeErrorsJSON writes the failed results to path as a JSON array.
+func writeErrorsJSON(path string, failed []result) error {
+	list := make([]fileError, 0, len(failed))
+	for i := range failed {
+		list = append(list, fileError{failedPath(&failed[i]), errorClass(failed[i].Err), failed[i].Err.Error()})
+	}
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	enc := json.NewEncoder(f)
+	enc.SetIndent("", "  ")
+	err = enc.Encode(list)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
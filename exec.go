@@ -0,0 +1,47 @@
+package main
+
+import (
+	"context"
+	"encoding/hex"
+	"os/exec"
+	"strconv"
+	"strings"
+	"sync/atomic"
+)
+
+// execFailures counts the -exec commands that couldn't be run or exited
+// with an error.
+var execFailures int64
+
+// runExec runs the -exec command for r, a file hashed without error, in the
+// worker that hashed it, so at most -j commands run at once. The command is
+// split into words like -ssh-command, without a shell, and {path}, {hash},
+// {size} and {algo} are replaced within each word, so a path with spaces
+// stays one argument. Its output goes to stderr, stdout being the file
+// list.
+func runExec(ctx context.Context, r *result, algo string) {
+	if opts.exec == "" || r.Err != nil || r.Sum == nil {
+		return
+	}
+	repl := strings.NewReplacer("{path}", r.Path, "{hash}", hex.EncodeToString(r.Sum),
+		"{size}", strconv.FormatInt(r.Size, 10), "{algo}", algo)
+	args := strings.Fields(opts.exec)
+	for i, a := range args {
+		args[i] = repl.Replace(a)
+	}
+	cmd := exec.CommandContext(ctx, args[0], args[1:]...)
+	cmd.Stdout = stderr
+	cmd.Stderr = stderr
+	logDebug("exec %s", strings.Join(args, " "))
+	if err := cmd.Run(); err != nil && ctx.Err() == nil {
+		atomic.AddInt64(&execFailures, 1)
+		logError("-exec", r.Path+":", err)
+	}
+}
+
+// warnExecFailures warns about the -exec commands that failed, if any.
+func warnExecFailures() {
+	if n := atomic.LoadInt64(&execFailures); n > 0 {
+		logWarning(n, "-exec commands failed")
+	}
+}
@@ -0,0 +1,30 @@
+package main
+
+import (
+	"os"
+)
+
+// Exit codes, so scripts can tell a clean run from one that found something
+// without parsing stderr.
+const (
+	exitOK          = 0   // Nothing to report.
+	exitFound       = 1   // Duplicates or what a report looks for were found, see found.
+	exitError       = 2   // Files couldn't be read or another error.
+	exitUsage       = 3   // Invalid flags or arguments.
+	exitInterrupted = 130 // Stopped by Ctrl-C or SIGTERM.
+)
+
+// found is set when a run finds duplicates, by printStats for every listing
+// and report with the duplicate stats and by dedupGroups for -delete-dupes,
+// -link-dupes and -reflink-dupes, dry runs included, and by the reports that
+// look for something else when they find it: -dupe-dirs, -changed-only,
+// -case-collisions and -merge-manifests.
+// -check, -cmp, -diff and a failed -selftest return exitFound themselves.
+var found bool
+
+// exit writes the -cpuprofile and -memprofile profiles and exits with code,
+// os.Exit would skip the deferred writes.
+func exit(code int) {
+	stopProfiles()
+	os.Exit(code)
+}
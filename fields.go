@@ -0,0 +1,118 @@
+package main
+
+import (
+	"fmt"
+	"github.com/rajder/gosha1/hashwalk"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Output fields selectable with -fields.
+var validFields = []string{"sum", "size", "path", "mtime", "depth", "entropy", "retries", "meta", "allocated", "duration"}
+
+// parseFields parses -fields. Besides the fixed fields the name of any of
+// the -algos selects that sum and hash is another name for sum. An empty s gives the default, the sum and path
+// or, with several algos, every sum then the path.
+func parseFields(s string, algos []string) ([]string, error) {
+	if s == "" {
+		if len(algos) == 1 {
+			return []string{"sum", "path"}, nil
+		}
+		return append(append([]string{}, algos...), "path"), nil
+	}
+	var fields []string
+	for _, f := range strings.Split(s, ",") {
+		f = strings.TrimSpace(f)
+		if f == "hash" {
+			f = "sum"
+		}
+		if algoIndex(algos, f) < 0 && !isValidField(f) {
+			if _, ok := hashwalk.Algorithms[f]; ok {
+				return nil, fmt.Errorf("field %q in -fields needs %s in -algos", f, f)
+			}
+			return nil, fmt.Errorf("unknown field %q in -fields, valid fields: %s",
+				f, strings.Join(append(validFields, algos...), ","))
+		}
+		fields = append(fields, f)
+	}
+	return fields, nil
+}
+
+// insertBeforePath returns fields with name added before the path field.
+func insertBeforePath(fields []string, name string) []string {
+	var out []string
+	for _, f := range fields {
+		if f == "path" {
+			out = append(out, name)
+		}
+		out = append(out, f)
+	}
+	return out
+}
+
+func algoIndex(algos []string, name string) int {
+	for i, a := range algos {
+		if a == name {
+			return i
+		}
+	}
+	return -1
+}
+
+func isValidField(f string) bool {
+	for _, v := range validFields {
+		if f == v {
+			return true
+		}
+	}
+	return false
+}
+
+func hasField(fields []string, f string) bool {
+	for _, v := range fields {
+		if v == f {
+			return true
+		}
+	}
+	return false
+}
+
+// Depth of a path relative to the scan root, files in the root have depth 1.
+func pathDepth(rel string) int {
+	return strings.Count(filepath.ToSlash(rel), "/") + 1
+}
+
+// formatFields renders the selected fields of r, rel is the path relative to
+// the scan root.
+func formatFields(fields []string, r *result, rel string) []string {
+	vals := make([]string, len(fields))
+	for i, f := range fields {
+		switch f {
+		case "sum":
+			vals[i] = encodeSum(opts.algos[0], r.Sum)
+		case "size":
+			vals[i] = strconv.FormatInt(r.Size, 10)
+		case "path":
+			vals[i] = rel
+		case "mtime":
+			vals[i] = r.ModTime.UTC().Format(time.RFC3339)
+		case "depth":
+			vals[i] = strconv.Itoa(pathDepth(rel))
+		case "entropy":
+			vals[i] = strconv.FormatFloat(r.Entropy, 'f', 3, 64)
+		case "retries":
+			vals[i] = strconv.Itoa(r.Retries)
+		case "duration":
+			vals[i] = strconv.FormatFloat(r.Duration.Seconds(), 'f', 3, 64)
+		case "allocated":
+			vals[i] = strconv.FormatInt(r.Allocated, 10)
+		case "meta":
+			vals[i] = encodeSum(opts.algos[0], r.Meta)
+		default:
+			vals[i] = encodeSum(f, r.Sums[algoIndex(opts.algos, f)])
+		}
+	}
+	return vals
+}
@@ -0,0 +1,18 @@
+//go:build !linux && !darwin && !dragonfly && !freebsd && !netbsd && !openbsd && !windows
+// +build !linux,!darwin,!dragonfly,!freebsd,!netbsd,!openbsd,!windows
+
+package main
+
+import "os"
+
+// fileOwner is the user and group owning the file behind fi, never known
+// here.
+func fileOwner(fi os.FileInfo) (uid, gid uint32, ok bool) {
+	return 0, 0, false
+}
+
+// allocatedSize is the disk space taken by the file behind fi, not known
+// here.
+func allocatedSize(fi os.FileInfo) (int64, bool) {
+	return 0, false
+}
@@ -0,0 +1,27 @@
+//go:build linux || darwin || dragonfly || freebsd || netbsd || openbsd
+// +build linux darwin dragonfly freebsd netbsd openbsd
+
+package main
+
+import (
+	"os"
+	"syscall"
+)
+
+// fileOwner is the user and group owning the file behind fi, false if they
+// aren't known.
+func fileOwner(fi os.FileInfo) (uid, gid uint32, ok bool) {
+	if st, ok := fi.Sys().(*syscall.Stat_t); ok {
+		return st.Uid, st.Gid, true
+	}
+	return 0, 0, false
+}
+
+// allocatedSize is the disk space taken by the file behind fi, less than
+// its size for sparse files, false if it isn't known.
+func allocatedSize(fi os.FileInfo) (int64, bool) {
+	if st, ok := fi.Sys().(*syscall.Stat_t); ok {
+		return int64(st.Blocks) * 512, true
+	}
+	return 0, false
+}
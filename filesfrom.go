@@ -0,0 +1,78 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"github.com/rajder/gosha1/hashwalk"
+	"io"
+	"os"
+)
+
+// filesFrom returns a job producer hashing the files listed in path, "-" for
+// stdin, instead of walking directories. The list is newline delimited, or
+// NUL delimited (find -print0) if a NUL shows up before the first PATH_MAX
+// bytes are over. Listed directories and other non-regular files are
+// skipped with a warning.
+func filesFrom(path string) jobProducer {
+	return func(ctx context.Context, jobs chan<- job) error {
+		var in io.Reader = os.Stdin
+		if path != "-" {
+			f, err := os.Open(path)
+			if err != nil {
+				return err
+			}
+			defer f.Close()
+			in = f
+		}
+		br := bufio.NewReader(in)
+		delim := byte('\n')
+		if head, _ := br.Peek(4097); bytes.IndexByte(head, 0) >= 0 {
+			delim = 0
+		}
+		for {
+			line, err := br.ReadBytes(delim)
+			if len(line) > 0 && line[len(line)-1] == delim {
+				line = line[:len(line)-1]
+			}
+			if delim == '\n' && len(line) > 0 && line[len(line)-1] == '\r' {
+				line = line[:len(line)-1]
+			}
+			if len(line) > 0 {
+				if serr := sendListed(ctx, jobs, string(line)); serr != nil {
+					return serr
+				}
+			}
+			if err == io.EOF {
+				return nil
+			}
+			if err != nil {
+				return err
+			}
+		}
+	}
+}
+
+func sendListed(ctx context.Context, jobs chan<- job, p string) error {
+	j := job{File: hashwalk.File{Path: p}}
+	fi, err := os.Stat(p)
+	switch {
+	case err != nil:
+		if !opts.keepGoing {
+			return err
+		}
+		j.Err = err
+	case !fi.Mode().IsRegular():
+		logWarning(fmt.Sprintf("%s: not a regular file, skipped", p))
+		return nil
+	default:
+		j.Info = fi
+	}
+	select {
+	case jobs <- j:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"bufio"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// gitIgnore holds the ignore rules of one directory: those of its
+// .gosha1ignore, unless -no-ignore-files, and for -respect-gitignore those
+// of its .gitignore and, at the top of a repository, .git/info/exclude.
+// Rules of deeper directories take precedence, as in git.
+type gitIgnore struct {
+	parent *gitIgnore
+	base   string // The directory's slash separated path relative to the root, "" for the root.
+	rules  []ignoreRule
+}
+
+// ignoreFileName is the ignore file honoured in every directory of a walk,
+// whose rules go with the data rather than on the command line.
+const ignoreFileName = ".gosha1ignore"
+
+type ignoreRule struct {
+	pattern string // A globMatch pattern.
+	negate  bool
+}
+
+// enter returns the rules for the directory path, rel relative to the
+// root, with those of its ignore files added to g's. g is returned as is if
+// the directory has none.
+func (g *gitIgnore) enter(path, rel string) *gitIgnore {
+	var rules []ignoreRule
+	if opts.respectGitignore {
+		rules = append(rules, readIgnoreFile(filepath.Join(path, ".git", "info", "exclude"))...)
+		rules = append(rules, readIgnoreFile(filepath.Join(path, ".gitignore"))...)
+	}
+	// Coming last, the rules of .gosha1ignore win over git's.
+	if !opts.noIgnoreFiles {
+		rules = append(rules, readIgnoreFile(filepath.Join(path, ignoreFileName))...)
+	}
+	if len(rules) == 0 {
+		return g
+	}
+	return &gitIgnore{parent: g, base: rel, rules: rules}
+}
+
+// readIgnoreFile parses a gitignore file, a missing or unreadable one has no
+// rules. Invalid patterns are left out.
+func readIgnoreFile(name string) []ignoreRule {
+	f, err := os.Open(name)
+	if err != nil {
+		return nil
+	}
+	defer f.Close()
+	var rules []ignoreRule
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		line := strings.TrimSuffix(sc.Text(), "\r")
+		// Trailing spaces don't count unless escaped.
+		for strings.HasSuffix(line, " ") && !strings.HasSuffix(line, "\\ ") {
+			line = line[:len(line)-1]
+		}
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		var r ignoreRule
+		if strings.HasPrefix(line, "!") {
+			r.negate = true
+			line = line[1:]
+		} else if strings.HasPrefix(line, `\#`) || strings.HasPrefix(line, `\!`) {
+			line = line[1:]
+		}
+		if strings.Trim(line, "/") == "" {
+			continue
+		}
+		if _, err := path.Match(strings.Trim(line, "/"), ""); err != nil {
+			continue
+		}
+		r.pattern = line
+		rules = append(rules, r)
+	}
+	return rules
+}
+
+// ignored reports whether the entry rel of the walk is ignored. The last
+// matching rule of the deepest directory with a match decides.
+func (g *gitIgnore) ignored(rel string, isDir bool) bool {
+	for ; g != nil; g = g.parent {
+		sub := rel
+		if g.base != "" {
+			sub = strings.TrimPrefix(rel, g.base+"/")
+		}
+		for i := len(g.rules) - 1; i >= 0; i-- {
+			if globMatch(g.rules[i].pattern, sub, isDir) {
+				return !g.rules[i].negate
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"fmt"
+	"path"
+	"strings"
+)
+
+// globList is a repeatable flag of gitignore style patterns: a pattern
+// without a slash matches names at any depth, one with a slash (a leading
+// one just anchors it) matches the path relative to the root, ** matches any
+// number of directories and a trailing slash only matches directories.
+type globList []string
+
+func (g *globList) String() string {
+	return strings.Join(*g, ",")
+}
+
+func (g *globList) Set(s string) error {
+	p := strings.TrimSuffix(s, "/")
+	for _, seg := range strings.Split(strings.TrimPrefix(p, "/"), "/") {
+		if _, err := path.Match(seg, ""); err != nil || seg == "" {
+			return fmt.Errorf("invalid pattern %q", s)
+		}
+	}
+	*g = append(*g, s)
+	return nil
+}
+
+// match reports whether any pattern matches rel, the slash separated path
+// relative to the root.
+func (g globList) match(rel string, isDir bool) bool {
+	for _, p := range g {
+		if globMatch(p, rel, isDir) {
+			return true
+		}
+	}
+	return false
+}
+
+func globMatch(pattern, rel string, isDir bool) bool {
+	if strings.HasSuffix(pattern, "/") {
+		if !isDir {
+			return false
+		}
+		pattern = strings.TrimSuffix(pattern, "/")
+	}
+	if !strings.Contains(pattern, "/") {
+		ok, _ := path.Match(pattern, path.Base(rel))
+		return ok
+	}
+	pattern = strings.TrimPrefix(pattern, "/")
+	return matchSegments(strings.Split(pattern, "/"), strings.Split(rel, "/"))
+}
+
+func matchSegments(pat, segs []string) bool {
+	for len(pat) > 0 {
+		if pat[0] == "**" {
+			for i := 0; i <= len(segs); i++ {
+				if matchSegments(pat[1:], segs[i:]) {
+					return true
+				}
+			}
+			return false
+		}
+		if len(segs) == 0 {
+			return false
+		}
+		if ok, _ := path.Match(pat[0], segs[0]); !ok {
+			return false
+		}
+		pat, segs = pat[1:], segs[1:]
+	}
+	return len(segs) == 0
+}
+
+// wantPath applies -exclude and -include to the entry rel of the walk.
+// Excluded directories are skipped as a whole. With -include only matching
+// files are hashed, directories are still walked.
+func wantPath(rel string, isDir bool) bool {
+	if opts.exclude.match(rel, isDir) {
+		return false
+	}
+	return isDir || len(opts.include) == 0 || opts.include.match(rel, false)
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"github.com/rajder/gosha1/hashwalk"
+	"sync"
+)
+
+// resolveAliases gives the results of hard links that weren't hashed again
+// the sums of the link that was, where linkedSums couldn't. Aliases of a
+// file that failed are dropped. Which link the walker hashed depends on the
+// order its goroutines got to them, so the lexically smallest path of each
+// file is made the one the others are aliases of, taking the time it took
+// to hash, and the output is the same on every run.
+func resolveAliases(rs resultSlice) resultSlice {
+	byPath := make(map[string]*result)
+	for i := range rs {
+		if rs[i].Alias == "" {
+			byPath[rs[i].Path] = &rs[i]
+		}
+	}
+	// The smallest path of each file, by the path that was hashed.
+	first := make(map[string]string)
+	out := make(resultSlice, 0, len(rs))
+	for _, r := range rs {
+		if r.Alias != "" {
+			orig, ok := byPath[r.Alias]
+			if !ok {
+				continue
+			}
+			r.Sum, r.Sums, r.Entropy, r.Quick, r.Pieces = orig.Sum, orig.Sums, orig.Entropy, orig.Quick, orig.Pieces
+			if p, ok := first[r.Alias]; r.Path < r.Alias && (!ok || r.Path < p) {
+				first[r.Alias] = r.Path
+			}
+		}
+		out = append(out, r)
+	}
+	for i := range out {
+		hashed := out[i].Alias
+		if hashed == "" {
+			hashed = out[i].Path
+		}
+		switch p, ok := first[hashed]; {
+		case !ok:
+		case out[i].Path == p:
+			out[i].Alias = ""
+			out[i].Duration = byPath[hashed].Duration
+		default:
+			out[i].Alias = p
+			out[i].Duration = 0
+		}
+	}
+	return out
+}
+
+// withoutAliases returns rs without the hard link aliases, which are the
+// same file and not duplicates of it.
+func withoutAliases(rs resultSlice) resultSlice {
+	var out resultSlice
+	for _, r := range rs {
+		if r.Alias == "" {
+			out = append(out, r)
+		}
+	}
+	return out
+}
+
+// linkedSums passes the sums of a file with several hard links on to the
+// aliases of it while the workers run, so the results of a scan carry their
+// sums in every mode, the streamed and spilled ones too. An alias whose link
+// is still being hashed waits until the worker hashing it is done.
+type linkedSums struct {
+	mu      sync.Mutex
+	sums    map[string]result // The hashed links, by path.
+	waiting map[string][]job  // Aliases waiting for their link, by its path.
+}
+
+// alias returns the result of the alias j, false if it has to wait for
+// its link.
+func (l *linkedSums) alias(j job) (result, bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if from, ok := l.sums[j.Alias]; ok {
+		return aliasResult(j, &from), true
+	}
+	if l.waiting == nil {
+		l.waiting = make(map[string][]job)
+	}
+	l.waiting[j.Alias] = append(l.waiting[j.Alias], j)
+	return result{}, false
+}
+
+// hashed records the sums of r, the result of j, if the file has other
+// links, and returns the aliases that waited for it.
+func (l *linkedSums) hashed(j job, r *result) []job {
+	if r.Err != nil || r.Alias != "" || j.Link != "" || j.Info == nil || hashwalk.LinkCount(j.Info) < 2 {
+		return nil
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.sums == nil {
+		l.sums = make(map[string]result)
+	}
+	l.sums[r.Path] = result{Sum: r.Sum, Sums: r.Sums, Entropy: r.Entropy, Quick: r.Quick, Pieces: r.Pieces}
+	waiting := l.waiting[r.Path]
+	delete(l.waiting, r.Path)
+	return waiting
+}
+
+// rest returns the aliases still waiting once all files were hashed: those
+// whose link failed, or was hashed by an earlier scan, as for the -quick
+// candidates, which resolveAliases gives its sums.
+func (l *linkedSums) rest() []job {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	var rest []job
+	for _, js := range l.waiting {
+		rest = append(rest, js...)
+	}
+	l.waiting = nil
+	return rest
+}
+
+// aliasResult returns the result of the alias j with the sums of from, the
+// result of its link, without sums if from is nil.
+func aliasResult(j job, from *result) result {
+	r := result{Path: j.Path, Size: j.Info.Size(), ModTime: j.Info.ModTime(), Root: j.Root, Alias: j.Alias}
+	if from != nil {
+		r.Sum, r.Sums, r.Entropy, r.Quick, r.Pieces = from.Sum, from.Sums, from.Entropy, from.Quick, from.Pieces
+	}
+	return r
+}
@@ -0,0 +1,277 @@
+package hashwalk
+
+import (
+	"encoding/binary"
+	"hash"
+	"math/bits"
+)
+
+// BLAKE3 with the default 32 byte output and no key, as printed by b3sum.
+// The input is split into 1K chunks that are hashed independently and
+// joined in a binary tree, so large inputs can be hashed in parallel: see
+// Blake3Subtree and NewBlake3After.
+
+const (
+	blake3ChunkLen = 1024
+	blake3BlockLen = 64
+
+	blake3ChunkStart = 1 << 0
+	blake3ChunkEnd   = 1 << 1
+	blake3Parent     = 1 << 2
+	blake3Root       = 1 << 3
+)
+
+// Blake3SubtreeSize is the size of the pieces Blake3Subtree hashes, a power
+// of two number of chunks.
+const Blake3SubtreeSize = 8 << 20
+
+var blake3IV = [8]uint32{
+	0x6A09E667, 0xBB67AE85, 0x3C6EF372, 0xA54FF53A, 0x510E527F, 0x9B05688C, 0x1F83D9AB, 0x5BE0CD19,
+}
+
+var blake3Permutation = [16]int{2, 6, 3, 10, 7, 0, 4, 13, 1, 11, 12, 5, 9, 14, 15, 8}
+
+func blake3G(s *[16]uint32, a, b, c, d int, mx, my uint32) {
+	s[a] += s[b] + mx
+	s[d] = bits.RotateLeft32(s[d]^s[a], -16)
+	s[c] += s[d]
+	s[b] = bits.RotateLeft32(s[b]^s[c], -12)
+	s[a] += s[b] + my
+	s[d] = bits.RotateLeft32(s[d]^s[a], -8)
+	s[c] += s[d]
+	s[b] = bits.RotateLeft32(s[b]^s[c], -7)
+}
+
+func blake3Compress(cv *[8]uint32, m [16]uint32, counter uint64, blockLen, flags uint32) [16]uint32 {
+	s := [16]uint32{
+		cv[0], cv[1], cv[2], cv[3], cv[4], cv[5], cv[6], cv[7],
+		blake3IV[0], blake3IV[1], blake3IV[2], blake3IV[3],
+		uint32(counter), uint32(counter >> 32), blockLen, flags,
+	}
+	for r := 0; r < 7; r++ {
+		blake3G(&s, 0, 4, 8, 12, m[0], m[1])
+		blake3G(&s, 1, 5, 9, 13, m[2], m[3])
+		blake3G(&s, 2, 6, 10, 14, m[4], m[5])
+		blake3G(&s, 3, 7, 11, 15, m[6], m[7])
+		blake3G(&s, 0, 5, 10, 15, m[8], m[9])
+		blake3G(&s, 1, 6, 11, 12, m[10], m[11])
+		blake3G(&s, 2, 7, 8, 13, m[12], m[13])
+		blake3G(&s, 3, 4, 9, 14, m[14], m[15])
+		var p [16]uint32
+		for i, j := range blake3Permutation {
+			p[i] = m[j]
+		}
+		m = p
+	}
+	for i := 0; i < 8; i++ {
+		s[i] ^= s[i+8]
+		s[i+8] ^= cv[i]
+	}
+	return s
+}
+
+func blake3Words(block []byte) [16]uint32 {
+	var buf [blake3BlockLen]byte
+	copy(buf[:], block)
+	var m [16]uint32
+	for i := range m {
+		m[i] = binary.LittleEndian.Uint32(buf[4*i:])
+	}
+	return m
+}
+
+// blake3Output is a compression not done yet, it becomes a chaining value
+// or, with the root flag, the hash.
+type blake3Output struct {
+	cv       [8]uint32
+	block    [16]uint32
+	counter  uint64
+	blockLen uint32
+	flags    uint32
+}
+
+func (o *blake3Output) chainingValue() [8]uint32 {
+	s := blake3Compress(&o.cv, o.block, o.counter, o.blockLen, o.flags)
+	var cv [8]uint32
+	copy(cv[:], s[:8])
+	return cv
+}
+
+func (o *blake3Output) rootHash(b []byte) []byte {
+	s := blake3Compress(&o.cv, o.block, 0, o.blockLen, o.flags|blake3Root)
+	for _, w := range s[:8] {
+		b = binary.LittleEndian.AppendUint32(b, w)
+	}
+	return b
+}
+
+func blake3ParentOutput(left, right [8]uint32) blake3Output {
+	var m [16]uint32
+	copy(m[:8], left[:])
+	copy(m[8:], right[:])
+	return blake3Output{cv: blake3IV, block: m, blockLen: blake3BlockLen, flags: blake3Parent}
+}
+
+// blake3ChunkState hashes one chunk.
+type blake3ChunkState struct {
+	cv         [8]uint32
+	counter    uint64
+	block      [blake3BlockLen]byte
+	blockLen   int
+	compressed int // Blocks compressed so far.
+}
+
+func newBlake3ChunkState(counter uint64) blake3ChunkState {
+	return blake3ChunkState{cv: blake3IV, counter: counter}
+}
+
+func (c *blake3ChunkState) len() int {
+	return blake3BlockLen*c.compressed + c.blockLen
+}
+
+func (c *blake3ChunkState) startFlag() uint32 {
+	if c.compressed == 0 {
+		return blake3ChunkStart
+	}
+	return 0
+}
+
+func (c *blake3ChunkState) update(p []byte) {
+	for len(p) > 0 {
+		// The last block of the chunk is only compressed by output, with
+		// the end flag.
+		if c.blockLen == blake3BlockLen {
+			s := blake3Compress(&c.cv, blake3Words(c.block[:]), c.counter, blake3BlockLen, c.startFlag())
+			copy(c.cv[:], s[:8])
+			c.compressed++
+			c.blockLen = 0
+		}
+		n := copy(c.block[c.blockLen:], p)
+		c.blockLen += n
+		p = p[n:]
+	}
+}
+
+func (c *blake3ChunkState) output() blake3Output {
+	return blake3Output{
+		cv:       c.cv,
+		block:    blake3Words(c.block[:c.blockLen]),
+		counter:  c.counter,
+		blockLen: uint32(c.blockLen),
+		flags:    c.startFlag() | blake3ChunkEnd,
+	}
+}
+
+type blake3 struct {
+	chunk blake3ChunkState
+	// Chaining values of the complete subtrees before the current chunk.
+	stack [][8]uint32
+}
+
+func newBlake3() hash.Hash {
+	return &blake3{chunk: newBlake3ChunkState(0)}
+}
+
+// NewBlake3After returns a BLAKE3 hash that continues after the first
+// len(cvs) Blake3SubtreeSize pieces of the input, cvs being their
+// Blake3Subtree values in order. The rest of the input, at least one byte,
+// is written to it.
+func NewBlake3After(cvs [][32]byte) hash.Hash {
+	d := &blake3{}
+	per := uint64(Blake3SubtreeSize / blake3ChunkLen)
+	for i, cv := range cvs {
+		var w [8]uint32
+		for j := range w {
+			w[j] = binary.LittleEndian.Uint32(cv[4*j:])
+		}
+		d.push(w, uint64(i)*per)
+	}
+	d.chunk = newBlake3ChunkState(uint64(len(cvs)) * per)
+	return d
+}
+
+// Blake3Subtree returns the chaining value of piece, the i-th
+// Blake3SubtreeSize bytes of an input, for NewBlake3After.
+func Blake3Subtree(piece []byte, i int64) [32]byte {
+	first := uint64(i) * Blake3SubtreeSize / blake3ChunkLen
+	cvs := make([][8]uint32, 0, Blake3SubtreeSize/blake3ChunkLen)
+	for n := uint64(0); len(piece) > 0; n++ {
+		c := newBlake3ChunkState(first + n)
+		end := blake3ChunkLen
+		if end > len(piece) {
+			end = len(piece)
+		}
+		c.update(piece[:end])
+		o := c.output()
+		cvs = append(cvs, o.chainingValue())
+		piece = piece[end:]
+	}
+	for len(cvs) > 1 {
+		for j := 0; j < len(cvs)/2; j++ {
+			o := blake3ParentOutput(cvs[2*j], cvs[2*j+1])
+			cvs[j] = o.chainingValue()
+		}
+		cvs = cvs[:len(cvs)/2]
+	}
+	var b [32]byte
+	for j, w := range cvs[0] {
+		binary.LittleEndian.PutUint32(b[4*j:], w)
+	}
+	return b
+}
+
+func (d *blake3) Size() int      { return 32 }
+func (d *blake3) BlockSize() int { return blake3BlockLen }
+
+func (d *blake3) Reset() {
+	d.chunk = newBlake3ChunkState(0)
+	d.stack = d.stack[:0]
+}
+
+// push adds the chaining value of the subtree starting at chunk: the stack
+// is merged to one entry per one bit of chunk, the number of chunks before
+// it, first.
+func (d *blake3) push(cv [8]uint32, chunk uint64) {
+	d.merge(chunk)
+	d.stack = append(d.stack, cv)
+}
+
+func (d *blake3) merge(chunks uint64) {
+	for len(d.stack) > bits.OnesCount64(chunks) {
+		n := len(d.stack)
+		o := blake3ParentOutput(d.stack[n-2], d.stack[n-1])
+		d.stack[n-2] = o.chainingValue()
+		d.stack = d.stack[:n-1]
+	}
+}
+
+func (d *blake3) Write(p []byte) (int, error) {
+	n := len(p)
+	for len(p) > 0 {
+		// A full chunk is only finished once more input follows, the last
+		// one is the root if it is the only one.
+		if d.chunk.len() == blake3ChunkLen {
+			o := d.chunk.output()
+			d.push(o.chainingValue(), d.chunk.counter)
+			d.chunk = newBlake3ChunkState(d.chunk.counter + 1)
+		}
+		take := blake3ChunkLen - d.chunk.len()
+		if take > len(p) {
+			take = len(p)
+		}
+		d.chunk.update(p[:take])
+		p = p[take:]
+	}
+	return n, nil
+}
+
+func (d *blake3) Sum(b []byte) []byte {
+	stack := append([][8]uint32(nil), d.stack...)
+	c := &blake3{stack: stack}
+	c.merge(d.chunk.counter)
+	o := d.chunk.output()
+	for i := len(c.stack) - 1; i >= 0; i-- {
+		o = blake3ParentOutput(c.stack[i], o.chainingValue())
+	}
+	return o.rootHash(b)
+}
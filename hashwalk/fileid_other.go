@@ -0,0 +1,31 @@
+//go:build !linux && !darwin && !dragonfly && !freebsd && !netbsd && !openbsd && !windows
+// +build !linux,!darwin,!dragonfly,!freebsd,!netbsd,!openbsd,!windows
+
+package hashwalk
+
+import (
+	"os"
+	"path/filepath"
+)
+
+// FileID identifies the file behind fi by its path with all symlinks
+// resolved, this platform has no device and inode numbers.
+func FileID(path string, fi os.FileInfo) string {
+	real, err := filepath.EvalSymlinks(path)
+	if err != nil {
+		return path
+	}
+	return real
+}
+
+// LinkCount is the number of hard links to the file behind fi, always 1
+// here as hard links can't be told apart.
+func LinkCount(fi os.FileInfo) uint64 {
+	return 1
+}
+
+// DeviceID is the device of the file system holding the file behind fi,
+// never known here, so WalkOptions.OneFileSystem has no effect.
+func DeviceID(fi os.FileInfo) (uint64, bool) {
+	return 0, false
+}
@@ -0,0 +1,36 @@
+//go:build linux || darwin || dragonfly || freebsd || netbsd || openbsd
+// +build linux darwin dragonfly freebsd netbsd openbsd
+
+package hashwalk
+
+import (
+	"fmt"
+	"os"
+	"syscall"
+)
+
+// FileID identifies the file behind fi by device and inode, for detecting
+// symlink cycles and hard links.
+func FileID(path string, fi os.FileInfo) string {
+	if st, ok := fi.Sys().(*syscall.Stat_t); ok {
+		return fmt.Sprintf("%d:%d", uint64(st.Dev), uint64(st.Ino))
+	}
+	return path
+}
+
+// LinkCount is the number of hard links to the file behind fi.
+func LinkCount(fi os.FileInfo) uint64 {
+	if st, ok := fi.Sys().(*syscall.Stat_t); ok {
+		return uint64(st.Nlink)
+	}
+	return 1
+}
+
+// DeviceID is the device of the file system holding the file behind fi,
+// false if it isn't known.
+func DeviceID(fi os.FileInfo) (uint64, bool) {
+	if st, ok := fi.Sys().(*syscall.Stat_t); ok {
+		return uint64(st.Dev), true
+	}
+	return 0, false
+}
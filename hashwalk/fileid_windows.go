@@ -0,0 +1,47 @@
+//go:build windows
+// +build windows
+
+package hashwalk
+
+import (
+	"fmt"
+	"os"
+	"syscall"
+)
+
+// FileID identifies the file behind path by volume serial number and file
+// index, for detecting cycles through symlinks and junctions with Symlinks
+// "follow". filepath.EvalSymlinks doesn't resolve junctions, so the path
+// can't be used.
+func FileID(path string, fi os.FileInfo) string {
+	p, err := syscall.UTF16PtrFromString(LongPath(path))
+	if err != nil {
+		return path
+	}
+	// Backup semantics are needed to open directories.
+	h, err := syscall.CreateFile(p, 0, syscall.FILE_SHARE_READ|syscall.FILE_SHARE_WRITE|syscall.FILE_SHARE_DELETE,
+		nil, syscall.OPEN_EXISTING, syscall.FILE_FLAG_BACKUP_SEMANTICS, 0)
+	if err != nil {
+		return path
+	}
+	defer syscall.CloseHandle(h)
+	var d syscall.ByHandleFileInformation
+	if err := syscall.GetFileInformationByHandle(h, &d); err != nil {
+		return path
+	}
+	return fmt.Sprintf("%d:%d:%d", d.VolumeSerialNumber, d.FileIndexHigh, d.FileIndexLow)
+}
+
+// LinkCount is the number of hard links to the file behind fi, always 1
+// here: directory listings don't have it and opening every file to ask
+// would slow the walk down.
+func LinkCount(fi os.FileInfo) uint64 {
+	return 1
+}
+
+// DeviceID is the device of the file system holding the file behind fi,
+// not in directory listings here, so WalkOptions.OneFileSystem has no
+// effect.
+func DeviceID(fi os.FileInfo) (uint64, bool) {
+	return 0, false
+}
@@ -0,0 +1,156 @@
+// Package hashwalk hashes the files of a directory tree concurrently. It is
+// the core of the gosha1 command, which adds its reporting and filtering
+// options on top.
+//
+//	s, err := hashwalk.Walk(ctx, "photos", hashwalk.Options{})
+//	if err != nil {
+//		return err
+//	}
+//	for r := range s.Results {
+//		if r.Err != nil {
+//			log.Print(r.Err)
+//			continue
+//		}
+//		fmt.Printf("%x\t%s\n", r.Sum, r.Path)
+//	}
+//	return s.Err()
+package hashwalk
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"time"
+)
+
+// Result is a hashed file, or a file or directory that couldn't be read if
+// Err is set.
+type Result struct {
+	Path    string
+	Sum     []byte   // Sum of the first of Options.Algorithms.
+	Sums    [][]byte // A sum per algorithm.
+	Size    int64
+	ModTime time.Time
+	Err     error
+	// With HardLinks, the path of the earlier result of the same file,
+	// whose sums this one has. Sum and Sums are unset.
+	Alias string
+}
+
+// Options of Walk, the zero value hashes with sha1 on one worker per CPU,
+// skips dot directories and symlinks and aborts on a directory it can't
+// read, like the gosha1 command.
+type Options struct {
+	Algorithms []string // Names from Algorithms, default sha1.
+	Workers    int      // Files hashed in parallel, default runtime.NumCPU().
+	// Give up on a file after this long, e.g. on a hung network mount.
+	// Zero waits forever.
+	FileTimeout time.Duration
+	// How the tree is walked, DirWorkers defaults to Workers.
+	WalkOptions
+}
+
+// Walk hashes the regular files below root and delivers a result per file
+// on the Results channel of the returned scan, which is closed when done or
+// soon after ctx is cancelled, which also stops the files being read.
+// Errors reading a file, files that timed out and with KeepGoing
+// directories that can't be read are delivered as results and collected in
+// its FileErrors, the walk carries on. Its Err is set if the scan was
+// aborted. An error is returned if the options are invalid or root isn't a
+// directory.
+func Walk(ctx context.Context, root string, opts Options) (*Scan[Result], error) {
+	algos := opts.Algorithms
+	if len(algos) == 0 {
+		algos = []string{"sha1"}
+	}
+	for _, a := range algos {
+		if _, ok := Algorithms[a]; !ok {
+			return nil, fmt.Errorf("unknown hash algorithm %q, valid algorithms: %s", a, AlgorithmNames())
+		}
+	}
+	workers := opts.Workers
+	if workers <= 0 {
+		workers = runtime.NumCPU()
+	}
+	fi, err := os.Stat(root)
+	if err != nil {
+		return nil, err
+	}
+	if !fi.IsDir() {
+		return nil, fmt.Errorf("%s: not a directory", root)
+	}
+	wo := opts.WalkOptions
+	if wo.DirWorkers <= 0 {
+		wo.DirWorkers = workers
+	}
+	res := make(chan Result)
+	files := make(chan File, workers)
+	produce := func(ctx context.Context, files chan<- File) error {
+		send := func(ctx context.Context, f File) error {
+			select {
+			case files <- f:
+				return nil
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		}
+		return NewWalker(ctx, wo, send).Walk(root, 0)
+	}
+	work := func(id int, s *Scan[Result]) {
+		for f := range files {
+			if ctx.Err() != nil {
+				continue
+			}
+			r := hashFile(ctx, f, algos, opts.FileTimeout)
+			s.AddFileError(r.Err)
+			select {
+			case res <- r:
+			case <-ctx.Done():
+			}
+		}
+	}
+	return Start(ctx, workers, files, res, produce, work), nil
+}
+
+// hashFile hashes the file f, or the target path string of a link, giving
+// up after timeout if it is positive.
+func hashFile(ctx context.Context, f File, algos []string, timeout time.Duration) Result {
+	r := Result{Path: f.Path, Err: f.Err, Alias: f.Alias}
+	if f.Err != nil {
+		return r
+	}
+	r.Size, r.ModTime = f.Info.Size(), f.Info.ModTime()
+	if f.Alias != "" {
+		return r
+	}
+	if f.Link != "" {
+		r.Sums, r.Size, r.Err = SumReader(strings.NewReader(f.Link), algos, nil)
+	} else {
+		r.Sums, r.Size, r.Err = WithTimeout(ctx, f.Path, timeout, func(ctx context.Context) ([][]byte, int64, error) {
+			return sumFile(ctx, f.Path, algos)
+		})
+	}
+	if r.Err == nil {
+		r.Sum = r.Sums[0]
+	}
+	return r
+}
+
+func sumFile(ctx context.Context, path string, algos []string) ([][]byte, int64, error) {
+	f, err := os.Open(LongPath(path))
+	if err != nil {
+		return nil, 0, err
+	}
+	defer f.Close()
+	return SumReaderContext(ctx, f, algos, nil)
+}
+
+// IsDotPath reports whether the last element of p is a dot name such as
+// .git, other than . and ..
+func IsDotPath(p string) bool {
+	b := filepath.Base(p)
+	return ".." != b && len(b) > 1 && '.' == b[0]
+}
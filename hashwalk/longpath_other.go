@@ -0,0 +1,16 @@
+//go:build !windows
+// +build !windows
+
+package hashwalk
+
+import "os"
+
+// LongPath returns p, only Windows limits the length of paths.
+func LongPath(p string) string {
+	return p
+}
+
+// IsJunction reports false, junctions only exist on Windows.
+func IsJunction(path string, fi os.FileInfo) bool {
+	return false
+}
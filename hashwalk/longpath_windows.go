@@ -0,0 +1,50 @@
+//go:build windows
+// +build windows
+
+package hashwalk
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+)
+
+// maxPath is where paths need the \\?\ prefix, MAX_PATH less room for a
+// file name of 8.3 characters and a NUL, as CreateDirectory requires.
+const maxPath = 248
+
+// LongPath returns p with the \\?\ prefix if it is too long for the Win32
+// API without it, made absolute and cleaned, as prefixed paths aren't
+// normalized. The os package does this for absolute paths already, this
+// also covers relative ones.
+func LongPath(p string) string {
+	if len(p) < maxPath || strings.HasPrefix(p, `\\?\`) || strings.HasPrefix(p, `\\.\`) {
+		return p
+	}
+	abs, err := filepath.Abs(p)
+	if err != nil {
+		return p
+	}
+	if strings.HasPrefix(abs, `\\`) {
+		return `\\?\UNC\` + abs[2:]
+	}
+	return `\\?\` + abs
+}
+
+// IsJunction reports whether the directory entry fi at path is a junction
+// (mount point) or another reparse point link that isn't reported as a
+// symlink, so WalkOptions.Symlinks applies to it instead of walking into
+// it. Reparse points that aren't links, e.g. cloud sync placeholders, are
+// walked as plain directories.
+func IsJunction(path string, fi os.FileInfo) bool {
+	if !fi.IsDir() || fi.Mode()&os.ModeSymlink != 0 {
+		return false
+	}
+	d, ok := fi.Sys().(*syscall.Win32FileAttributeData)
+	if !ok || d.FileAttributes&syscall.FILE_ATTRIBUTE_REPARSE_POINT == 0 {
+		return false
+	}
+	_, err := os.Readlink(LongPath(path))
+	return err == nil
+}
@@ -0,0 +1,124 @@
+package hashwalk
+
+import (
+	"context"
+	"errors"
+	"github.com/anderejd/syncext"
+	"sync"
+	"sync/atomic"
+)
+
+// Scan is a running hash of a tree. Results delivers a result per file,
+// files that couldn't be hashed have their error set. Once Results is
+// closed, Err tells whether the scan was aborted and FileErrors which files
+// failed along the way, so callers can tell "completed with some unreadable
+// files" from "aborted".
+type Scan[R any] struct {
+	Results  <-chan R
+	queue    func() (n, size int) // The files waiting for a worker.
+	produced int32                // Set once produce returned.
+
+	mu       sync.Mutex
+	fatal    error
+	fileErrs []error
+}
+
+// Start starts a scan of the files produce sends to jobs, which is closed
+// once produce returns, by workers goroutines each running work with its
+// index. The work functions take the files from jobs, or a channel fed by
+// it, and send a result for each to results, which is closed once all of
+// them returned. An error from produce aborts the scan, as does ctx being
+// done; the work functions should skip the remaining files then.
+func Start[J, R any](ctx context.Context, workers int, jobs chan J, results chan R,
+	produce func(ctx context.Context, jobs chan<- J) error, work func(id int, s *Scan[R])) *Scan[R] {
+	s := &Scan[R]{Results: results, queue: func() (int, int) { return len(jobs), cap(jobs) }}
+	var started int32
+	syncext.FanOut(workers, func() {
+		work(int(atomic.AddInt32(&started, 1)-1), s)
+	}, func() {
+		s.SetErr(ctx.Err())
+		close(results)
+	})
+	go func() {
+		s.SetErr(produce(ctx, jobs))
+		atomic.StoreInt32(&s.produced, 1)
+		close(jobs)
+	}()
+	return s
+}
+
+// NewScan returns a scan delivering results, for code sending the results
+// of its own workers or passing on those of another scan.
+func NewScan[R any](results <-chan R) *Scan[R] {
+	return &Scan[R]{Results: results}
+}
+
+// SetErr records err as the error that aborted the scan, unless there
+// already is one. A nil err is ignored.
+func (s *Scan[R]) SetErr(err error) {
+	if err == nil {
+		return
+	}
+	s.mu.Lock()
+	if s.fatal == nil {
+		s.fatal = err
+	}
+	s.mu.Unlock()
+}
+
+// AddFileError records the error of a file that couldn't be hashed, the
+// scan carries on. A nil err is ignored.
+func (s *Scan[R]) AddFileError(err error) {
+	if err == nil {
+		return
+	}
+	s.mu.Lock()
+	s.fileErrs = append(s.fileErrs, err)
+	s.mu.Unlock()
+}
+
+// Err returns the error that aborted the scan, nil if it ran to completion.
+// Only valid after Results is closed.
+func (s *Scan[R]) Err() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.fatal
+}
+
+// FileErrors returns the per-file errors joined with errors.Join, nil if all
+// files were hashed. errors.As and errors.Is see each of them. Only valid
+// after Results is closed.
+func (s *Scan[R]) FileErrors() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return errors.Join(s.fileErrs...)
+}
+
+// Queued returns how many files wait for a worker and how many the queue
+// holds, both 0 for a scan without a queue.
+func (s *Scan[R]) Queued() (n, size int) {
+	if s.queue == nil {
+		return 0, 0
+	}
+	return s.queue()
+}
+
+// Produced reports whether all the files were queued, the walk is done.
+// Always false for a scan without a queue.
+func (s *Scan[R]) Produced() bool {
+	return atomic.LoadInt32(&s.produced) != 0
+}
+
+// CopyErrors records the errors of the scan from, once its Results is
+// closed, in s, for a scan passing on the results of another.
+func (s *Scan[R]) CopyErrors(from *Scan[R]) {
+	from.mu.Lock()
+	fatal, fileErrs := from.fatal, append([]error(nil), from.fileErrs...)
+	from.mu.Unlock()
+	s.mu.Lock()
+	if s.fatal == nil {
+		s.fatal = fatal
+	}
+	s.fileErrs = append(s.fileErrs, fileErrs...)
+	s.mu.Unlock()
+}
@@ -0,0 +1,263 @@
+package hashwalk
+
+import (
+	"crypto/sha1"
+	"encoding/binary"
+	"hash"
+	"math/bits"
+)
+
+// SHA-1 with collision detection, after the counter-cryptanalysis of Marc
+// Stevens and Dan Shumow's sha1collisiondetection, as used by git. Every
+// known way to make two messages with the same SHA-1 sum, SHAttered's
+// included, needs a near-collision block built with one of 32 disturbance
+// vectors. For each block, and each vector, the compression is redone from
+// the middle with the message difference of that vector: if that gives the
+// same output, the block is one half of such a pair.
+//
+// The unavoidable bit condition filter of the original, which skips most
+// vectors for most blocks, isn't done here, so a SHA1DC is about 30 times
+// slower than crypto/sha1. It is meant for rechecking the few files that
+// share a sum, not for hashing whole trees.
+
+// sha1DV is a disturbance vector: its message difference, the 80 expanded
+// words, and the step the compression is redone from.
+type sha1DV struct {
+	dm    [80]uint32
+	testt int
+}
+
+// sha1DVBases are the first 16 words of the message difference of the
+// first vector of each family, type I and II with bit 0 or 2 as in the
+// vector names I(K,b) and II(K,b), at the smallest K used. A larger K
+// shifts the difference that many words on.
+var sha1DVBases = []struct {
+	ks   []int
+	base [16]uint32
+}{
+	{[]int{43, 44, 45, 46, 47, 48, 49, 50, 51, 52}, [16]uint32{ // I(K,0)
+		0x08000000, 0x9800000c, 0xd8000010, 0x08000010, 0xb8000010, 0x98000000, 0x60000000, 0x00000008,
+		0xc0000000, 0x90000014, 0x10000010, 0xb8000014, 0x28000000, 0x20000010, 0x48000000, 0x08000018,
+	}},
+	{[]int{46, 47, 48, 49, 50, 51}, [16]uint32{ // I(K,2)
+		0xb0000040, 0xd0000053, 0xd0000022, 0x20000000, 0x60000032, 0x60000043, 0x20000040, 0xe0000042,
+		0x60000002, 0x80000001, 0x00000020, 0x00000003, 0x40000052, 0x40000040, 0xe0000052, 0xa0000000,
+	}},
+	{[]int{45, 46, 47, 48, 49, 50, 51, 52, 53, 54, 55, 56}, [16]uint32{ // II(K,0)
+		0xec000014, 0x0c000002, 0xc0000010, 0xb400001c, 0x2c000004, 0xbc000018, 0xb0000010, 0x0000000c,
+		0xb8000010, 0x08000018, 0x78000010, 0x08000014, 0x70000010, 0xb800001c, 0xe8000000, 0xb0000004,
+	}},
+	{[]int{46, 49, 50, 51}, [16]uint32{ // II(K,2)
+		0x90000070, 0xb0000053, 0x30000008, 0x00000043, 0xd0000072, 0xb0000010, 0xf0000062, 0xc0000042,
+		0x00000030, 0xe0000042, 0x20000060, 0xe0000041, 0x20000050, 0xc0000041, 0xe0000072, 0xa0000003,
+	}},
+}
+
+var sha1DVs = makeSHA1DVs()
+
+// makeSHA1DVs expands sha1DVBases. A message difference is itself an
+// expanded message, so its 16 first words give the rest, and the
+// expansion run backwards gives the word before the first.
+func makeSHA1DVs() []sha1DV {
+	var dvs []sha1DV
+	for _, f := range sha1DVBases {
+		var w [80]uint32
+		copy(w[:], f.base[:])
+		sha1Expand(&w)
+		k := f.ks[0]
+		for _, want := range f.ks {
+			for ; k < want; k++ {
+				prev := bits.RotateLeft32(w[15], -1) ^ w[12] ^ w[7] ^ w[1]
+				copy(w[1:], w[:79])
+				w[0] = prev
+			}
+			dv := sha1DV{dm: w, testt: 58}
+			if k >= 50 {
+				dv.testt = 65
+			}
+			dvs = append(dvs, dv)
+		}
+	}
+	return dvs
+}
+
+func sha1Expand(w *[80]uint32) {
+	for i := 16; i < 80; i++ {
+		w[i] = bits.RotateLeft32(w[i-3]^w[i-8]^w[i-14]^w[i-16], 1)
+	}
+}
+
+// sha1Steps runs the steps from to to-1 of the compression of w on s.
+func sha1Steps(s *[5]uint32, w *[80]uint32, from, to int) {
+	a, b, c, d, e := s[0], s[1], s[2], s[3], s[4]
+	for i := from; i < to; i++ {
+		var f, k uint32
+		switch {
+		case i < 20:
+			f, k = b&c|^b&d, 0x5A827999
+		case i < 40:
+			f, k = b^c^d, 0x6ED9EBA1
+		case i < 60:
+			f, k = b&c|b&d|c&d, 0x8F1BBCDC
+		default:
+			f, k = b^c^d, 0xCA62C1D6
+		}
+		a, b, c, d, e = bits.RotateLeft32(a, 5)+f+e+k+w[i], a, bits.RotateLeft32(b, 30), c, d
+	}
+	s[0], s[1], s[2], s[3], s[4] = a, b, c, d, e
+}
+
+// sha1StepsBack undoes the steps from-1 down to 0 of the compression of w
+// on s, giving the state the compression started from.
+func sha1StepsBack(s *[5]uint32, w *[80]uint32, from int) {
+	a, b, c, d, e := s[0], s[1], s[2], s[3], s[4]
+	for i := from - 1; i >= 0; i-- {
+		// The state before the step was b, c>>>30, d, e and the e it
+		// replaced.
+		pb := bits.RotateLeft32(c, -30)
+		var f, k uint32
+		switch {
+		case i < 20:
+			f, k = pb&d|^pb&e, 0x5A827999
+		case i < 40:
+			f, k = pb^d^e, 0x6ED9EBA1
+		case i < 60:
+			f, k = pb&d|pb&e|d&e, 0x8F1BBCDC
+		default:
+			f, k = pb^d^e, 0xCA62C1D6
+		}
+		a, b, c, d, e = b, pb, d, e, a-bits.RotateLeft32(b, 5)-f-k-w[i]
+	}
+	s[0], s[1], s[2], s[3], s[4] = a, b, c, d, e
+}
+
+// SHA1DC is a SHA-1 hash.Hash that also reports, with Collision, whether
+// the data written to it contains half of a SHA-1 collision. The sum is
+// that of plain SHA-1.
+type SHA1DC struct {
+	ihv       [5]uint32
+	buf       [64]byte
+	n         int
+	total     uint64
+	collision bool
+	plain     bool // Skip the detection, for NewPureSHA1.
+}
+
+// NewSHA1DC returns a SHA-1 hash with collision detection.
+func NewSHA1DC() *SHA1DC {
+	d := &SHA1DC{}
+	d.Reset()
+	return d
+}
+
+// NewPureSHA1 returns SHA-1 in plain Go, without collision detection and
+// without the assembly of crypto/sha1, which it is slower than.
+func NewPureSHA1() hash.Hash {
+	d := NewSHA1DC()
+	d.plain = true
+	return d
+}
+
+// UsePureSHA1 makes the sha1 of Algorithms NewPureSHA1, e.g. to rule out
+// crypto/sha1's assembly when debugging. Call it before hashing starts.
+func UsePureSHA1() {
+	Algorithms["sha1"] = NewPureSHA1
+}
+
+func (d *SHA1DC) Size() int      { return sha1.Size }
+func (d *SHA1DC) BlockSize() int { return sha1.BlockSize }
+
+func (d *SHA1DC) Reset() {
+	d.ihv = [5]uint32{0x67452301, 0xEFCDAB89, 0x98BADCFE, 0x10325476, 0xC3D2E1F0}
+	d.n = 0
+	d.total = 0
+	d.collision = false
+}
+
+// Collision reports whether a block written so far is one half of a
+// near-collision pair, as in files crafted to share a SHA-1 sum.
+func (d *SHA1DC) Collision() bool {
+	return d.collision
+}
+
+func (d *SHA1DC) Write(p []byte) (int, error) {
+	n := len(p)
+	d.total += uint64(n)
+	for len(p) > 0 {
+		c := copy(d.buf[d.n:], p)
+		d.n += c
+		p = p[c:]
+		if d.n == 64 {
+			d.block(d.buf[:])
+			d.n = 0
+		}
+	}
+	return n, nil
+}
+
+func (d *SHA1DC) Sum(b []byte) []byte {
+	c := *d
+	var pad [72]byte
+	pad[0] = 0x80
+	padLen := 55 - d.n
+	if d.n > 55 {
+		padLen += 64
+	}
+	c.Write(pad[:padLen+1])
+	var length [8]byte
+	binary.BigEndian.PutUint64(length[:], d.total<<3)
+	c.Write(length[:])
+	d.collision = c.collision
+	for _, v := range c.ihv {
+		b = binary.BigEndian.AppendUint32(b, v)
+	}
+	return b
+}
+
+func (d *SHA1DC) block(p []byte) {
+	var m1 [80]uint32
+	for i := 0; i < 16; i++ {
+		m1[i] = binary.BigEndian.Uint32(p[4*i:])
+	}
+	sha1Expand(&m1)
+	// The states before steps 58 and 65, where the compression is redone
+	// from.
+	s := d.ihv
+	sha1Steps(&s, &m1, 0, 58)
+	at58 := s
+	sha1Steps(&s, &m1, 58, 65)
+	at65 := s
+	sha1Steps(&s, &m1, 65, 80)
+	for i := range d.ihv {
+		d.ihv[i] += s[i]
+	}
+	if d.collision || d.plain {
+		return
+	}
+	var m2 [80]uint32
+	for _, dv := range sha1DVs {
+		for i := range m2 {
+			m2[i] = m1[i] ^ dv.dm[i]
+		}
+		start := at58
+		if dv.testt == 65 {
+			start = at65
+		}
+		// The state the other block of a pair would have started from,
+		// and where it would end.
+		in2 := start
+		sha1StepsBack(&in2, &m2, dv.testt)
+		s := start
+		sha1Steps(&s, &m2, dv.testt, 80)
+		same := true
+		for i := range s {
+			if in2[i]+s[i] != d.ihv[i] {
+				same = false
+			}
+		}
+		if same {
+			d.collision = true
+			return
+		}
+	}
+}
@@ -0,0 +1,116 @@
+package hashwalk
+
+import (
+	"context"
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"fmt"
+	"hash"
+	"io"
+	"sort"
+	"strings"
+)
+
+// Algorithms are the hash algorithms by name.
+var Algorithms = map[string]func() hash.Hash{
+	"blake2b": newBlake2b,
+	"blake3":  newBlake3,
+	"crc32c":  newCRC32C,
+	"md5":     md5.New,
+	"sha1":    sha1.New,
+	"sha256":  sha256.New,
+	"sha512":  sha512.New,
+	"xxh64":   newXXH64,
+}
+
+// AlgorithmNames returns the sorted algorithm names, comma separated.
+func AlgorithmNames() string {
+	var names []string
+	for n := range Algorithms {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+	return strings.Join(names, ",")
+}
+
+// ParseAlgorithms parses a comma separated list of algorithm names.
+func ParseAlgorithms(s string) ([]string, error) {
+	var algos []string
+	for _, a := range strings.Split(s, ",") {
+		a = strings.ToLower(strings.TrimSpace(a))
+		if _, ok := Algorithms[a]; !ok {
+			return nil, fmt.Errorf("unknown hash algorithm %q, valid algorithms: %s", a, AlgorithmNames())
+		}
+		for _, b := range algos {
+			if a == b {
+				return nil, fmt.Errorf("hash algorithm %q given twice", a)
+			}
+		}
+		algos = append(algos, a)
+	}
+	return algos, nil
+}
+
+// NewHashes returns a new hash for each of the algos, which must be valid.
+func NewHashes(algos []string) []hash.Hash {
+	hs := make([]hash.Hash, len(algos))
+	for i, a := range algos {
+		hs[i] = Algorithms[a]()
+	}
+	return hs
+}
+
+// SumReader hashes everything read from r with each of the algos in a
+// single pass, also writing it to tee if it isn't nil.
+func SumReader(r io.Reader, algos []string, tee io.Writer) (sums [][]byte, written int64, err error) {
+	return sumReader(r, algos, tee, nil)
+}
+
+func sumReader(r io.Reader, algos []string, tee io.Writer, buf []byte) (sums [][]byte, written int64, err error) {
+	hs := NewHashes(algos)
+	ws := make([]io.Writer, 0, len(hs)+1)
+	for _, h := range hs {
+		ws = append(ws, h)
+	}
+	if tee != nil {
+		ws = append(ws, tee)
+	}
+	var w io.Writer = hs[0]
+	if len(ws) > 1 {
+		w = io.MultiWriter(ws...)
+	}
+	written, err = io.CopyBuffer(w, r, buf)
+	if nil != err {
+		return
+	}
+	for _, h := range hs {
+		sums = append(sums, h.Sum(nil))
+	}
+	return
+}
+
+// SumReaderContext is SumReader giving up with ctx's error once ctx is done.
+// ctx is checked before each read, a read that blocks isn't interrupted.
+func SumReaderContext(ctx context.Context, r io.Reader, algos []string, tee io.Writer) ([][]byte, int64, error) {
+	return SumReaderBuffer(ctx, r, algos, tee, nil)
+}
+
+// SumReaderBuffer is SumReaderContext reading into buf, so len(buf) is the
+// read size. A nil buf reads 32K at a time.
+func SumReaderBuffer(ctx context.Context, r io.Reader, algos []string, tee io.Writer, buf []byte) ([][]byte, int64, error) {
+	return sumReader(&ctxReader{ctx, r}, algos, tee, buf)
+}
+
+type ctxReader struct {
+	ctx context.Context
+	r   io.Reader
+}
+
+func (c *ctxReader) Read(p []byte) (int, error) {
+	if err := c.ctx.Err(); err != nil {
+		return 0, err
+	}
+	return c.r.Read(p)
+}
@@ -0,0 +1,49 @@
+package hashwalk
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// TimeoutError is the error of a file given up on after a timeout.
+type TimeoutError struct {
+	Path    string
+	Timeout time.Duration
+}
+
+func (e *TimeoutError) Error() string {
+	return fmt.Sprintf("%s: timed out after %v", e.Path, e.Timeout)
+}
+
+// WithTimeout runs sum, giving up on it after timeout if it is positive. A
+// read blocked on a hung mount can't be interrupted, so the abandoned sum is
+// left behind in its goroutine until the read returns, but the caller is
+// freed. The ctx passed to sum is cancelled then, so it stops at its next
+// read instead of reading the rest of the file.
+func WithTimeout(ctx context.Context, path string, timeout time.Duration,
+	sum func(ctx context.Context) ([][]byte, int64, error)) ([][]byte, int64, error) {
+	if timeout <= 0 {
+		return sum(ctx)
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	type hashed struct {
+		sums [][]byte
+		size int64
+		err  error
+	}
+	done := make(chan hashed, 1)
+	go func() {
+		sums, size, err := sum(ctx)
+		done <- hashed{sums, size, err}
+	}()
+	t := time.NewTimer(timeout)
+	defer t.Stop()
+	select {
+	case h := <-done:
+		return h.sums, h.size, h.err
+	case <-t.C:
+		return nil, 0, &TimeoutError{path, timeout}
+	}
+}
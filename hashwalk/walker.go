@@ -0,0 +1,385 @@
+package hashwalk
+
+import (
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// File is a file to hash found by a Walker, Info comes from the directory
+// listing.
+type File struct {
+	Path string
+	Info os.FileInfo
+	Root int    // Index of the root passed to Walker.Walk.
+	Err  error  // With KeepGoing, a directory that couldn't be read.
+	Link string // With Symlinks "target", the target hashed instead of a file.
+	// Path of an earlier found hard link to the same file, with HardLinks,
+	// which is hashed instead.
+	Alias string
+}
+
+// Stats counts what a walk left out.
+type Stats struct {
+	Hidden int // Dot directories skipped.
+	Cycles int // Directories reached again through a symlink.
+	// Files reached again through another hard link, not hashed again.
+	HardLinks int
+	Mounts    int // Mount points of other file systems left out.
+}
+
+// Dir is a directory being walked, as the hooks of WalkOptions see it.
+type Dir struct {
+	Path  string
+	Rel   string // Slash separated path relative to the root, "" for it.
+	Depth int    // Number of elements of Rel.
+	// State is for the hooks, e.g. the ignore rules in effect. It starts
+	// out as the parent directory's.
+	State interface{}
+}
+
+// Entry is a file, link or subdirectory found in a Dir.
+type Entry struct {
+	Path string
+	Rel  string
+	Info os.FileInfo // Of the target of a link followed with Symlinks "follow".
+	Link string      // With Symlinks "target", the target of the link.
+}
+
+// WalkOptions of a Walker, the zero value walks the regular files below
+// the roots, skips dot directories and symlinks, and aborts on a directory
+// it can't read.
+type WalkOptions struct {
+	Hidden bool // Also walk dot directories.
+	// What to do with symlinks and Windows junctions: "skip" (the default)
+	// leaves them out, "follow" walks what they point to and "target"
+	// passes the link on with the target path string to hash.
+	Symlinks string
+	FoldCase bool // Only walk one of the paths differing in case.
+	// Pass the links to a file after the first on with Alias set. With
+	// DirWorkers above 1, which link comes first depends on the order the
+	// directories are read in.
+	HardLinks     bool
+	OneFileSystem bool // Leave out mount points of other file systems.
+	// Pass directories that can't be read below the root on as files with
+	// Err set instead of aborting.
+	KeepGoing bool
+	MaxDepth  int // The deepest files walked, zero for no limit.
+	// Directories read at once, default 1. Walks with FoldCase or Symlinks
+	// "follow" are serial, so which of two paths comes first is
+	// deterministic.
+	DirWorkers int
+	Stats      *Stats // Counted into if set.
+
+	// EnterDir, if set, is called for each directory once it is opened,
+	// e.g. to read the ignore rules in it into d.State.
+	EnterDir func(d *Dir)
+	// Keep, if set, reports whether to walk an entry of d, files and links
+	// as well as subdirectories.
+	Keep func(d *Dir, e *Entry) bool
+	// EmptyDir, if set, is called for each directory without entries, one
+	// call at a time.
+	EmptyDir func(path string)
+	// Skipped, if set, is called for each directory left out for reason:
+	// "hidden", "symlink cycle" or "other file system".
+	Skipped func(path, reason string)
+}
+
+// Walker walks directory trees, sending the files to hash. Subdirectories
+// are walked by up to DirWorkers goroutines at once, mu guards the state
+// they share.
+type Walker struct {
+	ctx   context.Context
+	opts  WalkOptions
+	send  func(ctx context.Context, f File) error
+	root  int
+	stats *Stats
+	// Directories already walked, for Symlinks "follow".
+	visited map[string]bool
+	// Case folded paths already visited, for FoldCase.
+	folded map[string]bool
+	// First path seen of each file with several hard links.
+	inodes map[string]string
+	// Device of the current root, for OneFileSystem.
+	rootDev   uint64
+	rootDevOK bool
+
+	mu     sync.Mutex
+	wg     sync.WaitGroup
+	slots  chan struct{} // One per extra goroutine, nil walks serially.
+	err    error         // First error of the current root.
+	cancel context.CancelFunc
+}
+
+// NewWalker returns a walker calling send for each file found, which
+// should return ctx.Err() once ctx is done, and any other error to abort
+// the walk.
+func NewWalker(ctx context.Context, opts WalkOptions, send func(ctx context.Context, f File) error) *Walker {
+	w := &Walker{ctx: ctx, opts: opts, send: send, stats: opts.Stats}
+	if w.stats == nil {
+		w.stats = &Stats{}
+	}
+	if opts.FoldCase {
+		w.folded = make(map[string]bool)
+	}
+	if opts.Symlinks == "follow" {
+		w.visited = make(map[string]bool)
+	}
+	if opts.HardLinks {
+		w.inodes = make(map[string]string)
+	}
+	if n := opts.DirWorkers - 1; n > 0 && w.folded == nil && w.visited == nil {
+		w.slots = make(chan struct{}, n)
+	}
+	return w
+}
+
+// Walk walks the directory root, sending its files with index as their
+// Root, and waits for all the goroutines walking below it. The walker
+// remembers what it visited across calls, so a directory or hard link
+// reached again from a later root isn't walked or hashed twice.
+func (w *Walker) Walk(root string, index int) error {
+	parent := w.ctx
+	w.ctx, w.cancel = context.WithCancel(parent)
+	w.root = index
+	w.err = nil
+	w.rootDevOK = false
+	if w.opts.OneFileSystem {
+		if fi, err := os.Stat(root); err == nil {
+			w.rootDev, w.rootDevOK = DeviceID(fi)
+		}
+	}
+	w.fail(w.walkDir(&Dir{Path: root}))
+	w.wg.Wait()
+	w.cancel()
+	w.ctx = parent
+	return w.err
+}
+
+// seenFolded reports whether a path differing only in case was already
+// visited, so the same file on a case-insensitive volume is hashed once.
+func (w *Walker) seenFolded(p string) bool {
+	if w.folded == nil {
+		return false
+	}
+	k := strings.ToLower(p)
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.folded[k] {
+		return true
+	}
+	w.folded[k] = true
+	return false
+}
+
+// fail records the first error of the walk and stops the rest of it.
+func (w *Walker) fail(err error) {
+	if err == nil {
+		return
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.err == nil {
+		w.err = err
+		w.cancel()
+	}
+}
+
+func (w *Walker) skipped(path, reason string) {
+	if w.opts.Skipped != nil {
+		w.opts.Skipped(path, reason)
+	}
+}
+
+// otherFileSystem reports whether the directory fi is a mount point of
+// another file system that OneFileSystem leaves out.
+func (w *Walker) otherFileSystem(fi os.FileInfo) bool {
+	if !w.rootDevOK {
+		return false
+	}
+	if dev, ok := DeviceID(fi); !ok || dev == w.rootDev {
+		return false
+	}
+	w.mu.Lock()
+	w.stats.Mounts++
+	w.mu.Unlock()
+	return true
+}
+
+// hardLinkOf returns the path of an earlier walked hard link to the same
+// file as p, or an empty string if p is the first one seen.
+func (w *Walker) hardLinkOf(p string, fi os.FileInfo) string {
+	if w.inodes == nil || LinkCount(fi) < 2 {
+		return ""
+	}
+	id := FileID(p, fi)
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if first, ok := w.inodes[id]; ok {
+		w.stats.HardLinks++
+		return first
+	}
+	w.inodes[id] = p
+	return ""
+}
+
+// walkSubdir walks a subdirectory in a new goroutine if a slot is free and
+// in this one otherwise.
+func (w *Walker) walkSubdir(d *Dir) error {
+	select {
+	case w.slots <- struct{}{}:
+	default:
+		return w.walkDir(d)
+	}
+	w.wg.Add(1)
+	go func() {
+		defer w.wg.Done()
+		w.fail(w.walkDir(d))
+		<-w.slots
+	}()
+	return nil
+}
+
+// resolveLink applies Symlinks to the link (or Windows junction) at path:
+// skip leaves it out, follow returns what it points to and target returns
+// the link itself with its target, whose path string is hashed. Dangling
+// links are left out.
+func (w *Walker) resolveLink(path string, fi os.FileInfo) (os.FileInfo, string, bool) {
+	switch w.opts.Symlinks {
+	case "follow":
+		target, err := os.Stat(LongPath(path))
+		if err != nil {
+			return nil, "", false
+		}
+		return target, "", true
+	case "target":
+		target, err := os.Readlink(LongPath(path))
+		if err != nil {
+			return nil, "", false
+		}
+		return fi, target, true
+	}
+	return nil, "", false
+}
+
+// dirError aborts the walk, or with KeepGoing passes the error on as a
+// failed file and skips the directory. An unreadable root always aborts.
+func (w *Walker) dirError(d *Dir, err error) error {
+	if !w.opts.KeepGoing || d.Depth == 0 {
+		return err
+	}
+	return w.send(w.ctx, File{Path: d.Path, Root: w.root, Err: err})
+}
+
+// Directory entries are read in batches of this size, so huge flat
+// directories don't have to fit in memory at once.
+const readdirBatch = 1024
+
+// walkDir walks the directory d.
+func (w *Walker) walkDir(d *Dir) error {
+	if err := w.ctx.Err(); err != nil {
+		return err
+	}
+	if !w.opts.Hidden && IsDotPath(d.Path) {
+		w.mu.Lock()
+		w.stats.Hidden++
+		w.mu.Unlock()
+		w.skipped(d.Path, "hidden")
+		return nil
+	}
+	if w.visited != nil {
+		fi, err := os.Stat(LongPath(d.Path))
+		if err != nil {
+			return w.dirError(d, err)
+		}
+		id := FileID(d.Path, fi)
+		if w.visited[id] {
+			w.stats.Cycles++
+			w.skipped(d.Path, "symlink cycle")
+			return nil
+		}
+		w.visited[id] = true
+	}
+	dir, err := os.Open(LongPath(d.Path))
+	if err != nil {
+		return w.dirError(d, err)
+	}
+	defer dir.Close()
+	if w.opts.EnterDir != nil {
+		w.opts.EnterDir(d)
+	}
+	for n := 0; ; {
+		list, err := dir.Readdir(readdirBatch)
+		if err == io.EOF {
+			if n == 0 && w.opts.EmptyDir != nil {
+				w.mu.Lock()
+				w.opts.EmptyDir(d.Path)
+				w.mu.Unlock()
+			}
+			return nil
+		}
+		n += len(list)
+		if err != nil {
+			return w.dirError(d, err)
+		}
+		err = w.walkEntries(d, list)
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// walkEntries handles one batch of the entries of the directory d.
+func (w *Walker) walkEntries(d *Dir, list []os.FileInfo) error {
+	max := w.opts.MaxDepth
+	for _, f := range list {
+		e := Entry{Path: filepath.Join(d.Path, f.Name()), Rel: f.Name(), Info: f}
+		if w.seenFolded(e.Path) {
+			continue
+		}
+		if d.Rel != "" {
+			e.Rel = d.Rel + "/" + f.Name()
+		}
+		if f.Mode()&os.ModeSymlink != 0 || IsJunction(e.Path, f) {
+			var ok bool
+			e.Info, e.Link, ok = w.resolveLink(e.Path, f)
+			if !ok {
+				continue
+			}
+		}
+		if e.Link == "" && !e.Info.IsDir() && !e.Info.Mode().IsRegular() {
+			continue
+		}
+		if w.opts.Keep != nil && !w.opts.Keep(d, &e) {
+			continue
+		}
+		if !e.Info.IsDir() || e.Link != "" {
+			if max > 0 && d.Depth+1 > max {
+				continue
+			}
+			file := File{Path: e.Path, Info: e.Info, Root: w.root, Link: e.Link}
+			if e.Link == "" {
+				file.Alias = w.hardLinkOf(e.Path, e.Info)
+			}
+			if err := w.send(w.ctx, file); err != nil {
+				return err
+			}
+			continue
+		}
+		if max > 0 && d.Depth+2 > max {
+			continue
+		}
+		if w.otherFileSystem(e.Info) {
+			w.skipped(e.Path, "other file system")
+			continue
+		}
+		sub := &Dir{Path: e.Path, Rel: e.Rel, Depth: d.Depth + 1, State: d.State}
+		if err := w.walkSubdir(sub); err != nil {
+			return err
+		}
+	}
+	return nil
+}
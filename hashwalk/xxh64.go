@@ -0,0 +1,121 @@
+package hashwalk
+
+import (
+	"encoding/binary"
+	"hash"
+	"hash/crc32"
+	"math/bits"
+)
+
+// XXH64 with seed 0, as printed by xxhsum -H1, and CRC-32C, which the
+// standard library computes with the CPU's CRC32 instructions where there
+// are any. Both are far faster than the cryptographic sums and good enough
+// to find duplicates, but anyone can make a file with a given sum.
+
+const (
+	xxhPrime1 uint64 = 11400714785074694791
+	xxhPrime2 uint64 = 14029467366897019727
+	xxhPrime3 uint64 = 1609587929392839161
+	xxhPrime4 uint64 = 9650029242287828579
+	xxhPrime5 uint64 = 2870177450012600261
+)
+
+type xxh64 struct {
+	v     [4]uint64
+	total uint64
+	buf   [32]byte
+	n     int
+}
+
+func newXXH64() hash.Hash {
+	d := &xxh64{}
+	d.Reset()
+	return d
+}
+
+func newCRC32C() hash.Hash {
+	return crc32.New(crc32.MakeTable(crc32.Castagnoli))
+}
+
+func (d *xxh64) Size() int      { return 8 }
+func (d *xxh64) BlockSize() int { return 32 }
+
+func (d *xxh64) Reset() {
+	// The accumulators start at the seed, 0, plus and minus the primes, in
+	// wrapping arithmetic.
+	p1, p2 := xxhPrime1, xxhPrime2
+	d.v = [4]uint64{p1 + p2, p2, 0, -p1}
+	d.total = 0
+	d.n = 0
+}
+
+func xxhRound(acc, input uint64) uint64 {
+	acc += input * xxhPrime2
+	return bits.RotateLeft64(acc, 31) * xxhPrime1
+}
+
+func xxhMerge(acc, v uint64) uint64 {
+	acc ^= xxhRound(0, v)
+	return acc*xxhPrime1 + xxhPrime4
+}
+
+func (d *xxh64) blocks(p []byte) {
+	for ; len(p) >= 32; p = p[32:] {
+		for i := range d.v {
+			d.v[i] = xxhRound(d.v[i], binary.LittleEndian.Uint64(p[8*i:]))
+		}
+	}
+}
+
+func (d *xxh64) Write(p []byte) (int, error) {
+	n := len(p)
+	d.total += uint64(n)
+	if d.n > 0 {
+		c := copy(d.buf[d.n:], p)
+		d.n += c
+		p = p[c:]
+		if d.n < 32 {
+			return n, nil
+		}
+		d.blocks(d.buf[:])
+		d.n = 0
+	}
+	full := len(p) &^ 31
+	d.blocks(p[:full])
+	d.n = copy(d.buf[:], p[full:])
+	return n, nil
+}
+
+func (d *xxh64) Sum(b []byte) []byte {
+	var h uint64
+	if d.total >= 32 {
+		h = bits.RotateLeft64(d.v[0], 1) + bits.RotateLeft64(d.v[1], 7) +
+			bits.RotateLeft64(d.v[2], 12) + bits.RotateLeft64(d.v[3], 18)
+		for _, v := range d.v {
+			h = xxhMerge(h, v)
+		}
+	} else {
+		h = d.v[2] + xxhPrime5
+	}
+	h += d.total
+	p := d.buf[:d.n]
+	for ; len(p) >= 8; p = p[8:] {
+		h ^= xxhRound(0, binary.LittleEndian.Uint64(p))
+		h = bits.RotateLeft64(h, 27)*xxhPrime1 + xxhPrime4
+	}
+	if len(p) >= 4 {
+		h ^= uint64(binary.LittleEndian.Uint32(p)) * xxhPrime1
+		h = bits.RotateLeft64(h, 23)*xxhPrime2 + xxhPrime3
+		p = p[4:]
+	}
+	for _, c := range p {
+		h ^= uint64(c) * xxhPrime5
+		h = bits.RotateLeft64(h, 11) * xxhPrime1
+	}
+	h ^= h >> 33
+	h *= xxhPrime2
+	h ^= h >> 29
+	h *= xxhPrime3
+	h ^= h >> 32
+	return binary.BigEndian.AppendUint64(b, h)
+}
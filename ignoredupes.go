@@ -0,0 +1,129 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// intendedDupes holds the copies -ignore-dupes-file says are meant to be
+// there, nil without it.
+var intendedDupes *dupeAllowList
+
+// dupeAllowList is an -ignore-dupes-file: sums whose copies are all
+// intended, like a license file in every package, and pairs of absolute
+// paths that are meant to be the same, like a test fixture and its
+// original.
+type dupeAllowList struct {
+	sums  map[string]bool
+	pairs map[[2]string]bool
+}
+
+// loadIgnoreDupes reads path, a line per sum of algo in any -encoding or
+// per pair of paths separated by a tab, relative ones to the current
+// directory. Empty lines and lines starting with # are left out.
+func loadIgnoreDupes(path, algo string) (*dupeAllowList, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	l := &dupeAllowList{sums: make(map[string]bool), pairs: make(map[[2]string]bool)}
+	size := hexSize(algo) / 2
+	sc := bufio.NewScanner(f)
+	n := 0
+	for sc.Scan() {
+		n++
+		line := strings.TrimSpace(sc.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if i := strings.Index(line, "\t"); i >= 0 {
+			pa, err1 := filepath.Abs(strings.TrimSpace(line[:i]))
+			pb, err2 := filepath.Abs(strings.TrimSpace(line[i+1:]))
+			if err1 != nil || err2 != nil {
+				return nil, fmt.Errorf("%s:%d: invalid path pair", path, n)
+			}
+			l.pairs[[2]string{pa, pb}] = true
+			l.pairs[[2]string{pb, pa}] = true
+			continue
+		}
+		sumAlgo, sum, ok := decodeSum(line)
+		if !ok || len(sum) != size || sumAlgo != "" && sumAlgo != algo {
+			return nil, fmt.Errorf("%s:%d: %q is neither a %s sum nor two paths separated by a tab", path, n, line, algo)
+		}
+		l.sums[string(sum)] = true
+	}
+	return l, sc.Err()
+}
+
+// copies returns the files of g, a group with one sum, that count as
+// copies: only the first if the sum is allowed, else the first of each set
+// of files joined by allowed pairs, so a third file with the same content
+// is still a duplicate. A nil list keeps g.
+func (l *dupeAllowList) copies(g resultSlice) resultSlice {
+	if l == nil || len(g) < 2 {
+		return g
+	}
+	if l.sums[string(g[0].Sum)] {
+		return g[:1]
+	}
+	if len(l.pairs) == 0 {
+		return g
+	}
+	abs := make([]string, len(g))
+	for i, r := range g {
+		abs[i], _ = filepath.Abs(r.Path)
+	}
+	// set[i] is the first file of the set of file i.
+	set := make([]int, len(g))
+	for i := range g {
+		set[i] = i
+		for j := 0; j < i; j++ {
+			if !l.pairs[[2]string{abs[j], abs[i]}] {
+				continue
+			}
+			if set[i] == i {
+				set[i] = set[j]
+				continue
+			}
+			// i joins two sets, the later takes the first of the earlier.
+			from, to := set[i], set[j]
+			if from < to {
+				from, to = to, from
+			}
+			for k := 0; k <= i; k++ {
+				if set[k] == from {
+					set[k] = to
+				}
+			}
+		}
+	}
+	var out resultSlice
+	for i, r := range g {
+		if set[i] == i {
+			out = append(out, r)
+		}
+	}
+	return out
+}
+
+// withoutIntended drops the intended copies from groups, and the groups
+// left with a single file.
+func withoutIntended(groups []resultSlice) []resultSlice {
+	var out []resultSlice
+	var dropped int
+	for _, g := range groups {
+		c := intendedDupes.copies(g)
+		dropped += len(g) - len(c)
+		if len(c) > 1 {
+			out = append(out, c)
+		}
+	}
+	if dropped > 0 {
+		log("Intended     :", dropped, "copies not counted as duplicates (-ignore-dupes-file)")
+	}
+	return out
+}
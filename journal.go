@@ -0,0 +1,237 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"github.com/rajder/gosha1/hashwalk"
+	"os"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+const journalHeader = "# gosha1 journal v1"
+
+// journalSync is how often the journal is flushed and synced to disk, what
+// was hashed since is lost in a crash.
+const journalSync = time.Second
+
+// journal is the -checkpoint file, where the sums of the files are appended
+// as they are hashed. After a crash or a power failure, -resume takes them
+// from it instead of hashing the files again. Lines are like the cache's,
+// with all sums: sum[,sum...], size, mtime in ns and absolute path, tab
+// separated.
+type journal struct {
+	path  string
+	f     *os.File
+	bw    *bufio.Writer
+	algos string
+	last  time.Time
+	err   error // The first write error, reported by close.
+}
+
+// journalEntry is a file hashed by an earlier, interrupted run.
+type journalEntry struct {
+	Sums    [][]byte
+	Size    int64
+	ModTime time.Time
+}
+
+// resumed counts the files whose sums were taken from the journal.
+var resumed int64
+
+// openJournal creates the checkpoint file for algos, or with resume opens it
+// to append and returns the files hashed so far. Without resume an existing
+// checkpoint is an error, so that it isn't lost by forgetting -resume.
+func openJournal(path string, algos []string, resume bool) (*journal, map[string]journalEntry, error) {
+	j := &journal{path: path, algos: strings.Join(algos, ",")}
+	done := make(map[string]journalEntry)
+	header := false
+	if resume {
+		var err error
+		header, err = j.load(done)
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+	flags := os.O_WRONLY | os.O_CREATE | os.O_APPEND
+	if !resume {
+		flags |= os.O_EXCL
+	}
+	f, err := os.OpenFile(path, flags, 0666)
+	if errors.Is(err, os.ErrExist) {
+		return nil, nil, fmt.Errorf("%s exists, continue the interrupted run with -resume or remove it", path)
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	j.f, j.bw, j.last = f, bufio.NewWriter(f), time.Now()
+	if !header {
+		// On disk at once, a crash before the first sync would leave a
+		// journal the next -resume can't read.
+		fmt.Fprintln(j.bw, journalHeader)
+		fmt.Fprintln(j.bw, "# algos:", j.algos)
+		j.sync()
+		if j.err != nil {
+			f.Close()
+			return nil, nil, fmt.Errorf("%s: %v", path, j.err)
+		}
+	}
+	return j, done, nil
+}
+
+// load adds the files hashed so far to done and reports whether the
+// journal has its header, a missing or empty one has neither. The last line
+// may be cut short by the crash and is ignored.
+func (j *journal) load(done map[string]journalEntry) (bool, error) {
+	f, err := os.Open(j.path)
+	if os.IsNotExist(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	defer f.Close()
+	sc := bufio.NewScanner(f)
+	sc.Buffer(make([]byte, 64*1024), 1024*1024)
+	n := 0
+	for sc.Scan() {
+		n++
+		line := sc.Text()
+		if n == 1 && line != journalHeader {
+			return false, fmt.Errorf("%s: not a gosha1 journal file", j.path)
+		}
+		if strings.HasPrefix(line, "# algos:") {
+			if algos := strings.TrimSpace(strings.TrimPrefix(line, "# algos:")); algos != j.algos {
+				return false, fmt.Errorf("%s has %s sums, resume with -algos %s", j.path, algos, algos)
+			}
+			continue
+		}
+		if n == 1 || line == "" {
+			continue
+		}
+		e, path, ok := parseJournalLine(line, len(strings.Split(j.algos, ",")))
+		if !ok {
+			logWarning(fmt.Sprintf("%s:%d: malformed journal line, ignored", j.path, n))
+			continue
+		}
+		done[path] = e
+	}
+	return n > 0, sc.Err()
+}
+
+func parseJournalLine(line string, nalgos int) (journalEntry, string, bool) {
+	cols := strings.SplitN(line, "\t", 4)
+	if len(cols) != 4 {
+		return journalEntry{}, "", false
+	}
+	var e journalEntry
+	for _, s := range strings.Split(cols[0], ",") {
+		sum, err := hex.DecodeString(s)
+		if err != nil || len(sum) == 0 {
+			return journalEntry{}, "", false
+		}
+		e.Sums = append(e.Sums, sum)
+	}
+	size, err1 := strconv.ParseInt(cols[1], 10, 64)
+	mtime, err2 := strconv.ParseInt(cols[2], 10, 64)
+	if err1 != nil || err2 != nil || len(e.Sums) != nalgos {
+		return journalEntry{}, "", false
+	}
+	e.Size, e.ModTime = size, time.Unix(0, mtime)
+	return e, cols[3], true
+}
+
+// add appends the sums of r, syncing the journal every journalSync.
+func (j *journal) add(r result) {
+	if j.err != nil {
+		return
+	}
+	k, err := cacheKey(r.Path)
+	if err != nil {
+		j.err = err
+		return
+	}
+	sums := make([]string, len(r.Sums))
+	for i, s := range r.Sums {
+		sums[i] = hex.EncodeToString(s)
+	}
+	fmt.Fprintf(j.bw, "%s\t%d\t%d\t%s\n", strings.Join(sums, ","), r.Size, r.ModTime.UnixNano(), k)
+	if time.Since(j.last) >= journalSync {
+		j.sync()
+	}
+}
+
+func (j *journal) sync() {
+	if err := j.bw.Flush(); err != nil && j.err == nil {
+		j.err = err
+	}
+	if err := j.f.Sync(); err != nil && j.err == nil {
+		j.err = err
+	}
+	j.last = time.Now()
+}
+
+// tee adds the results of in to the journal on their way to the caller.
+// Results from the journal or a cache needn't be added again, and failed
+// files are hashed again when resuming.
+func (j *journal) tee(ctx context.Context, in *hashwalk.Scan[result]) *hashwalk.Scan[result] {
+	res := make(chan result)
+	out := hashwalk.NewScan(res)
+	go func() {
+		defer close(res)
+		for r := range in.Results {
+			if r.Err == nil && !r.Cached && r.Alias == "" && len(r.Sums) > 0 {
+				j.add(r)
+			}
+			select {
+			case res <- r:
+			case <-ctx.Done():
+			}
+		}
+		j.sync()
+		out.CopyErrors(in)
+	}()
+	return out
+}
+
+// close closes the journal and, when the run completed, removes it.
+func (j *journal) close(completed bool) error {
+	err := j.bw.Flush()
+	if cerr := j.f.Close(); err == nil {
+		err = cerr
+	}
+	if j.err != nil {
+		err = j.err
+	}
+	if err != nil {
+		return fmt.Errorf("%s: %v", j.path, err)
+	}
+	if completed {
+		return os.Remove(j.path)
+	}
+	logWarning("the sums hashed so far are in", j.path+", continue with -resume")
+	return nil
+}
+
+// lookupJournal returns the sums of the file at path from the journal if
+// its size and mtime are unchanged.
+func lookupJournal(done map[string]journalEntry, path string, info os.FileInfo) ([][]byte, bool) {
+	if len(done) == 0 {
+		return nil, false
+	}
+	k, err := cacheKey(path)
+	if err != nil {
+		return nil, false
+	}
+	e, ok := done[k]
+	if !ok || e.Size != info.Size() || !e.ModTime.Equal(info.ModTime()) {
+		return nil, false
+	}
+	atomic.AddInt64(&resumed, 1)
+	return e.Sums, true
+}
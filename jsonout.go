@@ -0,0 +1,159 @@
+package main
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// fileRecord is a result as written with -format json and to -listen
+// clients, one JSON object per line.
+type fileRecord struct {
+	Path    string            `json:"path"`
+	Sum     string            `json:"sum,omitempty"`
+	Sums    map[string]string `json:"sums,omitempty"`
+	Size    int64             `json:"size"`
+	ModTime time.Time         `json:"mtime"`
+	Entropy float64           `json:"entropy,omitempty"`
+	Pieces  []string          `json:"pieces,omitempty"`
+	Error   string            `json:"error,omitempty"`
+	Class   string            `json:"error_class,omitempty"` // See errorClass.
+	Alias   string            `json:"alias,omitempty"`
+	Retries int               `json:"retries,omitempty"`
+	Meta    string            `json:"meta,omitempty"`
+	Seconds float64           `json:"seconds,omitempty"` // Time taken to hash the file, with -timings.
+	// Allocated is only known with -sparse.
+	Allocated *int64 `json:"allocated,omitempty"`
+}
+
+func newFileRecord(basepath string, r *result) fileRecord {
+	rec := fileRecord{
+		Path:    relPath(basepath, r.Path),
+		Size:    r.Size,
+		ModTime: r.ModTime,
+		Entropy: r.Entropy,
+		Retries: r.Retries,
+	}
+	if opts.timings {
+		rec.Seconds = r.Duration.Seconds()
+	}
+	if opts.sparse && r.Err == nil {
+		alloc := r.Allocated
+		rec.Allocated = &alloc
+	}
+	if r.Alias != "" {
+		rec.Alias = relPath(basepath, r.Alias)
+	}
+	if r.Err != nil {
+		rec.Error = r.Err.Error()
+		rec.Class = errorClass(r.Err)
+	} else {
+		rec.Sum = encodeSum(opts.algos[0], r.Sum)
+		if r.Meta != nil {
+			rec.Meta = encodeSum(opts.algos[0], r.Meta)
+		}
+		if len(r.Sums) > 1 {
+			rec.Sums = make(map[string]string, len(r.Sums))
+			for i, s := range r.Sums {
+				rec.Sums[opts.algos[i]] = encodeSum(opts.algos[i], s)
+			}
+		}
+		for _, p := range r.Pieces {
+			rec.Pieces = append(rec.Pieces, hex.EncodeToString(p))
+		}
+	}
+	return rec
+}
+
+// printJSON writes an object per file, the failed ones with their error,
+// followed by {"summary": {...}} with the duplicate stats.
+func printJSON(basepath string, rs, failed resultSlice) error {
+	enc := json.NewEncoder(stdout)
+	ordered := outputOrder(rs)
+	for i := range ordered {
+		if err := enc.Encode(newFileRecord(basepath, &ordered[i])); err != nil {
+			return err
+		}
+	}
+	for i := range failed {
+		r := failed[i]
+		if r.Path = failedPath(&r); r.Path == "" {
+			continue
+		}
+		if err := enc.Encode(newFileRecord(basepath, &r)); err != nil {
+			return err
+		}
+	}
+	err := enc.Encode(struct {
+		Summary summary `json:"summary"`
+	}{summarize(rs)})
+	if err != nil {
+		return err
+	}
+	printSummary(rs)
+	return nil
+}
+
+// printSummaryOnly prints the stats for -summary instead of a line per file:
+// "key<TAB>value" lines, or with -format json the {"summary": {...}} object.
+func printSummaryOnly(s summary) error {
+	if opts.format == "json" {
+		return json.NewEncoder(stdout).Encode(struct {
+			Summary summary `json:"summary"`
+		}{s})
+	}
+	printLine("files\t%d", s.Files)
+	printLine("unique\t%d", s.Unique)
+	printLine("duplicates\t%d", s.Duplicates)
+	printLine("duplicate_bytes\t%d", s.DupBytes)
+	printLine("total_bytes\t%d", s.TotalBytes)
+	return nil
+}
+
+// runSummary is what -summary-json writes at the end of a run.
+type runSummary struct {
+	summary
+	Errors      int     `json:"errors"`
+	WallSeconds float64 `json:"wall_seconds"`
+	MBPerSecond float64 `json:"mb_per_second"`
+	Interrupted bool    `json:"interrupted"`
+	Error       string  `json:"error,omitempty"`
+}
+
+// writeRunSummary writes the run summary as one JSON object to path, or to
+// the inherited file descriptor N for a path of the form fd:N.
+func writeRunSummary(path string, s summary, failed []result, start time.Time, runErr error) error {
+	wall := time.Since(start).Seconds()
+	rs := runSummary{summary: s, Errors: len(failed), WallSeconds: wall}
+	if wall > 0 {
+		rs.MBPerSecond = float64(s.TotalBytes) / 1024 / 1024 / wall
+	}
+	if runErr != nil {
+		rs.Interrupted = errors.Is(runErr, errInterrupted) || errors.Is(runErr, errDeadline)
+		rs.Error = runErr.Error()
+	}
+	var f *os.File
+	var err error
+	if strings.HasPrefix(path, "fd:") {
+		fd, perr := strconv.Atoi(strings.TrimPrefix(path, "fd:"))
+		if perr != nil {
+			return fmt.Errorf("invalid -summary-json %q", path)
+		}
+		f, err = openFd(fd)
+	} else {
+		f, err = os.Create(path)
+	}
+	if err != nil {
+		return err
+	}
+	err = json.NewEncoder(f).Encode(rs)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
@@ -0,0 +1,154 @@
+package main
+
+import (
+	"path/filepath"
+	"strings"
+)
+
+// keepPlan is what to do with one duplicate group: keep one file and remove
+// (delete or link) the others. Files in neither list are left alone.
+type keepPlan struct {
+	Keep   *result
+	Remove []*result
+}
+
+// planGroup picks the file to keep in a duplicate group. With -keep-under a
+// file below that directory wins and only copies outside it are removed.
+// Among the candidates a file under the earliest -keep-prefix wins, then the
+// -keep policy (shortest path, oldest or newest mtime) decides, then the
+// first in sort order.
+func planGroup(group resultSlice) (keepPlan, error) {
+	var under []bool
+	anyUnder := false
+	if opts.keepUnder != "" {
+		root, err := filepath.Abs(opts.keepUnder)
+		if err != nil {
+			return keepPlan{}, err
+		}
+		under = make([]bool, len(group))
+		for i := range group {
+			p, err := filepath.Abs(group[i].Path)
+			if err != nil {
+				return keepPlan{}, err
+			}
+			under[i] = isUnder(p, root)
+			anyUnder = anyUnder || under[i]
+		}
+	}
+	rank := make([]int, len(group))
+	for i := range group {
+		r, err := prefixRank(group[i].Path)
+		if err != nil {
+			return keepPlan{}, err
+		}
+		rank[i] = r
+	}
+	candidate := func(i int) bool {
+		return !anyUnder || under[i]
+	}
+	keep := -1
+	for i := range group {
+		if !candidate(i) {
+			continue
+		}
+		if keep < 0 || rank[i] < rank[keep] ||
+			rank[i] == rank[keep] && keepBefore(&group[i], &group[keep]) {
+			keep = i
+		}
+	}
+	plan := keepPlan{Keep: &group[keep]}
+	for i := range group {
+		if i == keep || (anyUnder && under[i]) {
+			continue
+		}
+		plan.Remove = append(plan.Remove, &group[i])
+	}
+	return plan, nil
+}
+
+// prefixRank is the index of the first -keep-prefix directory p is under,
+// or the number of prefixes if it is under none.
+func prefixRank(p string) (int, error) {
+	if len(opts.keepPrefix) == 0 {
+		return 0, nil
+	}
+	abs, err := filepath.Abs(p)
+	if err != nil {
+		return 0, err
+	}
+	for i, prefix := range opts.keepPrefix {
+		root, err := filepath.Abs(prefix)
+		if err != nil {
+			return 0, err
+		}
+		if isUnder(abs, root) {
+			return i, nil
+		}
+	}
+	return len(opts.keepPrefix), nil
+}
+
+// keepBefore reports whether a is strictly preferred over b by -keep.
+func keepBefore(a, b *result) bool {
+	switch opts.keep {
+	case "oldest":
+		return a.ModTime.Before(b.ModTime)
+	case "newest":
+		return a.ModTime.After(b.ModTime)
+	}
+	return len(a.Path) < len(b.Path)
+}
+
+// printKeepUnder prints the copies that are redundant given -keep-under, one
+// "sum<TAB>path" line each, and how much space removing them would reclaim.
+func printKeepUnder(basepath string, rs resultSlice) error {
+	var n int
+	var reclaim int64
+	for _, g := range reportedGroups(rs) {
+		plan, err := planGroup(g)
+		if err != nil {
+			return err
+		}
+		for _, r := range plan.Remove {
+			printLine("%x\t%s", r.Sum, relPath(basepath, r.Path))
+			n++
+			reclaim += r.Size
+		}
+	}
+	found = found || n > 0
+	log("Redundant    :", n)
+	log("Reclaim MB   :", float64(reclaim)/1024/1024)
+	return nil
+}
+
+// printAnnotated prints every file of each duplicate group with the action
+// the keep policy suggests for it: KEEP for the original, LEAVE for other
+// copies under -keep-under and DELETE or LINK (per -annotate) for the
+// redundant ones. Nothing is changed on disk.
+func printAnnotated(basepath string, rs resultSlice) error {
+	action := strings.ToUpper(opts.annotate)
+	groups := reportedGroups(rs)
+	found = found || len(groups) > 0
+	for _, g := range groups {
+		plan, err := planGroup(g)
+		if err != nil {
+			return err
+		}
+		remove := make(map[*result]bool, len(plan.Remove))
+		for _, r := range plan.Remove {
+			remove[r] = true
+		}
+		for i := range g {
+			r := &g[i]
+			label := "LEAVE"
+			if r == plan.Keep {
+				label = "KEEP"
+			} else if remove[r] {
+				label = action
+			}
+			printLine("%s\t%x\t%s", label, r.Sum, relPath(basepath, r.Path))
+		}
+	}
+	printSummary(rs)
+	return nil
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/hex"
+	"fmt"
+	"github.com/rajder/gosha1/hashwalk"
+	"io"
+	"os"
+	"strings"
+)
+
+// knownSet holds the sums of -known-hashes, nil without it.
+var knownSet map[string]bool
+
+// nsrlColumns maps the algorithms to their column in an NSRL RDS
+// NSRLFile.txt.
+var nsrlColumns = map[string]string{"sha1": "SHA-1", "md5": "MD5", "sha256": "SHA-256"}
+
+// loadKnownHashes reads the sums of algo from path, either a plain list
+// with a hex sum at the start of each line, like sha1sum output, or an NSRL
+// RDS file, a CSV whose header names the SHA-1, MD5 and other columns.
+func loadKnownHashes(path, algo string) (map[string]bool, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	br := bufio.NewReader(f)
+	head, err := br.Peek(len(`"SHA-1"`))
+	if err == nil && string(head) == `"SHA-1"` {
+		return loadNSRL(br, path, algo)
+	}
+	size := hexSize(algo)
+	set := make(map[string]bool)
+	sc := bufio.NewScanner(br)
+	n := 0
+	for sc.Scan() {
+		n++
+		line := strings.TrimSpace(sc.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		h := strings.ToLower(strings.Fields(line)[0])
+		if len(h) != size {
+			return nil, fmt.Errorf("%s:%d: %q isn't a %s sum", path, n, h, algo)
+		}
+		sum, err := hex.DecodeString(h)
+		if err != nil {
+			return nil, fmt.Errorf("%s:%d: %q isn't a %s sum", path, n, h, algo)
+		}
+		set[string(sum)] = true
+	}
+	return set, sc.Err()
+}
+
+func loadNSRL(r io.Reader, path, algo string) (map[string]bool, error) {
+	cr := csv.NewReader(r)
+	cr.ReuseRecord = true
+	cr.FieldsPerRecord = -1
+	header, err := cr.Read()
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	col := -1
+	for i, name := range header {
+		if name == nsrlColumns[algo] {
+			col = i
+		}
+	}
+	if col < 0 {
+		return nil, fmt.Errorf("%s: the NSRL file has no %s column", path, algo)
+	}
+	set := make(map[string]bool)
+	for {
+		rec, err := cr.Read()
+		if err == io.EOF {
+			return set, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", path, err)
+		}
+		if col >= len(rec) {
+			continue
+		}
+		sum, err := hex.DecodeString(rec[col])
+		if err != nil {
+			line, _ := cr.FieldPos(col)
+			return nil, fmt.Errorf("%s:%d: %q isn't a %s sum", path, line, rec[col], algo)
+		}
+		set[string(sum)] = true
+	}
+}
+
+// hexSize is the length of a hex sum of algo.
+func hexSize(algo string) int {
+	return 2 * hashwalk.NewHashes([]string{algo})[0].Size()
+}
+
+// filterKnown keeps the results in the -known-hashes set with -known-show
+// known, and those not in it with unknown.
+func filterKnown(rs resultSlice) resultSlice {
+	kept := rs[:0]
+	known := 0
+	for _, r := range rs {
+		isKnown := knownSet[string(r.Sum)]
+		if isKnown {
+			known++
+		}
+		if isKnown == (opts.knownShow == "known") {
+			kept = append(kept, r)
+		}
+	}
+	log("Known        :", known, "of", len(rs), "files")
+	return kept
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"os"
+	"runtime"
+	"runtime/debug"
+)
+
+// numCPU is the number of CPUs the workers can use, the CPUs of the
+// machine until applyLimits has looked at the cgroup.
+var numCPU = runtime.NumCPU()
+
+// resultCost is roughly what a result kept for the output takes in memory,
+// path and sums included, to turn -max-memory into a -spill-at.
+const resultCost = 1 << 10
+
+// applyLimits fits the run into the limits of its container. Unless
+// GOMAXPROCS is set in the environment, numCPU, and so the default worker
+// count, and GOMAXPROCS follow the CPU quota of the cgroup, rounded up,
+// when it is below the CPUs of the machine: a job allowed 2 of 64 CPUs
+// running 64 workers is only throttled. -max-memory becomes the soft
+// memory limit of the garbage collector and, when only the plain file
+// list is printed, a -spill-at keeping half of it for results.
+func applyLimits() {
+	if os.Getenv("GOMAXPROCS") != "" {
+		numCPU = runtime.GOMAXPROCS(0)
+	} else if quota, ok := cgroupCPUs(); ok {
+		n := int((quota + 999) / 1000)
+		if n < numCPU {
+			logVerbose("CPU quota    :", float64(quota)/1000, "CPUs of", numCPU)
+			numCPU = n
+			runtime.GOMAXPROCS(n)
+		}
+	}
+	if opts.maxMemory == 0 {
+		if limit, ok := cgroupMemory(); ok {
+			logVerbose("Memory limit :", limit, "bytes in the cgroup, -max-memory isn't set")
+		}
+		return
+	}
+	debug.SetMemoryLimit(int64(opts.maxMemory))
+	if opts.spillAt > 0 || opts.stream {
+		return
+	}
+	if !opts.spillable() {
+		logVerbose("Max memory   : all results are kept for this output, only the collector is limited")
+		return
+	}
+	opts.spillAt = int(opts.maxMemory / 2 / resultCost)
+	if opts.spillAt < 1 {
+		opts.spillAt = 1
+	}
+	logVerbose("Max memory   : spilling results beyond", opts.spillAt)
+}
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/rajder/gosha1/hashwalk"
+	"net"
+	"strings"
+	"sync"
+)
+
+// streamBacklog is how many results a client may fall behind before it is
+// dropped, a slow dashboard must not stall the scan.
+const streamBacklog = 1024
+
+// streamer sends the results of a running scan as JSONL to every client
+// connected to its listener. Clients may come and go during the scan, each
+// only receives the results hashed while it is connected.
+type streamer struct {
+	ln       net.Listener
+	basepath string
+
+	mu      sync.Mutex
+	clients map[chan []byte]bool
+	closed  bool // Set by close, later clients are turned away.
+	wg      sync.WaitGroup
+}
+
+// listenNetwork picks a unix socket for addresses that look like a path and
+// TCP for everything else, e.g. ":9000" or "localhost:9000".
+func listenNetwork(addr string) string {
+	if strings.Contains(addr, "/") {
+		return "unix"
+	}
+	return "tcp"
+}
+
+func newStreamer(addr, basepath string) (*streamer, error) {
+	ln, err := net.Listen(listenNetwork(addr), addr)
+	if err != nil {
+		return nil, err
+	}
+	st := &streamer{ln: ln, basepath: basepath, clients: make(map[chan []byte]bool)}
+	go st.accept()
+	return st, nil
+}
+
+func (st *streamer) accept() {
+	for {
+		conn, err := st.ln.Accept()
+		if err != nil {
+			return
+		}
+		ch := make(chan []byte, streamBacklog)
+		st.mu.Lock()
+		if st.closed {
+			st.mu.Unlock()
+			conn.Close()
+			return
+		}
+		st.clients[ch] = true
+		st.wg.Add(1)
+		st.mu.Unlock()
+		go st.serve(conn, ch)
+	}
+}
+
+// serve writes the queued lines to conn until the queue is closed or the
+// client goes away.
+func (st *streamer) serve(conn net.Conn, ch chan []byte) {
+	defer st.wg.Done()
+	defer conn.Close()
+	for line := range ch {
+		_, err := conn.Write(line)
+		if err != nil {
+			st.drop(ch)
+			for range ch {
+			}
+			return
+		}
+	}
+}
+
+func (st *streamer) drop(ch chan []byte) {
+	st.mu.Lock()
+	if st.clients[ch] {
+		delete(st.clients, ch)
+		close(ch)
+	}
+	st.mu.Unlock()
+}
+
+func (st *streamer) send(r result) {
+	line, err := json.Marshal(newFileRecord(st.basepath, &r))
+	if err != nil {
+		return
+	}
+	line = append(line, '\n')
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	for ch := range st.clients {
+		select {
+		case ch <- line:
+		default:
+			logWarning("-listen client too slow, disconnected")
+			delete(st.clients, ch)
+			close(ch)
+		}
+	}
+}
+
+// tee returns a scan delivering the same results as in after sending each
+// of them to the clients.
+func (st *streamer) tee(ctx context.Context, in *hashwalk.Scan[result]) *hashwalk.Scan[result] {
+	res := make(chan result)
+	out := hashwalk.NewScan(res)
+	go func() {
+		defer close(res)
+		for r := range in.Results {
+			st.send(r)
+			select {
+			case res <- r:
+			case <-ctx.Done():
+			}
+		}
+		out.CopyErrors(in)
+	}()
+	return out
+}
+
+// close stops accepting clients and waits for the connected ones to receive
+// everything queued for them.
+func (st *streamer) close() error {
+	err := st.ln.Close()
+	st.mu.Lock()
+	st.closed = true
+	for ch := range st.clients {
+		delete(st.clients, ch)
+		close(ch)
+	}
+	st.mu.Unlock()
+	st.wg.Wait()
+	return err
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"time"
+)
+
+// logLevel is how much is written to stderr, set with -q, -v and -vv.
+type logLevel int
+
+const (
+	levelQuiet   logLevel = iota // Only errors and warnings.
+	levelNormal                  // Also the totals and the status lines.
+	levelVerbose                 // Also the roots walked and what was skipped.
+	levelDebug                   // Also a line per file opened and read.
+)
+
+var verbosity = levelNormal
+
+// setVerbosity applies -q, -v and -vv, the highest of -v and -vv wins.
+func setVerbosity() {
+	switch {
+	case opts.debug:
+		verbosity = levelDebug
+	case opts.verbose:
+		verbosity = levelVerbose
+	case opts.quiet:
+		verbosity = levelQuiet
+	}
+}
+
+func logAt(level logLevel, a ...interface{}) {
+	if verbosity >= level {
+		fmt.Fprintln(stderr, a...)
+	}
+}
+
+// log prints the totals and notes of a normal run, which -q leaves out.
+func log(a ...interface{}) {
+	logAt(levelNormal, a...)
+}
+
+func logError(a ...interface{}) {
+	fmt.Fprintln(stderr, append([]interface{}{"ERROR:"}, a...)...)
+}
+
+func logWarning(a ...interface{}) {
+	fmt.Fprintln(stderr, append([]interface{}{"WARNING:"}, a...)...)
+}
+
+func logVerbose(a ...interface{}) {
+	logAt(levelVerbose, a...)
+}
+
+// logDebug prints a "debug:" line with the time since the start of the run,
+// so the lines of concurrent workers can be put in order.
+func logDebug(format string, a ...interface{}) {
+	if verbosity < levelDebug {
+		return
+	}
+	fmt.Fprintf(stderr, "debug: %9.3fs "+format+"\n",
+		append([]interface{}{time.Since(startTime).Seconds()}, a...)...)
+}
+
+var startTime = time.Now()
+
+// logStatus prints the status of the last second: the read speed, the
+// files done, how full the queues were and what bounds the run, the files
+// waiting in the walk queue and, when the totals are known, the percent
+// complete of bytes. With -status-files a line per worker follows with the
+// file it is hashing.
+func logStatus(l *statusLines, MBps float64, files int, MBpsTotal float64, q *queueStats, doneBytes int64) {
+	if verbosity < levelNormal {
+		return
+	}
+	const format = "MB/s: %.2f\tfiles: %d\tMB/s (total): %.2f\t%v"
+	line := fmt.Sprintf(format, MBps, files, MBpsTotal, q)
+	if n, size := q.s.Queued(); size > 0 {
+		line += fmt.Sprintf("\tqueued: %d", n)
+	}
+	if statusTotals != nil {
+		_, totBytes, done := statusTotals.totals()
+		approx := "~"
+		if done {
+			approx = ""
+		}
+		if totBytes > 0 {
+			line += fmt.Sprintf("\tdone: %s%.1f%%", approx, math.Min(100, percent(doneBytes, totBytes)))
+		}
+	}
+	lines := []string{line}
+	if workerFiles != nil {
+		width := terminalWidth(stderr)
+		if !l.tty || width <= 0 {
+			width = 1 << 20
+		}
+		for i, p := range workerFiles.list() {
+			prefix := fmt.Sprintf("  worker %d: ", i+1)
+			if p == "" {
+				p = "-"
+			}
+			lines = append(lines, prefix+shortenLeft(p, width-1-len(prefix)))
+		}
+	}
+	l.draw(lines)
+}
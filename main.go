@@ -2,19 +2,41 @@ package main
 
 import (
 	"bytes"
-	"crypto/sha1"
+	"context"
+	"errors"
 	"flag"
 	"fmt"
-	"github.com/anderejd/syncext"
+	"github.com/rajder/gosha1/hashwalk"
 	"io"
 	"os"
 	"path/filepath"
-	"runtime"
 	"sort"
 	"strings"
+	"sync/atomic"
 	"time"
 )
 
+// Results go to stdout, stats, progress and errors to stderr. Either can be
+// moved to an inherited file descriptor with -results-fd and -progress-fd.
+var (
+	stdout = os.Stdout
+	stderr = os.Stderr
+)
+
+// openFd returns the inherited file descriptor fd, refusing ones that aren't
+// open.
+func openFd(fd int) (*os.File, error) {
+	f := os.NewFile(uintptr(fd), fmt.Sprintf("fd%d", fd))
+	if f == nil {
+		return nil, fmt.Errorf("invalid file descriptor %d", fd)
+	}
+	_, err := f.Stat()
+	if err != nil {
+		return nil, fmt.Errorf("file descriptor %d: %v", fd, err)
+	}
+	return f, nil
+}
+
 type resultSlice []result
 
 func (r resultSlice) Len() int {
@@ -22,8 +44,11 @@ func (r resultSlice) Len() int {
 }
 
 func (r resultSlice) Less(i, j int) bool {
-	a := &r[i]
-	b := &r[j]
+	return lessResult(&r[i], &r[j])
+}
+
+// lessResult orders results by sum, then path.
+func lessResult(a, b *result) bool {
 	c := bytes.Compare(a.Sum, b.Sum)
 	if -1 == c {
 		return true
@@ -31,7 +56,7 @@ func (r resultSlice) Less(i, j int) bool {
 	if 1 == c {
 		return false
 	}
-	c = strings.Compare(a.Path, b.Path)
+	c = strings.Compare(normPath(a.Path), normPath(b.Path))
 	if -1 == c {
 		return true
 	}
@@ -45,168 +70,1420 @@ func (r resultSlice) Swap(i, j int) {
 }
 
 func printResultBuffer(basepath string, rs resultSlice) error {
-	var dupBytes int64
-	var totBytes int64
-	var dups int
-	var sum []byte
-	for _, r := range rs {
-		p, err := filepath.Rel(basepath, r.Path)
-		if err != nil {
-			return err
+	printAlgosHeader()
+	ordered := outputOrder(rs)
+	if opts.preserveRootOrder {
+		printByRoot(basepath, ordered)
+	} else {
+		for _, r := range ordered {
+			printResult(basepath, &r)
 		}
-		fmt.Fprintf(os.Stdout, "%x\t%s\n", r.Sum, p)
-		totBytes += r.Size
-		if !bytes.Equal(r.Sum, sum) {
-			sum = r.Sum
-			continue
+	}
+	if opts.treeDigest {
+		printTreeDigests(basepath, rs)
+	}
+	printSummary(rs)
+	return nil
+}
+
+// printAlgosHeader records the algorithms in the output so -check knows
+// which to verify with, and the fields unless they are the default. It is
+// left out for plain sha1 to keep the output sha1sum compatible. With
+// -format csv or tsv the header row is printed instead. With -shard-output
+// each shard gets the header.
+func printAlgosHeader() {
+	if shards == nil {
+		writeAlgosHeader()
+		return
+	}
+	for _, o := range shards.outs {
+		stdout = o.f
+		writeAlgosHeader()
+	}
+}
+
+func writeAlgosHeader() {
+	if csvFormat() {
+		// A comment would break CSV readers, the columns are named instead.
+		printCSVRecord(opts.fields)
+		return
+	}
+	if opts.pieceSize > 0 {
+		printLine("# piece-size: %d", int64(opts.pieceSize))
+	}
+	if opts.format == "coreutils" {
+		return
+	}
+	if len(opts.algos) > 1 || opts.algos[0] != "sha1" {
+		printLine("# algos: %s", strings.Join(opts.algos, ","))
+	}
+	// Columns other than the sums and the path, like -entropy adds, would
+	// be read as part of the path without it.
+	if def, _ := parseFields("", opts.algos); strings.Join(opts.fields, ",") != strings.Join(def, ",") {
+		printLine("# fields: %s", strings.Join(opts.fields, ","))
+	}
+}
+
+// printByRoot prints the sorted rs in a section per root, in the order the
+// roots were given.
+func printByRoot(basepath string, rs resultSlice) {
+	byRoot := make([]resultSlice, len(opts.args))
+	for _, r := range rs {
+		byRoot[r.Root] = append(byRoot[r.Root], r)
+	}
+	for i, section := range byRoot {
+		printLine("# root %d: %s", i+1, opts.args[i])
+		for _, r := range section {
+			printResult(basepath, &r)
 		}
-		dups++
-		dupBytes += r.Size
 	}
-	dupMB := float64(dupBytes) / 1024 / 1024
-	totMB := float64(totBytes) / 1024 / 1024
-	log("Duplicates   :", dups)
+}
+
+// printSummary prints the duplicate stats of the sorted rs.
+func printSummary(rs resultSlice) {
+	high := 0
+	if opts.entropy {
+		high = countHighEntropy(rs)
+	}
+	s := summarize(rs)
+	printStats(s, high)
+	chunkDedup.print(s)
+}
+
+// printStats prints the duplicate stats and, with -entropy, the number of
+// high entropy files. Duplicates make the run exit with exitFound, except
+// for -copy, whose manifest just lists them.
+func printStats(s summary, high int) {
+	found = found || s.Duplicates > 0 && !opts.copy
+	dupMB := float64(s.DupBytes) / 1024 / 1024
+	totMB := float64(s.TotalBytes) / 1024 / 1024
+	log("Duplicates   :", s.Duplicates)
 	log("Duplicate MB :", dupMB)
 	log("Total MB     :", totMB)
-	return nil
+	log("Duplicate %  :", fmt.Sprintf("%.1f%% of bytes, %.1f%% of files",
+		percent(s.DupBytes, s.TotalBytes), percent(int64(s.Duplicates), int64(s.Files))))
+	log("Unique       :", fmt.Sprintf("%d contents in %d files, %s dedup ratio",
+		s.Unique, s.Files, dedupRatio(s.Files, s.Unique)))
+	if opts.entropy {
+		log("High entropy :", high)
+	}
+}
+
+// summary holds the duplicate stats of a result set.
+type summary struct {
+	Files      int   `json:"files"`
+	Unique     int   `json:"unique"`
+	Duplicates int   `json:"duplicates"`
+	DupBytes   int64 `json:"duplicate_bytes"`
+	TotalBytes int64 `json:"total_bytes"`
+}
+
+// summarize computes the stats of the sorted rs. Every file after the first
+// of a sum counts as a duplicate, hard link aliases don't count at all. The
+// unique files the duplicate filters left out of rs count too.
+func summarize(rs resultSlice) summary {
+	rs = withoutAliases(rs)
+	s := summary{Files: len(rs)}
+	for i := 0; i < len(rs); {
+		j := i + 1
+		for j < len(rs) && bytes.Equal(rs[i].Sum, rs[j].Sum) {
+			j++
+		}
+		for _, r := range rs[i:j] {
+			s.TotalBytes += r.Size
+		}
+		s.Unique++
+		for _, r := range intendedDupes.copies(rs[i:j])[1:] {
+			s.Duplicates++
+			s.DupBytes += r.Size
+		}
+		i = j
+	}
+	s.Files += filtered.files
+	s.Unique += filtered.files
+	s.TotalBytes += filtered.bytes
+	return s
+}
+
+// dedupRatio formats files per unique content, e.g. "1.50x".
+func dedupRatio(files, unique int) string {
+	if unique == 0 {
+		return "1.00x"
+	}
+	return fmt.Sprintf("%.2fx", float64(files)/float64(unique))
+}
+
+func printResult(basepath string, r *result) {
+	p := relPath(basepath, r.Path)
+	if shards != nil {
+		stdout = shards.shard(r.Sum)
+	}
+	if opts.format == "coreutils" {
+		printCoreutils(p, r)
+		return
+	}
+	if csvFormat() {
+		printCSVRecord(formatFields(opts.fields, r, p))
+		return
+	}
+	printLine("%s", strings.Join(formatFields(opts.fields, r, p), "\t"))
+	printPieces(r)
+}
+
+// relPath returns p relative to basepath, or p itself if basepath is empty.
+// When that isn't possible, e.g. for paths on different Windows drives, p is
+// returned as is with a warning. The path is normalized per -normalize.
+func relPath(basepath, p string) string {
+	if basepath == "" {
+		return normPath(p)
+	}
+	rel, err := filepath.Rel(basepath, p)
+	if err != nil {
+		logWarning(err)
+		return normPath(p)
+	}
+	return normPath(rel)
+}
+
+// printEmptyFiles prints the zero byte files in their own section for
+// -empty-files=separate.
+func printEmptyFiles(basepath string, empty resultSlice) {
+	log("Empty files  :", len(empty))
+	if len(empty) == 0 {
+		return
+	}
+	printLine("# empty files: %d", len(empty))
+	for i := range empty {
+		printResult(basepath, &empty[i])
+	}
+}
+
+// printEmptyDirs prints the directories without any entries the walk found,
+// in a section of their own like printEmptyFiles.
+func printEmptyDirs(basepath string) {
+	log("Empty dirs   :", len(emptyDirs))
+	if len(emptyDirs) == 0 {
+		return
+	}
+	sort.Strings(emptyDirs)
+	printLine("# empty directories: %d", len(emptyDirs))
+	for _, d := range emptyDirs {
+		p := relPath(basepath, d)
+		if !strings.HasSuffix(p, string(filepath.Separator)) {
+			p += string(filepath.Separator)
+		}
+		printLine("%s", p)
+	}
+}
+
+// splitEmpty moves the zero byte files of rs to a separate slice, keeping
+// the order of both.
+func splitEmpty(rs resultSlice) (rest, empty resultSlice) {
+	rest = rs[:0]
+	for _, r := range rs {
+		if r.Size == 0 {
+			empty = append(empty, r)
+		} else {
+			rest = append(rest, r)
+		}
+	}
+	return
 }
 
-func log(a ...interface{}) {
-	fmt.Fprintln(os.Stderr, a...)
+func percent(part, total int64) float64 {
+	if total == 0 {
+		return 0
+	}
+	return 100 * float64(part) / float64(total)
 }
 
-func logStatus(MBps float64, files int, MBpsTotal float64) {
-	const format = "MB/s: %.2f\tfiles: %d\tMB/s (total): %.2f\n"
-	fmt.Fprintf(os.Stderr, format, MBps, files, MBpsTotal)
+// printLine prints a result record to stdout, terminated by a newline or
+// with -print0 by a NUL.
+func printLine(format string, a ...interface{}) {
+	end := "\n"
+	if opts.print0 {
+		end = "\x00"
+	}
+	fmt.Fprintf(stdout, format+end, a...)
 }
 
-func calcSha1(path string) (sum []byte, written int64, err error) {
+// linkResult hashes the target path string of the link j, for -symlinks
+// target.
+func linkResult(j job, algos []string) result {
+	hs := hashwalk.NewHashes(algos)
+	r := result{Path: j.Path, Size: int64(len(j.Link)), ModTime: j.Info.ModTime(), Root: j.Root}
+	for _, h := range hs {
+		io.WriteString(h, j.Link)
+		r.Sums = append(r.Sums, h.Sum(nil))
+	}
+	r.Sum = r.Sums[0]
+	return r
+}
+
+// calcSums hashes the file with each of the algos in a single read. The
+// file content is also written to tee, unless it is nil. Reading stops with
+// ctx's error once ctx is done.
+// With -sync-first the file is fsynced before it is read, so dirty pages are
+// on disk and the sum matches what is durably persisted. This costs a flush
+// per file and can be very slow on busy or network file systems.
+// With -no-cache-pollution what was read is dropped from the page cache as
+// it goes. With -mmap large files are mapped instead of read.
+// With -vv the time to open and read the file is logged.
+func calcSums(ctx context.Context, path string, algos []string, tee io.Writer) (sums [][]byte, written int64, err error) {
 	var f *os.File
-	f, err = os.Open(path)
+	start := time.Now()
+	f, err = os.Open(hashwalk.LongPath(path))
 	if nil != err {
+		logDebug("open %s: %v", path, err)
 		return
 	}
 	defer f.Close()
-	h := sha1.New()
-	written, err = io.Copy(h, f)
-	if nil != err {
-		return
+	if verbosity >= levelDebug {
+		logDebug("open %s in %v", path, time.Since(start))
+		defer func() {
+			d := time.Since(start)
+			logDebug("read %s: %d bytes in %v, %.1f MB/s, err %v", path, written, d,
+				float64(written)/1024/1024/d.Seconds(), err)
+		}()
 	}
-	sum = h.Sum(nil)
-	return
+	if opts.syncFirst {
+		err = f.Sync()
+		if nil != err {
+			return
+		}
+	}
+	return sumFile(ctx, f, algos, tee)
+}
+
+// sumFile hashes the open file f to EOF, skipping holes with -sparse and
+// reading it as -split-min, -mmap-min, -no-cache-pollution and -read-buffer
+// ask.
+func sumFile(ctx context.Context, f *os.File, algos []string, tee io.Writer) ([][]byte, int64, error) {
+	var sparse io.Reader
+	if opts.sparse {
+		if fi, err := f.Stat(); err == nil && fi.Mode().IsRegular() {
+			sparse, _ = newSparseReader(f, fi)
+		}
+	}
+	if opts.splitMin > 0 && sparse == nil {
+		if fi, err := f.Stat(); err == nil && fi.Mode().IsRegular() && splitHashable(algos, fi.Size(), tee) {
+			return splitSums(ctx, f, fi.Size())
+		}
+	}
+	if opts.mmapMin > 0 && sparse == nil {
+		if fi, err := f.Stat(); err == nil && fi.Mode().IsRegular() && fi.Size() >= int64(opts.mmapMin) {
+			sums, written, ok, err := mmapSums(ctx, f, fi.Size(), algos, tee)
+			if ok {
+				return sums, written, err
+			}
+		}
+	}
+	var r io.Reader = f
+	if sparse != nil {
+		r = sparse
+	} else if opts.noCachePollution {
+		r = &dropBehind{f: f}
+	}
+	buf := readBuffer()
+	defer putBuffer(buf)
+	if buf == nil {
+		return hashwalk.SumReaderContext(ctx, limitRead(ctx, r), algos, tee)
+	}
+	return hashwalk.SumReaderBuffer(ctx, limitRead(ctx, r), algos, tee, *buf)
 }
 
 // Result struct for a single file.
 // Err will be nil on success.
+// Sum is the sum of the first of the -algos, Sums has one per algorithm.
+// Chunks is only set in -cdc mode.
 type result struct {
-	Path string
-	Sum  []byte
-	Size int64
-	Err  error
+	Path    string
+	Sum     []byte
+	Sums    [][]byte
+	Size    int64
+	ModTime time.Time
+	Err     error
+	Chunks  []chunk
+	Pieces  [][]byte // With -piece-size.
+	Entropy float64
+	Root    int    // Index of the root argument the file was found under.
+	Cached  bool   // The sum was taken from -cache instead of hashing.
+	Quick   bool   // The sum only covers the size, head and tail (-quick).
+	Alias   string // Path of the hard link the sum was taken from.
+	Retries int    // Reads retried after transient errors (-retries).
+	Meta    []byte // Sum of the file's metadata (-hash-metadata).
+	// Disk space taken by the file, with -sparse, -1 if unknown.
+	Allocated int64
+	Duration  time.Duration // Time taken to hash the file, retries included.
+	Seq       int           // Order the result was collected in, for -no-sort.
+}
+
+// A file to hash.
+type job struct {
+	hashwalk.File
+	Archive bool // With -archives, the members are hashed too.
+}
+
+func (j job) size() int64 {
+	if j.Info == nil {
+		return 0
+	}
+	return j.Info.Size()
+}
+
+// What the workers compute for each file.
+type hashSpec struct {
+	algos   []string
+	cdc     bool
+	entropy bool
+	cache   cache                   // Sums of unchanged files are taken from here if set.
+	quick   int64                   // Only hash this many bytes at each end of larger files.
+	xattr   bool                    // Take and store sums in extended attributes (-xattr).
+	pieces  int64                   // Also hash pieces of this size (-piece-size).
+	resumed map[string]journalEntry // Sums of files hashed before an interruption (-resume).
+	copy    *copier                 // Copy the files while hashing them (-copy).
+	dedup   bool                    // Add the chunks of each file to chunkDedup (-chunk-dedup).
 }
 
-// The returned result channel will close when done.
-func produceConcurrent(dirpath string) <-chan result {
-	res := make(chan result)
-	jobs := make(chan string)
-	work := func() {
-		for path := range jobs {
-			sum, size, err := calcSha1(path)
-			res <- result{path, sum, size, err}
+// produceConcurrent hashes the files from produce with a pool of workers.
+// The scan's result channel will close when done, or soon after ctx is
+// cancelled. An error from produce aborts the scan.
+func produceConcurrent(ctx context.Context, spec hashSpec, produce jobProducer) *hashwalk.Scan[result] {
+	res := make(chan result, opts.resultQueue)
+	jobs := make(chan job, jobQueueDepth())
+	runMetrics.scanStarted(jobs)
+	var next <-chan job = jobs
+	if opts.prefetch > 0 {
+		next = prefetchJobs(ctx, jobs, opts.prefetch)
+	}
+	// members sends a result per file inside an -archives archive.
+	members := func(j job, s *hashwalk.Scan[result]) {
+		if !j.Archive {
+			return
+		}
+		err := archiveMembers(ctx, j.Path, j.Root, spec.algos, func(r result) bool {
+			runExec(ctx, &r, spec.algos[0])
+			select {
+			case res <- r:
+				return true
+			case <-ctx.Done():
+				return false
+			}
+		})
+		if err != nil {
+			s.AddFileError(err)
+			select {
+			case res <- result{Path: j.Path, Err: err, Root: j.Root}:
+			case <-ctx.Done():
+			}
 		}
 	}
-	syncext.FanOut(runtime.NumCPU(), work, func() { close(res) })
-	go produceJobs(dirpath, jobs, res)
-	return res
+	// The hard link aliases get their sums from links, the last worker
+	// done sends those whose link wasn't hashed here.
+	var links linkedSums
+	workers := workerCount()
+	active := int32(workers)
+	work := func(id int, s *hashwalk.Scan[result]) {
+		var send func(j job, r result)
+		send = func(j job, r result) {
+			if opts.hashMetadata != nil && r.Err == nil && j.Info != nil {
+				r.Meta = metadataSum(j, spec.algos[0])
+			}
+			if spec.copy != nil && r.Alias != "" && r.Sum != nil {
+				if err := spec.copy.link(j); err != nil {
+					s.AddFileError(err)
+					r = result{Path: j.Path, Err: err, Root: j.Root}
+				}
+			}
+			runExec(ctx, &r, spec.algos[0])
+			workerFiles.set(id, "")
+			runMetrics.hashed(id, &r)
+			slowest.add(&r)
+			select {
+			case res <- r:
+			case <-ctx.Done():
+			}
+			for _, a := range links.hashed(j, &r) {
+				send(a, aliasResult(a, &r))
+			}
+		}
+		defer func() {
+			if atomic.AddInt32(&active, -1) == 0 {
+				for _, a := range links.rest() {
+					send(a, aliasResult(a, nil))
+				}
+			}
+		}()
+		for j := range next {
+			if ctx.Err() != nil {
+				continue
+			}
+			workerFiles.set(id, j.Path)
+			if j.Link != "" {
+				send(j, linkResult(j, spec.algos))
+				continue
+			}
+			if j.Err != nil {
+				s.AddFileError(j.Err)
+				send(j, result{Path: j.Path, Err: j.Err, Root: j.Root})
+				continue
+			}
+			if j.Alias != "" {
+				if r, ok := links.alias(j); ok {
+					send(j, r)
+				}
+				continue
+			}
+			if spec.xattr && !opts.noCache && !spec.cdc && !spec.entropy && !spec.dedup {
+				if sums, ok := xattrSums(j.Path, j.Info, spec.algos); ok {
+					r := result{Path: j.Path, Sum: sums[0], Sums: sums, Size: j.Info.Size(),
+						ModTime: j.Info.ModTime(), Root: j.Root, Cached: true}
+					send(j, r)
+					members(j, s)
+					continue
+				}
+			}
+			if sums, ok := lookupJournal(spec.resumed, j.Path, j.Info); ok {
+				r := result{Path: j.Path, Sum: sums[0], Sums: sums, Size: j.Info.Size(),
+					ModTime: j.Info.ModTime(), Root: j.Root, Cached: true}
+				send(j, r)
+				members(j, s)
+				continue
+			}
+			if sum, ok := spec.cache.lookup(j.Path, j.Info); ok {
+				r := result{Path: j.Path, Sum: sum, Sums: [][]byte{sum}, Size: j.Info.Size(),
+					ModTime: j.Info.ModTime(), Root: j.Root, Cached: true}
+				send(j, r)
+				members(j, s)
+				continue
+			}
+			var c, dc *chunker
+			var e *entropyCounter
+			var pc *pieceHasher
+			var sums [][]byte
+			var size int64
+			var err error
+			retries := 0
+			start := time.Now()
+			for {
+				// The tees start over on a retry.
+				c, dc, e, pc = nil, nil, nil, nil
+				var tees []io.Writer
+				if spec.pieces > 0 {
+					pc = newPieceHasher(spec.algos[0], spec.pieces)
+					tees = append(tees, pc)
+				}
+				if spec.cdc {
+					c = newChunker()
+					tees = append(tees, c)
+				}
+				if spec.dedup {
+					dc = newFastCDC(chunkAverage())
+					tees = append(tees, dc)
+				}
+				if spec.entropy {
+					e = &entropyCounter{}
+					tees = append(tees, e)
+				}
+				var ct *copyTarget
+				if spec.copy != nil {
+					if ct, err = spec.copy.create(j); err != nil {
+						break
+					}
+					tees = append(tees, ct)
+				}
+				var tee io.Writer
+				if len(tees) > 0 {
+					tee = io.MultiWriter(tees...)
+				}
+				if spec.quick > 0 {
+					sums, size, err = withTimeout(ctx, j.Path, func(ctx context.Context) ([][]byte, int64, error) {
+						return quickSums(ctx, j.Path, spec.algos, spec.quick, tee)
+					})
+				} else if agents != nil {
+					sums, size, err = withTimeout(ctx, j.Path, func(ctx context.Context) ([][]byte, int64, error) {
+						return agents.hash(ctx, j.Path, spec.algos)
+					})
+				} else {
+					sums, size, err = calcSumsTimeout(ctx, j.Path, spec.algos, tee)
+				}
+				if ct != nil {
+					err = spec.copy.finish(ctx, ct, sums, err)
+				}
+				if err == nil || retries >= opts.retries || !transientError(err) || ctx.Err() != nil {
+					break
+				}
+				retries++
+				atomic.AddInt64(&retried, 1)
+				logWarning(fmt.Sprintf("%v, retry %d of %d", err, retries, opts.retries))
+				if !retryWait(ctx, retries) {
+					break
+				}
+			}
+			r := result{Path: j.Path, Sums: sums, Size: size, Err: err, Retries: retries,
+				Duration: time.Since(start)}
+			r.Quick = spec.quick > 0 && size > 2*spec.quick
+			if err == nil {
+				r.Sum = sums[0]
+			}
+			r.ModTime = j.Info.ModTime()
+			r.Root = j.Root
+			if opts.sparse {
+				r.Allocated = -1
+				if n, ok := allocatedSize(j.Info); ok {
+					r.Allocated = n
+				}
+			}
+			if c != nil && err == nil {
+				r.Chunks = c.Chunks()
+			}
+			if dc != nil && err == nil {
+				chunkDedup.add(dc.Chunks())
+			}
+			if e != nil && err == nil {
+				r.Entropy = e.Entropy()
+			}
+			if pc != nil && err == nil {
+				r.Pieces = pc.Pieces()
+			}
+			if err != nil {
+				s.AddFileError(err)
+			} else if spec.xattr {
+				storeXattrSums(j.Path, j.Info, spec.algos, sums)
+			}
+			send(j, r)
+			if err == nil {
+				members(j, s)
+			}
+		}
+	}
+	return hashwalk.Start(ctx, workers, jobs, res, produce, work)
 }
 
-func produceJobs(dirpath string, jobs chan<- string, res chan<- result) {
-	err := processDir(dirpath, jobs)
-	if err != nil {
-		res <- result{"", nil, 0, err}
+// workerCount returns the number of hashing workers, -j or one per CPU.
+func workerCount() int {
+	if opts.jobs > 0 {
+		return opts.jobs
+	}
+	if agents != nil {
+		return agents.size()
 	}
-	close(jobs)
+	return numCPU
 }
 
-func processDir(path string, jobs chan<- string) error {
-	if isDotPath(path) {
-		return nil
+func processRoots(args []string) (err error) {
+	var failed []result
+	filtered.files, filtered.bytes = 0, 0
+	var totals summary
+	if opts.summaryJSON != "" {
+		start := time.Now()
+		defer func() {
+			werr := writeRunSummary(opts.summaryJSON, totals, failed, start, err)
+			if err == nil {
+				err = werr
+			}
+		}()
+	}
+	if opts.errorsJSON != "" {
+		defer func() {
+			werr := writeErrorsJSON(opts.errorsJSON, failed)
+			if err == nil {
+				err = werr
+			}
+		}()
+	}
+	if opts.keepGoing {
+		defer func() {
+			if err == nil {
+				err = failedFilesError(failed)
+			}
+		}()
+	}
+	var c cache
+	if opts.cache != "" {
+		var algo string
+		c, algo, err = loadCache(opts.cache)
+		if err != nil {
+			return err
+		}
+		if algo != opts.algos[0] {
+			logWarning("the cache has", algo, "sums, starting a new one for", opts.algos[0])
+			c = make(cache)
+		}
+	}
+	var jl *journal
+	var done map[string]journalEntry
+	if opts.checkpoint != "" {
+		jl, done, err = openJournal(opts.checkpoint, opts.algos, opts.resume)
+		if err != nil {
+			return err
+		}
+		if len(done) > 0 {
+			logVerbose("Resuming     :", len(done), "files hashed before from", opts.checkpoint)
+		}
+		defer func() {
+			if cerr := jl.close(err == nil && len(failed) == 0); err == nil {
+				err = cerr
+			}
+		}()
+	}
+	var roots []string
+	var dirpath string
+	if opts.filesFrom == "" {
+		roots, dirpath, err = resolveRoots(args)
+		if err != nil {
+			return err
+		}
+	}
+	useBar := opts.bar && isTerminal(stderr)
+	var bar *progressBar
+	if useBar {
+		bar = newProgressBar(roots)
+	} else if opts.prescan {
+		statusTotals = startPrescan(roots)
+	}
+	ctx, cancel := runContext()
+	defer cancel()
+	var stats hashwalk.Stats
+	walk := walkRoots(roots, &stats)
+	if opts.filesFrom != "" {
+		walk = filesFrom(opts.filesFrom)
+	}
+	if opts.groupMode() && !opts.cdc && !opts.chunkDedup && opts.sqlite == "" && opts.db == "" && opts.cache == "" && !opts.archives &&
+		!opts.caseCollisions {
+		list, err := sizeCandidates(ctx, walk)
+		if serr := stopError(ctx, err); serr != nil {
+			return serr
+		}
+		if err != nil {
+			return err
+		}
+		if useBar {
+			bar = newProgressBarJobs(list)
+		} else {
+			statusTotals = prescanJobs(list)
+		}
+		walk = jobList(list)
+	}
+	if opts.prefilterAlgo != "" {
+		spec := hashSpec{algos: []string{opts.prefilterAlgo}}
+		pre, err := collect(produceConcurrent(ctx, spec, walk), bar, &failed)
+		if serr := stopError(ctx, err); serr != nil {
+			return serr
+		}
+		if err != nil {
+			return err
+		}
+		candidates := prefilterCandidates(pre)
+		if useBar {
+			bar = newProgressBarTotal(candidates)
+		} else {
+			statusTotals = prescanResults(candidates)
+		}
+		walk = candidateJobs(candidates)
+	}
+	var whole resultSlice
+	if opts.quick > 0 {
+		spec := hashSpec{algos: opts.algos, entropy: opts.entropy, quick: opts.quick << 20}
+		pre, err := collect(produceConcurrent(ctx, spec, walk), bar, &failed)
+		if serr := stopError(ctx, err); serr != nil {
+			return serr
+		}
+		if err != nil {
+			return err
+		}
+		var partial resultSlice
+		whole, partial = quickCandidates(pre)
+		if useBar {
+			bar = newProgressBarTotal(partial)
+		}
+		walk = candidateJobs(partial)
+	}
+	spec := hashSpec{algos: opts.algos, cdc: opts.cdc, entropy: opts.entropy, pieces: int64(opts.pieceSize),
+		dedup: opts.chunkDedup}
+	if c != nil && !opts.noCache && len(opts.algos) == 1 && !opts.cdc && !opts.entropy && !opts.chunkDedup {
+		spec.cache = c
+	}
+	spec.xattr = opts.xattr
+	spec.resumed = done
+	s := produceConcurrent(ctx, spec, walk)
+	if jl != nil {
+		s = jl.tee(ctx, s)
+	}
+	if opts.listen != "" {
+		st, err := newStreamer(opts.listen, dirpath)
+		if err != nil {
+			return err
+		}
+		defer st.close()
+		s = st.tee(ctx, s)
+	}
+	if opts.stream {
+		totals, err = streamResults(s, bar, &failed, dirpath)
+		if serr := stopError(ctx, err); serr != nil {
+			return serr
+		}
+		if err == nil {
+			printFailed(dirpath, failed)
+			printWalkStats(&stats)
+			slowest.print()
+			warnExecFailures()
+		}
+		return err
+	}
+	if opts.spillAt > 0 {
+		totals, err = sortSpilled(ctx, s, bar, &failed, dirpath)
+		if serr := stopError(ctx, err); serr != nil {
+			return serr
+		}
+		if err == nil {
+			printFailed(dirpath, failed)
+			printWalkStats(&stats)
+			slowest.print()
+			warnExecFailures()
+		}
+		return err
+	}
+	// The links of files in whole are aliases of results of the -quick pass.
+	resBuff, err := gather(s, bar, &failed)
+	resBuff = resolveAliases(append(resBuff, whole...))
+	if serr := stopError(ctx, err); serr != nil {
+		// Restore the default handling, a second Ctrl-C kills at once.
+		cancel()
+		what := "interrupted"
+		if serr == errDeadline {
+			what = "-timeout ran out"
+		}
+		logWarning(what+", printing the", len(resBuff), "files hashed so far")
+		sort.Sort(resBuff)
+		totals = summarize(resBuff)
+		err = report(dirpath, resBuff, failed)
+		if err != nil {
+			return err
+		}
+		return serr
 	}
-	f, err := os.Open(path)
 	if err != nil {
 		return err
 	}
-	list, err := f.Readdir(-1)
-	f.Close()
+	printWalkStats(&stats)
+	if spec.cache != nil || spec.xattr {
+		log("Cached       :", countCached(resBuff), "files not rehashed")
+	}
+	if opts.resume {
+		log("Resumed      :", atomic.LoadInt64(&resumed), "files not rehashed")
+	}
+	if n := atomic.LoadInt64(&sparseFiles); n > 0 {
+		log("Sparse       :", n, "files,", float64(atomic.LoadInt64(&holeBytes))/1024/1024, "MB of holes not read")
+	}
+	if n := atomic.LoadInt64(&retried); n > 0 {
+		log("Retried      :", n, "reads after transient errors")
+	}
+	slowest.print()
+	if n := atomic.LoadInt64(&xattrFailures); n > 0 {
+		logWarning(n, "files' sums couldn't be stored in extended attributes")
+	}
+	warnExecFailures()
+	if opts.casSkip != "" {
+		resBuff = skipInCAS(resBuff)
+	}
+	if knownSet != nil {
+		resBuff = filterKnown(resBuff)
+	}
+	sort.Sort(resBuff)
+	totals = summarize(resBuff)
+	if c == nil {
+		return report(dirpath, resBuff, failed)
+	}
+	changes, err := c.update(roots, resBuff)
 	if err != nil {
 		return err
 	}
-	for _, f := range list {
-		p := filepath.Join(path, f.Name())
-		if !f.IsDir() {
-			if f.Mode().IsRegular() {
-				jobs <- p
-			}
-		} else {
-			err = processDir(p, jobs)
-			if nil != err {
-				return err
-			}
+	if opts.changedOnly {
+		printChanges(dirpath, changes)
+	} else {
+		err = report(dirpath, resBuff, failed)
+		if err != nil {
+			return err
 		}
 	}
-	return nil
+	return c.save(opts.cache, opts.algos[0])
 }
 
-func isDotPath(p string) bool {
-	b := filepath.Base(p)
-	if ".." != b && len(b) > 1 && '.' == b[0] {
-		return true
-	}
-	return false
+// collect gathers the results of s, logging the throughput once a second or
+// updating bar if it isn't nil. Failed files are appended to failed. Files
+// that timed out, and with -keep-going any failed file, are skipped, any
+// other error stops the collection. The caller must cancel the scan if an
+// error is returned. The results gathered so far are returned with the
+// error, e.g. to print them after Ctrl-C. Hard link aliases have the sums of
+// their link, see resolveAliases.
+func collect(s *hashwalk.Scan[result], bar *progressBar, failed *[]result) (resultSlice, error) {
+	rs, err := gather(s, bar, failed)
+	return resolveAliases(rs), err
+}
+
+// gather is collect leaving the aliases unresolved, for results whose
+// links are hashed by another scan.
+func gather(s *hashwalk.Scan[result], bar *progressBar, failed *[]result) (resultSlice, error) {
+	resBuff := make(resultSlice, 0)
+	err := collectEach(s, bar, failed, func(r result) {
+		r.Seq = len(resBuff)
+		resBuff = append(resBuff, r)
+	})
+	return resBuff, err
 }
 
-func processRootDir(dirpath string) error {
-	res := produceConcurrent(dirpath)
+// collectEach is collect passing each good result to each instead of
+// gathering them. Without a bar the status line is printed each second, even
+// while a large file keeps all workers busy.
+func collectEach(s *hashwalk.Scan[result], bar *progressBar, failed *[]result, each func(r result)) error {
 	ta := time.Now()
 	files := 0
+	done := 0
 	i := 0
 	var MBpsTotal float64
-	var bytes int64
-	resBuff := make(resultSlice, 0)
-	for r := range res {
-		bytes += r.Size
-		files++
-		if r.Err != nil {
-			return r.Err
-		}
-		tb := time.Now()
-		s := tb.Sub(ta).Seconds()
-		if s > 1.0 {
+	var bytes, allBytes int64
+	var doneBytes int64
+	q := newQueueStats(s)
+	status := newStatusLines()
+	defer status.clear()
+	tick := time.NewTicker(time.Second)
+	defer tick.Stop()
+	for results := s.Results; results != nil; {
+		var r result
+		select {
+		case res, ok := <-results:
+			if !ok {
+				results = nil
+				continue
+			}
+			r = res
+		case tb := <-tick.C:
+			if bar != nil {
+				continue
+			}
 			i++
-			bytesPerSec := float64(bytes) / s
+			bytesPerSec := float64(bytes) / tb.Sub(ta).Seconds()
 			MBps := bytesPerSec / 1024 / 1024
 			MBpsTotal += (MBps - MBpsTotal) / float64(i)
-			logStatus(MBps, files, MBpsTotal)
+			q.add()
+			logStatus(status, MBps, files, MBpsTotal, q, allBytes)
+			q.reset()
 			ta = tb
 			bytes = 0
 			files = 0
+			continue
+		}
+		bytes += r.Size
+		allBytes += r.Size
+		files++
+		if _, ok := r.Err.(*hashwalk.TimeoutError); ok {
+			status.clear()
+			logWarning(r.Err)
+			*failed = append(*failed, r)
+			continue
+		}
+		if r.Err != nil && opts.keepGoing {
+			*failed = append(*failed, r)
+			continue
+		}
+		if r.Err != nil {
+			*failed = append(*failed, r)
+			if bar != nil {
+				bar.finish()
+			}
+			return r.Err
+		}
+		each(r)
+		done++
+		if bar != nil {
+			doneBytes += r.Size
+			bar.update(done, doneBytes, r.Path)
 		}
-		resBuff = append(resBuff, r)
 	}
-	sort.Sort(resBuff)
-	return printResultBuffer(dirpath, resBuff)
+	if bar != nil {
+		bar.finish()
+	}
+	if err := s.Err(); err != nil {
+		*failed = append(*failed, result{Err: err})
+		return err
+	}
+	return nil
+}
+
+// report prints the sorted results and the requested extra reports, paths
+// relative to dirpath, or absolute if it is empty.
+func report(dirpath string, resBuff, failed resultSlice) error {
+	var empty resultSlice
+	if opts.emptyFiles == "separate" {
+		resBuff, empty = splitEmpty(resBuff)
+	}
+	if opts.groupMode() {
+		resBuff = withoutAliases(resBuff)
+	}
+	var err error
+	if opts.linkDupes {
+		err = linkDupes(dirpath, resBuff)
+	} else if opts.reflinkDupes {
+		err = reflinkDupes(dirpath, resBuff)
+	} else if opts.deleteDupes {
+		err = deleteDupes(dirpath, resBuff)
+	} else if opts.tui {
+		err = runTUI(dirpath, resBuff)
+	} else if opts.annotate != "" {
+		err = printAnnotated(dirpath, resBuff)
+	} else if opts.keepUnder != "" {
+		err = printKeepUnder(dirpath, resBuff)
+	} else if opts.dupes {
+		printDupes(dirpath, resBuff)
+	} else if opts.dupeDirs {
+		printDupeDirs(dirpath, resBuff)
+	} else if opts.report == "usage" {
+		printUsage(dirpath, resBuff)
+	} else if opts.summaryOnly {
+		err = printSummaryOnly(summarize(resBuff))
+		printSummary(resBuff)
+	} else if opts.format == "json" {
+		err = printJSON(dirpath, resBuff, failed)
+	} else {
+		err = printResultBuffer(dirpath, resBuff)
+		printFailed(dirpath, failed)
+	}
+	if err == nil && opts.sqlite != "" {
+		err = writeSQLite(opts.sqlite, dirpath, append(resBuff, empty...))
+	}
+	if err == nil && opts.db != "" {
+		err = writeDB(opts.db, append(resBuff, empty...))
+	}
+	if err != nil {
+		return err
+	}
+	if opts.emptyFiles == "separate" {
+		printEmptyFiles(dirpath, empty)
+	}
+	if opts.emptyDirs {
+		printEmptyDirs(dirpath)
+	}
+	if opts.caseCollisions {
+		printCaseCollisions(dirpath, resBuff)
+	}
+	if opts.cdc {
+		printSimilar(dirpath, resBuff, opts.cdcMinPerc)
+	}
+	return nil
 }
 
 func main() {
-	flag.Parse()
-	dirpath := flag.Arg(0)
-	if "" == dirpath {
-		log("ERROR: Arg 0 (dirpath) missing.")
-		os.Exit(1)
+	flag.BoolVar(&opts.cdc, "cdc", false, "Fingerprint files with content-defined chunks and report near-duplicate files.")
+	flag.BoolVar(&opts.chunkDedup, "chunk-dedup", false, "Also split every file into content-defined chunks (FastCDC) and report how much a block level dedup or a backup tool like restic or borg would save.")
+	flag.Var(&opts.chunkSize, "chunk-size", "Average chunk size of -chunk-dedup, a power of two from 4K to 64M (default 1M).")
+	flag.Float64Var(&opts.cdcMinPerc, "cdc-min", 50, "Minimum shared chunk percentage for -cdc to report a pair as similar.")
+	flag.BoolVar(&opts.entropy, "entropy", false, fmt.Sprintf("Compute the Shannon entropy of each file in bits per byte (entropy field), files with %.1f or more are counted as likely compressed or encrypted.", highEntropy))
+	flag.BoolVar(&opts.check, "check", false, "Verify the files in the directory given as second argument (default .) against the manifest given as first argument.")
+	flag.BoolVar(&opts.cmp, "cmp", false, "Compare the two files given as arguments, or the two directory trees: files only in one and files that differ.")
+	flag.BoolVar(&opts.cmpContent, "cmp-content", false, "With -cmp of two directories, compare the contents regardless of path: list the files whose content isn't anywhere in the other tree.")
+	flag.BoolVar(&opts.detectCollisions, "detect-collisions", false, "Read the files of each duplicate group again with SHA-1 collision detection and leave out those crafted to collide, like the SHAttered PDFs.")
+	flag.IntVar(&opts.slowest, "slowest", 0, "Log the N files that took longest to hash with their read speed, to find failing disks and slow network paths.")
+	flag.BoolVar(&opts.timings, "timings", false, "With -format json and -listen, add the seconds each file took to hash, which differ between runs.")
+	flag.BoolVar(&opts.sparse, "sparse", false, "Don't read the holes of sparse files, hashing them as the zeros they read as, and add the allocated field with the disk space each file takes (Linux only).")
+	flag.BoolVar(&opts.paranoid, "paranoid", false, "Compare the files of each duplicate group byte by byte before reporting or acting on it, leaving out any that differ or can't be read.")
+	flag.BoolVar(&opts.cmpBytes, "cmp-bytes", false, "With -cmp, confirm equal hashes with a byte-by-byte comparison.")
+	flag.BoolVar(&opts.copy, "copy", false, "Copy the file or directory given as first argument to the second while hashing it, printing the manifest of the copy.")
+	flag.BoolVar(&opts.copyVerify, "copy-verify", false, "With -copy, read each copy back and compare its sum before putting it in place.")
+	flag.StringVar(&opts.errorsJSON, "errors-json", "", "Write the files that failed and their errors to this file as JSON.")
+	flag.BoolVar(&opts.prescan, "prescan", false, "Count the files and bytes below the roots while hashing, for the percent complete in the status line.")
+	flag.BoolVar(&opts.statusFiles, "status-files", false, "Show the file each worker is hashing below the status line.")
+	flag.BoolVar(&opts.bar, "bar", false, "Show a progress bar with percentage and ETA when stderr is a terminal.")
+	flag.StringVar(&opts.sign, "sign", "", "With -output, write a detached Ed25519 signature of it made with this PEM or unencrypted minisign secret key to FILE.sig, or FILE.minisig for minisign.")
+	flag.StringVar(&opts.verifyKey, "verify-key", "", "With -check, verify the manifest's detached signature with this PEM or minisign public key file, or minisign key string, before trusting it.")
+	flag.StringVar(&opts.serveDB, "serve-db", "", "Serve the -db database over HTTP on this address (e.g. localhost:8080): lookups by hash and path, the duplicates, and rescans of directories below the roots given as arguments, which need the secret in GOSHA1_TOKEN as a bearer token. Lookups are open to anyone who can connect.")
+	flag.StringVar(&opts.serve, "serve", "", "Run as an agent for -workers on this TCP address (e.g. localhost:7070) or unix socket path, hashing the files below the roots given as arguments for any caller with the secret in GOSHA1_TOKEN, which TCP needs. Bind to a trusted network only, the traffic isn't encrypted.")
+	flag.StringVar(&opts.workers, "workers", "", "Comma separated -serve agents (host:port) that hash the files walked here, they must see the same paths, e.g. nodes mounting the same NAS.")
+	flag.StringVar(&opts.sshCommand, "ssh-command", "ssh", "Command, with its arguments, that runs the sum tool on the host of sftp:// and ssh:// roots, e.g. \"ssh -i key\".")
+	flag.StringVar(&opts.s3Endpoint, "s3-endpoint", "", "Endpoint of s3:// roots for S3 compatible stores like MinIO, e.g. http://localhost:9000 (default AWS_ENDPOINT_URL or AWS itself).")
+	flag.StringVar(&opts.checkpoint, "checkpoint", "", "Append the sums to this journal file as the files are hashed, and remove it when done.")
+	flag.BoolVar(&opts.resume, "resume", false, "Continue the interrupted run of -checkpoint, taking the sums of unchanged files from the journal.")
+	flag.BoolVar(&opts.s3ETag, "s3-etag", false, "With -algos md5, take the sums of s3:// objects uploaded in one part from their ETags instead of downloading them.")
+	flag.StringVar(&opts.ignoreDupes, "ignore-dupes-file", "", "File of intended duplicates left out of the duplicate counts, groups and dedup actions: a sum per line, or two paths separated by a tab.")
+	flag.StringVar(&opts.knownHashes, "known-hashes", "", "Hash set of known files, a list of hex sums of the first of -algos (e.g. sha1sum output) or an NSRL RDS NSRLFile.txt, to leave out or keep with -known-show.")
+	flag.StringVar(&opts.knownShow, "known-show", "unknown", "With -known-hashes, print only the unknown files (not in the set) or only the known ones.")
+	flag.StringVar(&opts.casSkip, "cas-skip", "", "Leave out files whose sum already exists in this sharded content addressed store.")
+	flag.BoolVar(&opts.diff, "diff", false, "Report the files added, removed, modified and renamed between the old and new manifests given as arguments.")
+	flag.BoolVar(&opts.manifestDiff, "manifest-diff", false, "Report how duplicated space changed between the old and new manifests given as arguments.")
+	flag.BoolVar(&opts.mergeManifests, "merge-manifests", false, "Report content duplicated across the manifests given as arguments, and directories hashed now to compare with them.")
+	flag.StringVar(&opts.normalize, "normalize", "", "Unicode normalize paths, nfc or nfd, before sorting, comparing and printing them, so manifests made on macOS (NFD) and Linux (NFC) match.")
+	flag.BoolVar(&opts.ads, "ads", false, "Also hash the named NTFS alternate data streams of each file, printed as path:stream (Windows only).")
+	flag.BoolVar(&opts.noIgnoreFiles, "no-ignore-files", false, "Don't honour the "+ignoreFileName+" files found in the walk.")
+	flag.BoolVar(&opts.respectGitignore, "respect-gitignore", false, "Skip the files and directories ignored by the .gitignore and .git/info/exclude files found in the walk, and .git directories.")
+	flag.BoolVar(&opts.emptyDirs, "empty-dirs", false, "Also list the directories without any entries, in a section of their own after the files.")
+	flag.StringVar(&opts.emptyFiles, "empty-files", "group", "Zero byte files: group (with other duplicates), skip, or separate (own section, left out of the duplicate stats).")
+	flag.IntVar(&opts.jobs, "j", 0, "Number of files hashed in parallel, 1 hashes strictly one file at a time (default one per CPU).")
+	flag.IntVar(&opts.queueDepth, "queue-depth", jobQueue, "Number of files the walk may list ahead of the workers.")
+	flag.IntVar(&opts.resultQueue, "result-queue", 0, "Number of hashed files the workers may finish ahead of the output (default 0, each waits for its result to be taken).")
+	flag.BoolVar(&opts.autoJobs, "auto-jobs", false, "Pick -j, -prefetch and -buffer-size from the storage of the roots: rotational disk, SSD or network file system, unless given.")
+	flag.IntVar(&opts.jobs, "jobs", 0, "Same as -j.")
+	flag.IntVar(&opts.prefetch, "prefetch", 0, "Start reading up to this many files ahead of the workers, for network and cloud filesystems where read latency dominates (0 disables).")
+	flag.Var(&opts.limitRate, "limit-rate", "Limit the total read rate of all workers to this many bytes per second, e.g. 50M.")
+	opts.splitMin = 256 << 20
+	flag.Var(&opts.splitMin, "split-min", "Hash files of at least this size in parallel pieces, e.g. 1G, with -algos blake3 alone (0 disables).")
+	flag.Var(&opts.mmapMin, "mmap", "Map files of at least this size into memory instead of reading them, e.g. 64M, falling back to reading where that fails (0 disables).")
+	flag.Var(&opts.bufferSize, "buffer-size", "Read files in chunks of this size, e.g. 1M (default 32K).")
+	flag.BoolVar(&opts.noCachePollution, "no-cache-pollution", false, "Drop the files read from the page cache as they are hashed, so a big scan doesn't evict the cache of other programs (Linux only).")
+	flag.BoolVar(&opts.syncFirst, "sync-first", false, "Fsync each file before hashing it (slow, see README).")
+	flag.BoolVar(&opts.archives, "archives", false, "Also hash each file inside .zip, .jar, .tar, .tgz and .tar.gz archives, as archive.zip!/inner/file.")
+	flag.BoolVar(&opts.watch, "watch", false, "Keep running and print a new, changed or deleted line whenever a file below the roots changes, updating -cache and -db.")
+	flag.DurationVar(&opts.watchInterval, "watch-interval", 2*time.Second, "How often -watch polls the tree for changes. Each poll walks the roots again but only rehashes the files whose size or mtime changed.")
+	flag.StringVar(&opts.db, "db", "", "Upsert the results into the manifest table (path, hash, size, mtime, first_seen, last_seen) of this SQLite database.")
+	flag.StringVar(&opts.sqlite, "sqlite", "", "Also write the results to this SQLite database.")
+	flag.BoolVar(&opts.caseCollisions, "case-collisions", false, "Also list the paths that differ only in case, which clash when copied to FAT, NTFS or APFS, in a section of their own after the files.")
+	flag.BoolVar(&opts.foldCase, "case-insensitive-fs", false, "Treat paths differing only in case as the same file and hash it once.")
+	flag.Var(durationValue{&opts.timeout}, "timeout", "Stop the whole run after this long, e.g. 1h, and print what was hashed so far (0 runs to completion).")
+	flag.Var(durationValue{&opts.fileTimeout}, "file-timeout", "Give up on a file that takes longer than this to open and hash, in seconds or e.g. 1m30s, and carry on with the rest (0 waits forever).")
+	flag.IntVar(&opts.retries, "retries", 0, "Read a file again up to this many times after an I/O error or timeout, e.g. from a USB enclosure or network mount, before failing it.")
+	opts.retryDelay = time.Second
+	flag.Var(durationValue{&opts.retryDelay}, "retry-delay", "Wait this long before the first of -retries, doubling it for each further retry.")
+	flag.StringVar(&opts.cache, "cache", "", "Remember sums, sizes and mtimes in this file between runs.")
+	flag.BoolVar(&opts.stream, "stream", false, "Print each result as soon as it is hashed, unsorted, instead of keeping all of them in memory.")
+	flag.StringVar(&opts.exec, "exec", "", "Run this command for each file hashed, as soon as it is, with {path}, {hash}, {size} and {algo} replaced, e.g. 'tag-file {path} {hash}'.")
+	flag.Var(&opts.maxMemory, "max-memory", "Limit the memory use to about this size, e.g. 2G: a soft limit for the garbage collector and, for the plain file list, -spill-at with results beyond half of it.")
+	flag.IntVar(&opts.spillAt, "spill-at", 0, "Sort with temporary files: keep at most this many results in memory, spilling sorted runs to $TMPDIR and merging them for the output.")
+	flag.BoolVar(&opts.keepGoing, "keep-going", false, "Skip files and directories that can't be read, list them at the end and exit with 2.")
+	flag.BoolVar(&opts.listErrors, "list-errors", false, "With -keep-going, also list the files that failed after the results, as \"# error: CLASS<TAB>PATH<TAB>MESSAGE\" comment lines with CLASS missing, permission, io, timeout or other.")
+	flag.BoolVar(&opts.noCache, "no-cache", false, "With -cache, rehash every file but still update the cache.")
+	flag.BoolVar(&opts.changedOnly, "changed-only", false, "With -cache, only print files that are new, changed or deleted since the last run.")
+	flag.BoolVar(&opts.linkDupes, "link-dupes", false, "Replace the redundant copies in each duplicate group with hard links to the kept one (same file system only), logging each action.")
+	flag.BoolVar(&opts.reflinkDupes, "reflink-dupes", false, "Replace the redundant copies in each duplicate group with copy-on-write clones of the kept one (btrfs, XFS and other file systems with FICLONE, Linux only), logging each action.")
+	flag.BoolVar(&opts.dryRun, "dry-run", false, "With -link-dupes, -reflink-dupes or -delete-dupes, only print what would be done (the default for -delete-dupes unless -confirm is given).")
+	flag.BoolVar(&opts.deleteDupes, "delete-dupes", false, "Delete the redundant copies in each duplicate group, logging each action. Only a dry run unless -dry-run=false or -confirm is given.")
+	flag.BoolVar(&opts.tui, "tui", false, "Browse the duplicate groups in a terminal UI, marking files to delete or replace with hard links, and act on the marks after confirming them.")
+	flag.BoolVar(&opts.yes, "yes", false, "Let -delete-dupes delete for good without asking on a terminal, e.g. from cron (with -dry-run=false).")
+	flag.StringVar(&opts.trash, "trash", "", "Keep the files the -dupes actions delete or replace in this directory, on the same file system, with a journal for -undo.")
+	flag.StringVar(&opts.undo, "undo", "", "Put the files in this -trash directory back where they were.")
+	flag.BoolVar(&opts.confirm, "confirm", false, "With -link-dupes, -reflink-dupes or -delete-dupes, ask on stdin before acting on each duplicate group.")
+	flag.StringVar(&opts.keep, "keep", "shortest", "Which copy of a duplicate group to keep: shortest (path), oldest or newest (mtime).")
+	keepPrefix := flag.String("keep-prefix", "", "Comma separated directories, a copy under an earlier one is kept first, before -keep decides.")
+	flag.StringVar(&opts.report, "report", "", "Print a report instead of the file list: usage (files, bytes and duplicate bytes per extension and per top level directory).")
+	flag.BoolVar(&opts.dupeDirs, "dupe-dirs", false, "Report duplicated directory trees, with the same files by sum and relative path, as one entry per set of copies with their size.")
+	flag.BoolVar(&opts.treeDigest, "tree-digest", false, "Also print a digest per directory, rolled up from the sums of its children, ending with one for the whole tree.")
+	flag.Int64Var(&opts.quick, "quick", 0, "With -dupes, -keep-under or -annotate, first hash only the size and the first and last N MB of larger files and hash in full only those whose quick sum another file shares.")
+	flag.StringVar(&opts.filesFrom, "files-from", "", "Hash the files listed in this file (- for stdin), one per line or NUL terminated as from find -print0, instead of walking directories.")
+	flag.BoolVar(&opts.print0, "print0", false, "End each output record with a NUL instead of a newline, for xargs -0 and paths with newlines.")
+	flag.BoolVar(&opts.print0, "0", false, "Same as -print0.")
+	flag.BoolVar(&opts.dupes, "dupes", false, "Print only the duplicate groups, each with its number of copies and oldest and newest mtime.")
+	flag.StringVar(&opts.prefilterAlgo, "prefilter-algo", "", "With -dupes or -keep-under, first hash with this cheaper algorithm and only confirm files sharing a sum with -algos.")
+	flag.StringVar(&opts.symlinks, "symlinks", "skip", "Symbolic links: skip, follow (with cycle detection) or target (hash the target path string).")
+	flag.BoolVar(&opts.hidden, "hidden", false, "Also walk dot directories such as .git, they are skipped by default.")
+	flag.BoolVar(&opts.perRoot, "per-root", false, "With several roots, print each path under its root as given on the command line instead of absolute.")
+	flag.BoolVar(&opts.commonBase, "common-base", false, "With several roots, print paths relative to their deepest common ancestor instead of absolute.")
+	flag.BoolVar(&opts.absolute, "absolute", false, "Print absolute paths instead of paths relative to the root.")
+	flag.StringVar(&opts.relativeTo, "relative-to", "", "Print paths relative to this directory instead of the root, with .. for paths outside it.")
+	flag.BoolVar(&opts.selftest, "selftest", false, "Hash a generated tree with known sums and duplicates and print PASS or FAIL. An optional argument picks the directory to create it in.")
+	flag.BoolVar(&opts.pureGo, "pure-go", false, "Hash sha1 with gosha1's own plain Go code instead of crypto/sha1 and its SHA-NI, AVX2 or ARMv8 assembly, e.g. to rule the assembly out. Slower.")
+	flag.BoolVar(&opts.bench, "bench", false, "Measure the hash throughput of each algorithm and, given a directory, how fast its files read with more and more workers, and print the -j and -algo to use.")
+	flag.StringVar(&opts.output, "output", "", "Write the results to this file, replacing it only once the run succeeded.")
+	flag.StringVar(&opts.output, "o", "", "Same as -output.")
+	flag.IntVar(&opts.shardOutput, "shard-output", 0, "With -output FILE, split the sorted results by sum prefix into this many files FILE.0 to FILE.N-1, each sorted on its own.")
+	flag.BoolVar(&opts.summaryOnly, "summary", false, "Print only the file, duplicate and byte totals instead of a line per file, as key<TAB>value lines or with -format json as one object.")
+	flag.BoolVar(&opts.quiet, "q", false, "Quiet, only print errors and warnings to stderr, no totals or status lines.")
+	flag.BoolVar(&opts.verbose, "v", false, "Verbose, also log each root walked and the directories skipped.")
+	flag.BoolVar(&opts.debug, "vv", false, "Debug, like -v and also log when each file is opened and how many bytes were read in how long, to find slow files and file systems.")
+	flag.StringVar(&opts.summaryJSON, "summary-json", "", "Write a JSON run summary (files, bytes, duplicates, errors, wall time, throughput) to this file, or to an inherited descriptor as fd:N.")
+	flag.BoolVar(&opts.xattr, "xattr", false, "Store sums and mtime in user.gosha1.* extended attributes and trust them (or cshatag's user.shatag.*) while the mtime matches (Linux only).")
+	flag.BoolVar(&opts.oneFileSystem, "one-file-system", false, "Don't descend into directories on other file systems, like du -x.")
+	flag.BoolVar(&opts.oneFileSystem, "x", false, "Same as -one-file-system.")
+	flag.Var(&opts.pieceSize, "piece-size", "Also hash each file in pieces of this size, e.g. 16M, and print their sums below its line, so -check can tell which byte ranges of a changed file differ.")
+	flag.Var(&opts.minSize, "min-size", "Only hash files of at least this size, e.g. 10K, 100M or 2G.")
+	flag.Var(timeBound{&opts.newerThan}, "newer-than", "Only hash files modified after this time, an age such as 30d, 6h or 2w, or an RFC 3339 timestamp or date such as 2024-05-01.")
+	flag.Var(timeBound{&opts.olderThan}, "older-than", "Only hash files modified before this time, an age such as 30d, 6h or 2w, or an RFC 3339 timestamp or date.")
+	flag.Var(&opts.maxSize, "max-size", "Only hash files of at most this size, e.g. 10K, 100M or 2G (0 for no limit).")
+	flag.Var(&opts.exclude, "exclude", "Repeatable gitignore style pattern of files and directories to skip, e.g. node_modules/ or *.tmp.")
+	flag.Var(&opts.include, "include", "Repeatable gitignore style pattern, only files matching one of them are hashed.")
+	flag.IntVar(&opts.maxDepth, "max-depth", 0, "Only hash files up to this many levels below the roots, 1 for the files directly in them (0 for no limit).")
+	flag.Var(&opts.depthRules, "depth-rule", "Repeatable PATTERN=N, only hash files up to N levels below directories matching PATTERN (most specific pattern wins).")
+	flag.IntVar(&opts.top, "top", 0, "Only report the N duplicate groups wasting the most space, most first (0 reports all).")
+	flag.BoolVar(&opts.crossDirOnly, "cross-dir-only", false, "With -dupes or -keep-under, leave out duplicate groups whose copies are all in one directory.")
+	flag.StringVar(&opts.sort, "sort", "", "Order of the file list: sum (or hash, the default), path, size or mtime, compared byte by byte with the path breaking ties. Of duplicate groups with -dupes or -keep-under: sum, copies (most copies first) or wasted (most wasted bytes first), wasted by default with -dupes.")
+	flag.BoolVar(&opts.noSort, "no-sort", false, "List the files in the order they were hashed instead of sorting them.")
+	flag.StringVar(&opts.pprofAddr, "pprof-addr", "", "Serve the net/http/pprof profiling endpoints on /debug/pprof/ at this TCP address (e.g. localhost:6060) or unix socket path.")
+	flag.StringVar(&opts.cpuProfile, "cpuprofile", "", "Write a CPU profile of the run to this file, for go tool pprof.")
+	flag.StringVar(&opts.memProfile, "memprofile", "", "Write a heap profile to this file at the end of the run, for go tool pprof.")
+	flag.StringVar(&opts.metricsAddr, "metrics-addr", "", "Serve Prometheus metrics (files, bytes and errors so far, queue depth, bytes per worker) on /metrics at this TCP address (e.g. :9100) or unix socket path.")
+	flag.StringVar(&opts.listen, "listen", "", "Stream the results as JSON lines to clients connecting to this TCP address (e.g. :9000) or unix socket path.")
+	flag.BoolVar(&opts.preserveRootOrder, "preserve-root-order", false, "Print the results in a section per root, in argument order, instead of sorted together.")
+	flag.StringVar(&opts.annotate, "annotate", "", "Print every copy in each duplicate group as KEEP, LEAVE (also under -keep-under) or the action for the redundant copies: delete or link.")
+	flag.StringVar(&opts.keepUnder, "keep-under", "", "Keep the copy below this directory in each duplicate group and print the redundant copies elsewhere.")
+	flag.StringVar(&opts.sumEncoding, "encoding", "hex", "How sums are printed in the file list: hex, multihash (base58btc, as IPFS names them) or sri (sha256-<base64>, for integrity attributes).")
+	flag.StringVar(&opts.format, "format", "text", "Output format: text (tab separated -fields), csv or tsv (quoted -fields with a header row), json (an object per file and a trailing summary object) or coreutils (\"<sum>  <path>\" lines for sha1sum -c).")
+	flag.BoolVar(&opts.tag, "tag", false, "With -format coreutils, print BSD style \"SHA1 (path) = <sum>\" lines for shasum -c.")
+	resultsFd := flag.Int("results-fd", 1, "Write results to this inherited file descriptor.")
+	progressFd := flag.Int("progress-fd", 2, "Write stats, progress and errors to this inherited file descriptor.")
+	algos := flag.String("algos", "sha1", "Comma separated hash algorithms computed in one pass, the first is used for duplicates: "+hashwalk.AlgorithmNames())
+	flag.StringVar(algos, "algo", "sha1", "Same as -algos, e.g. -algo sha256 or -algo sha1,sha256,blake2b.")
+	types := flag.String("type", "", "Comma separated content types to hash, sniffed from the first 512 bytes, e.g. image,video or application/pdf.")
+	flag.StringVar(&opts.typeUnknown, "type-unknown", "skip", "With -type, skip or include files whose content type can't be determined.")
+	hashMetadata := flag.String("hash-metadata", "", "Comma separated metadata to hash into a second sum per file (meta field): "+strings.Join(metadataFields, ",")+" or all, so permission, owner and time changes show even when the contents are the same.")
+	fields := flag.String("fields", "", "Comma separated output fields: "+strings.Join(validFields, ",")+" or an algorithm name (default sum,path, or the -algos and path)")
+	flag.StringVar(fields, "columns", "", "Same as -fields, e.g. -format csv -columns hash,path,size,mtime.")
+	flag.CommandLine.Init(os.Args[0], flag.ContinueOnError)
+	if err := loadConfig(configPath()); err != nil {
+		logError(err)
+		exit(exitUsage)
+	}
+	if err := flag.CommandLine.Parse(os.Args[1:]); err == flag.ErrHelp {
+		exit(exitOK)
+	} else if err != nil {
+		exit(exitUsage)
+	}
+	opts.args = flag.Args()
+	setVerbosity()
+	if *keepPrefix != "" {
+		opts.keepPrefix = strings.Split(*keepPrefix, ",")
+	}
+	if opts.deleteDupes && !opts.confirm && !flagGiven("dry-run") {
+		opts.dryRun = true
+	}
+	var err error
+	if *progressFd != 2 {
+		stderr, err = openFd(*progressFd)
+		if err != nil {
+			logError(err)
+			exit(exitUsage)
+		}
+	}
+	if *resultsFd != 1 {
+		stdout, err = openFd(*resultsFd)
+		if err != nil {
+			logError(err)
+			exit(exitUsage)
+		}
+	}
+	opts.algos, err = hashwalk.ParseAlgorithms(*algos)
+	if err != nil {
+		logError(err)
+		exit(exitUsage)
+	}
+	opts.types = parseTypes(*types)
+	opts.fields, err = parseFields(*fields, opts.algos)
+	if err != nil {
+		logError(err)
+		exit(exitUsage)
+	}
+	if *fields == "" && opts.entropy {
+		opts.fields = withEntropy(opts.fields)
+	}
+	opts.hashMetadata, err = parseMetadata(*hashMetadata)
+	if err != nil {
+		logError(err)
+		exit(exitUsage)
+	}
+	if *fields == "" && opts.hashMetadata != nil {
+		opts.fields = insertBeforePath(opts.fields, "meta")
+	}
+	if *fields == "" && opts.sparse {
+		if !hasField(opts.fields, "size") {
+			opts.fields = insertBeforePath(opts.fields, "size")
+		}
+		opts.fields = insertBeforePath(opts.fields, "allocated")
 	}
-	err := processRootDir(dirpath)
+	streams := opts.filesFrom == "" && allStreams(opts.args)
+	if *fields == "" && streams {
+		opts.fields = insertBeforePath(opts.fields, "size")
+	}
+	// Before the checks, so a -spill-at that -max-memory sets is checked
+	// like a given one.
+	applyLimits()
+	err = validateOptions(&opts)
 	if err != nil {
-		log("ERROR: ", err)
-		os.Exit(1)
+		logError(err)
+		exit(exitUsage)
+	}
+	if opts.pureGo {
+		hashwalk.UsePureSHA1()
+	}
+	if opts.limitRate > 0 {
+		readLimiter = newRateLimiter(int64(opts.limitRate))
+	}
+	if opts.slowest > 0 {
+		slowest = &slowestFiles{n: opts.slowest}
+	}
+	if opts.chunkDedup {
+		chunkDedup = newChunkSet()
+	}
+	if opts.statusFiles {
+		workerFiles = &currentFiles{}
+	}
+	if opts.autoJobs {
+		roots := opts.args
+		if opts.check {
+			roots = []string{"."}
+			if len(opts.args) > 1 {
+				roots = opts.args[1:]
+			}
+		}
+		autoTune(roots)
+	}
+	if opts.ignoreDupes != "" {
+		intendedDupes, err = loadIgnoreDupes(opts.ignoreDupes, opts.algos[0])
+		if err != nil {
+			logError(err)
+			exit(exitError)
+		}
+	}
+	if opts.knownHashes != "" {
+		knownSet, err = loadKnownHashes(opts.knownHashes, opts.algos[0])
+		if err != nil {
+			logError(err)
+			exit(exitError)
+		}
+	}
+	if err := startProfiles(); err != nil {
+		logError(err)
+		exit(exitError)
+	}
+	defer stopProfiles()
+	if opts.metricsAddr != "" {
+		if err := serveMetrics(opts.metricsAddr); err != nil {
+			logError(err)
+			exit(exitError)
+		}
+	}
+	if opts.undo != "" {
+		if err := runUndo(opts.undo); err != nil {
+			logError(err)
+			exit(exitError)
+		}
+		return
+	}
+	if opts.selftest {
+		dir := ""
+		if len(opts.args) > 0 {
+			dir = opts.args[0]
+		}
+		exit(runSelftest(dir))
+	}
+	if opts.bench {
+		dir := ""
+		if len(opts.args) > 0 {
+			dir = opts.args[0]
+		}
+		exit(runBench(dir))
+	}
+	if opts.check {
+		dir := "."
+		if len(opts.args) > 1 {
+			dir = opts.args[1]
+		}
+		exit(runCheck(opts.args[0], dir))
+	}
+	if opts.cmp {
+		exit(runCompare(opts.args[0], opts.args[1], opts.cmpBytes))
+	}
+	if opts.diff {
+		exit(snapshotDiff(opts.args[0], opts.args[1]))
+	}
+	if opts.manifestDiff {
+		err = manifestDiff(opts.args[0], opts.args[1])
+		if err != nil {
+			logError(err)
+			exit(exitError)
+		}
+		return
+	}
+	if opts.mergeManifests {
+		err = mergeManifests(opts.args)
+		if err != nil {
+			logError(err)
+			exit(exitError)
+		}
+		if found {
+			exit(exitFound)
+		}
+		return
+	}
+	if opts.workers != "" {
+		agents, err = dialAgents(strings.Split(opts.workers, ","))
+		if err != nil {
+			logError(err)
+			exit(exitError)
+		}
+	}
+	var signer *signKey
+	if opts.sign != "" {
+		signer, err = loadSigningKey(opts.sign)
+		if err != nil {
+			logError(err)
+			exit(exitUsage)
+		}
+	}
+	var out *atomicOutput
+	if opts.output != "" {
+		if *resultsFd != 1 {
+			logError("only one of -output and -results-fd can be used")
+			exit(exitUsage)
+		}
+		if opts.shardOutput > 0 {
+			shards, err = createShards(opts.output, opts.shardOutput)
+			if err == nil {
+				stdout = shards.outs[0].f
+			}
+		} else {
+			out, err = createOutput(opts.output)
+			if err == nil {
+				stdout = out.f
+			}
+		}
+		if err != nil {
+			logError(err)
+			exit(exitError)
+		}
+	}
+	if opts.serve != "" {
+		err = runServe(opts.serve, opts.args)
+	} else if opts.serveDB != "" {
+		err = runServeDB(opts.serveDB, opts.db, opts.args)
+	} else if opts.watch {
+		err = runWatch(opts.args)
+	} else if streams {
+		err = processStreams(opts.args)
+	} else if opts.copy {
+		err = runCopy(opts.args[0], opts.args[1])
+	} else if hasRemoteRoot(opts.args) {
+		if len(opts.args) > 1 {
+			logError("a remote root can only be hashed on its own, or compared with a local tree with -cmp")
+			exit(exitUsage)
+		}
+		err = processRemote(opts.args[0])
+	} else {
+		err = processRoots(opts.args)
+	}
+	if out != nil {
+		if err == nil {
+			err = out.commit()
+			if err == nil && signer != nil {
+				err = signManifest(opts.output, signer)
+			}
+		} else {
+			out.abort()
+			logWarning(opts.output, "left unchanged")
+		}
+	}
+	if shards != nil {
+		if err == nil {
+			err = shards.commit()
+		} else {
+			shards.abort()
+			logWarning("the", opts.shardOutput, "shards of", opts.output, "left unchanged")
+		}
+	}
+	if errors.Is(err, errInterrupted) {
+		logError(err)
+		exit(exitInterrupted)
+	}
+	if err != nil {
+		logError(err)
+		exit(exitError)
+	}
+	if found {
+		exit(exitFound)
 	}
 }
@@ -0,0 +1,437 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"github.com/rajder/gosha1/hashwalk"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// One line of a saved manifest. Size is -1 when the manifest has no size
+// column, Algo is empty when the manifest doesn't record it.
+type manifestEntry struct {
+	Sum  []byte
+	Path string
+	Size int64
+	Host string
+	Algo string
+	// Sums of the pieces of a -piece-size manifest, of PieceSize bytes each.
+	Pieces    [][]byte
+	PieceSize int64
+}
+
+// readManifest parses "sum<TAB>path" lines, and sha1sum or BSD tag lines
+// (see parseCoreutilsLine).
+// Lines starting with # are comments, except "# host: NAME" which sets the
+// host tag of the following entries and "# algos: NAME,..." which records
+// the algorithm of each sum column, the entries keep the first. "# fields:
+// NAME,..." gives the columns of the lines, see parseFieldsLine. The host
+// tag defaults to the file name.
+// "# piece-size: N" and "# piece: I SUM" lines record the -piece-size
+// pieces of the entry before them. Records written with -print0 are NUL
+// terminated, told apart like -files-from does.
+func readManifest(path string) ([]manifestEntry, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	host := filepath.Base(path)
+	algo := ""
+	tagAlgo := "" // Of the first BSD tag line, lines with other algorithms are skipped.
+	var algos, fields []string
+	var pieceSize int64
+	var entries []manifestEntry
+	br := bufio.NewReader(f)
+	sc := bufio.NewScanner(br)
+	sc.Buffer(make([]byte, 64*1024), 1024*1024)
+	nul := false
+	if head, _ := br.Peek(4097); bytes.IndexByte(head, 0) >= 0 {
+		sc.Split(scanNUL)
+		nul = true
+	}
+	n := 0
+	for sc.Scan() {
+		n++
+		line := sc.Text()
+		if line == "" {
+			continue
+		}
+		if !nul && strings.IndexByte(line, 0) >= 0 {
+			return nil, fmt.Errorf("%s:%d: NUL in a newline terminated manifest", path, n)
+		}
+		if strings.HasPrefix(line, "#") {
+			c := strings.TrimSpace(strings.TrimPrefix(line, "#"))
+			if strings.HasPrefix(c, "host:") {
+				host = strings.TrimSpace(strings.TrimPrefix(c, "host:"))
+			}
+			if strings.HasPrefix(c, "piece-size:") {
+				pieceSize, err = strconv.ParseInt(strings.TrimSpace(strings.TrimPrefix(c, "piece-size:")), 10, 64)
+				if err != nil || pieceSize <= 0 {
+					return nil, fmt.Errorf("%s:%d: invalid piece size", path, n)
+				}
+			}
+			if strings.HasPrefix(c, "piece:") && len(entries) > 0 && pieceSize > 0 {
+				var i int
+				var sum []byte
+				_, err := fmt.Sscanf(strings.TrimSpace(strings.TrimPrefix(c, "piece:")), "%d %x", &i, &sum)
+				e := &entries[len(entries)-1]
+				if err != nil || i != len(e.Pieces) {
+					return nil, fmt.Errorf("%s:%d: invalid piece line", path, n)
+				}
+				e.Pieces = append(e.Pieces, sum)
+				e.PieceSize = pieceSize
+			}
+			if strings.HasPrefix(c, "algos:") {
+				algos = strings.Split(strings.TrimSpace(strings.TrimPrefix(c, "algos:")), ",")
+				algo = algos[0]
+			}
+			if strings.HasPrefix(c, "fields:") {
+				fields = strings.Split(strings.TrimSpace(strings.TrimPrefix(c, "fields:")), ",")
+				if !hasField(fields, "path") {
+					return nil, fmt.Errorf("%s:%d: the fields have no path", path, n)
+				}
+			}
+			continue
+		}
+		var e manifestEntry
+		if fields != nil {
+			e, err = parseFieldsLine(line, fields, algos)
+		} else {
+			e, err = parseManifestLine(line, len(algos))
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%s:%d: %v", path, n, err)
+		}
+		e.Host = host
+		e.Path = normPath(e.Path)
+		if strings.HasSuffix(e.Path, "/") {
+			continue // A -tree-digest directory line.
+		}
+		if e.Algo != "" {
+			if tagAlgo == "" {
+				tagAlgo = e.Algo
+			}
+			if e.Algo != tagAlgo {
+				continue
+			}
+		} else {
+			e.Algo = algo
+		}
+		entries = append(entries, e)
+	}
+	return entries, sc.Err()
+}
+
+// scanNUL is a bufio.SplitFunc for NUL terminated records.
+func scanNUL(data []byte, atEOF bool) (advance int, token []byte, err error) {
+	if i := bytes.IndexByte(data, 0); i >= 0 {
+		return i + 1, data[:i], nil
+	}
+	if atEOF && len(data) > 0 {
+		return len(data), data, nil
+	}
+	return 0, nil, nil
+}
+
+// parseManifestLine parses a line with sums sum columns, one per algorithm
+// of -algos, of which the entry gets the first, and the path. Other columns
+// need a "# fields:" header, anything after the sums is the path.
+func parseManifestLine(line string, sums int) (manifestEntry, error) {
+	if e, ok := parseCoreutilsLine(line); ok {
+		return e, nil
+	}
+	e := manifestEntry{Size: -1}
+	if sums < 1 {
+		sums = 1
+	}
+	cols := strings.Split(line, "\t")
+	if len(cols) < sums+1 {
+		return e, fmt.Errorf("expected %d sums and a path separated by tabs", sums)
+	}
+	for i, col := range cols[:sums] {
+		algo, sum, ok := decodeSum(col)
+		if !ok {
+			return e, fmt.Errorf("invalid sum %q", col)
+		}
+		if i == 0 {
+			e.Sum, e.Algo = sum, algo
+		}
+	}
+	e.Path = strings.Join(cols[sums:], "\t")
+	return e, nil
+}
+
+// parseFieldsLine parses a line of a manifest with a "# fields:" header,
+// written with -fields or with extra columns like -entropy. The entry gets
+// the sum field or else the first sum column, preferring the first of
+// algos, and the size. The path is the only field that can hold tabs.
+func parseFieldsLine(line string, fields, algos []string) (manifestEntry, error) {
+	e := manifestEntry{Size: -1}
+	cols := strings.Split(line, "\t")
+	if len(cols) < len(fields) {
+		return e, fmt.Errorf("expected the %d fields %s separated by tabs", len(fields), strings.Join(fields, ","))
+	}
+	// The columns of the path beyond the one it has in fields.
+	extra := len(cols) - len(fields)
+	pathAt := -1
+	found, first := false, false // A sum, of the first of algos.
+	for i, f := range fields {
+		if pathAt >= 0 {
+			i += extra
+		}
+		col := cols[i]
+		switch {
+		case f == "path":
+			pathAt = i
+			e.Path = strings.Join(cols[i:i+extra+1], "\t")
+		case f == "size":
+			size, err := strconv.ParseInt(col, 10, 64)
+			if err != nil {
+				return e, fmt.Errorf("invalid size %q", col)
+			}
+			e.Size = size
+		case f == "sum" || hashwalk.Algorithms[f] != nil:
+			isFirst := f == "sum" || len(algos) > 0 && f == algos[0]
+			if found && (first || !isFirst) {
+				continue
+			}
+			algo, sum, ok := decodeSum(col)
+			if !ok {
+				return e, fmt.Errorf("invalid sum %q", col)
+			}
+			if algo == "" && f != "sum" {
+				algo = f
+			}
+			e.Sum, e.Algo = sum, algo
+			found, first = true, isFirst
+		}
+	}
+	if !found {
+		return e, fmt.Errorf("no sum among the fields %s", strings.Join(fields, ","))
+	}
+	return e, nil
+}
+
+// mergeManifests loads the manifests and prints every sum found under more
+// than one host tag, without touching the files themselves. Directories
+// among the paths are hashed now, with the algorithm of the manifests, and
+// tagged with their path, so a saved dataset can be compared with a live one.
+func mergeManifests(paths []string) error {
+	var all []manifestEntry
+	var dirs []string
+	for _, p := range paths {
+		if isDir(p) {
+			dirs = append(dirs, p)
+			continue
+		}
+		entries, err := readManifest(p)
+		if err != nil {
+			return err
+		}
+		all = append(all, entries...)
+	}
+	var failed []result
+	if len(dirs) > 0 {
+		algo, err := manifestsAlgo(all)
+		if err != nil {
+			return err
+		}
+		for _, d := range dirs {
+			entries, err := dirEntries(d, algo, &failed)
+			if err != nil {
+				return err
+			}
+			all = append(all, entries...)
+		}
+	}
+	sort.Slice(all, func(i, j int) bool {
+		a, b := &all[i], &all[j]
+		if c := bytes.Compare(a.Sum, b.Sum); c != 0 {
+			return c < 0
+		}
+		if a.Host != b.Host {
+			return a.Host < b.Host
+		}
+		return a.Path < b.Path
+	})
+	var groups, copies int
+	var dupBytes int64
+	for i := 0; i < len(all); {
+		j := i + 1
+		hosts := 1
+		size := all[i].Size
+		for ; j < len(all) && bytes.Equal(all[i].Sum, all[j].Sum); j++ {
+			if all[j].Host != all[j-1].Host {
+				hosts++
+			}
+			if size < 0 {
+				size = all[j].Size
+			}
+		}
+		if hosts > 1 {
+			groups++
+			copies += j - i - 1
+			if size > 0 {
+				dupBytes += size * int64(j-i-1)
+			}
+			printLine("%x\t%d hosts\t%d copies", all[i].Sum, hosts, j-i)
+			for _, e := range all[i:j] {
+				printLine("\t%s\t%s", e.Host, e.Path)
+			}
+		}
+		i = j
+	}
+	log("Manifests    :", len(paths)-len(dirs))
+	if len(dirs) > 0 {
+		log("Directories  :", len(dirs), "hashed")
+	}
+	log("Entries      :", len(all))
+	found = found || groups > 0
+	log("Cross-host   :", groups)
+	log("Duplicates   :", copies)
+	log("Duplicate MB :", float64(dupBytes)/1024/1024)
+	return failedFilesError(failed)
+}
+
+// manifestsAlgo returns the algorithm to hash directories with so their
+// sums join with those of the entries: recorded in the manifests or
+// guessed from the sum length, -algos if given or there are no entries.
+func manifestsAlgo(entries []manifestEntry) (string, error) {
+	if len(entries) == 0 || flagGiven("algos") || flagGiven("algo") {
+		return opts.algos[0], nil
+	}
+	if a := entries[0].Algo; a != "" {
+		if _, ok := hashwalk.Algorithms[a]; !ok {
+			return "", fmt.Errorf("unknown hash algorithm %q in the manifests", a)
+		}
+		return a, nil
+	}
+	return algoForSum(entries[0].Sum)
+}
+
+// dirEntries hashes the tree dir with algo into manifest entries tagged
+// with dir, paths relative to it. Files that can't be read go to failed.
+func dirEntries(dir, algo string, failed *[]result) ([]manifestEntry, error) {
+	ctx, cancel := runContext()
+	defer cancel()
+	rs, err := collect(produceConcurrent(ctx, hashSpec{algos: []string{algo}}, walkRoots([]string{dir}, nil)), nil, failed)
+	if serr := stopError(ctx, err); serr != nil {
+		return nil, serr
+	}
+	if err != nil {
+		return nil, err
+	}
+	entries := make([]manifestEntry, 0, len(rs))
+	for _, r := range rs {
+		entries = append(entries, manifestEntry{Sum: r.Sum, Path: relPath(dir, r.Path), Size: r.Size, Host: dir, Algo: algo})
+	}
+	return entries, nil
+}
+
+// Copies and size of one sum in a manifest, size is -1 when unknown.
+type sumStat struct {
+	copies int
+	size   int64
+}
+
+func (s sumStat) wasted() int64 {
+	if s.copies < 2 || s.size < 0 {
+		return 0
+	}
+	return int64(s.copies-1) * s.size
+}
+
+func sumStats(entries []manifestEntry) map[string]sumStat {
+	m := make(map[string]sumStat)
+	for _, e := range entries {
+		s, ok := m[string(e.Sum)]
+		if !ok {
+			s.size = -1
+		}
+		s.copies++
+		if s.size < 0 {
+			s.size = e.Size
+		}
+		m[string(e.Sum)] = s
+	}
+	return m
+}
+
+// manifestDiff reports how the duplicated space changed between two
+// manifests: one line per duplicate group whose number of copies changed,
+// and the totals before and after. Byte counts need manifests written with a
+// size column, e.g. -fields sum,size,path.
+func manifestDiff(oldPath, newPath string) error {
+	oldEntries, err := readManifest(oldPath)
+	if err != nil {
+		return err
+	}
+	newEntries, err := readManifest(newPath)
+	if err != nil {
+		return err
+	}
+	before := sumStats(oldEntries)
+	after := sumStats(newEntries)
+	type change struct {
+		sum      string
+		old, new sumStat
+		delta    int64
+	}
+	var changes []change
+	seen := make(map[string]bool)
+	for _, m := range []map[string]sumStat{before, after} {
+		for sum := range m {
+			if seen[sum] {
+				continue
+			}
+			seen[sum] = true
+			o, n := before[sum], after[sum]
+			if o.copies == n.copies || (o.copies < 2 && n.copies < 2) {
+				continue
+			}
+			if n.size < 0 {
+				n.size = o.size
+			}
+			if o.size < 0 {
+				o.size = n.size
+			}
+			changes = append(changes, change{sum, o, n, n.wasted() - o.wasted()})
+		}
+	}
+	sort.Slice(changes, func(i, j int) bool {
+		a, b := changes[i], changes[j]
+		if a.delta != b.delta {
+			return a.delta < b.delta
+		}
+		return a.sum < b.sum
+	})
+	printLine("# sum\told copies\tnew copies\twasted bytes delta")
+	for _, c := range changes {
+		printLine("%x\t%d\t%d\t%+d", c.sum, c.old.copies, c.new.copies, c.delta)
+	}
+	var oldDups, newDups int
+	var oldBytes, newBytes int64
+	for _, s := range before {
+		if s.copies > 1 {
+			oldDups += s.copies - 1
+		}
+		oldBytes += s.wasted()
+	}
+	for _, s := range after {
+		if s.copies > 1 {
+			newDups += s.copies - 1
+		}
+		newBytes += s.wasted()
+	}
+	log("Changed groups   :", len(changes))
+	log("Duplicates       :", oldDups, "->", newDups)
+	log("Duplicate MB     :", float64(oldBytes)/1024/1024, "->", float64(newBytes)/1024/1024)
+	log("Duplicate MB diff:", float64(newBytes-oldBytes)/1024/1024)
+	return nil
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"fmt"
+	"github.com/rajder/gosha1/hashwalk"
+	"io"
+	"os"
+	"strings"
+	"time"
+)
+
+// Metadata -hash-metadata can cover.
+var metadataFields = []string{"mode", "owner", "mtime", "link"}
+
+// parseMetadata parses -hash-metadata, a comma separated list of
+// metadataFields or all of them.
+func parseMetadata(s string) ([]string, error) {
+	if s == "" {
+		return nil, nil
+	}
+	if s == "all" {
+		return metadataFields, nil
+	}
+	var out []string
+	for _, f := range strings.Split(s, ",") {
+		f = strings.TrimSpace(f)
+		if !hasField(metadataFields, f) {
+			return nil, fmt.Errorf("unknown metadata %q in -hash-metadata, valid: %s,all", f,
+				strings.Join(metadataFields, ","))
+		}
+		out = append(out, f)
+	}
+	return out, nil
+}
+
+// metadataSum returns the meta field of the file of j for -hash-metadata:
+// the first of the algos over a line per selected piece of metadata, so a
+// change of permissions or owner shows in a manifest even if the contents
+// are the same. The link target is that of a symlink hashed with -symlinks
+// target, empty for other files.
+func metadataSum(j job, algo string) []byte {
+	var b strings.Builder
+	for _, f := range opts.hashMetadata {
+		switch f {
+		case "mode":
+			fmt.Fprintf(&b, "mode %o\n", uint32(j.Info.Mode()))
+		case "owner":
+			if uid, gid, ok := fileOwner(j.Info); ok {
+				fmt.Fprintf(&b, "owner %d:%d\n", uid, gid)
+			}
+		case "mtime":
+			fmt.Fprintf(&b, "mtime %s\n", j.Info.ModTime().UTC().Format(time.RFC3339Nano))
+		case "link":
+			target := j.Link
+			if target == "" && j.Info.Mode()&os.ModeSymlink != 0 {
+				target, _ = os.Readlink(j.Path)
+			}
+			fmt.Fprintf(&b, "link %s\n", target)
+		}
+	}
+	h := hashwalk.NewHashes([]string{algo})[0]
+	io.WriteString(h, b.String())
+	return h.Sum(nil)
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// jobQueue is how many files the walker may list ahead of the workers by
+// default, so a slow directory read doesn't leave them idle. Its fill is the
+// queue depth of -metrics-addr and the status line.
+const jobQueue = 256
+
+// metrics are the counters served in the Prometheus text format on
+// /metrics for -metrics-addr. They add up over all the scans of the run,
+// e.g. the passes of -watch.
+type metrics struct {
+	files  int64 // Atomic.
+	bytes  int64 // Atomic.
+	cached int64 // Atomic.
+	errors int64 // Atomic.
+	start  time.Time
+
+	mu      sync.Mutex
+	workers []*int64 // Bytes hashed by each worker, atomic.
+	jobs    chan job // Queue of the running scan.
+}
+
+// runMetrics is nil without -metrics-addr.
+var runMetrics *metrics
+
+// serveMetrics listens on addr and serves /metrics until the run ends.
+func serveMetrics(addr string) error {
+	ln, err := net.Listen(listenNetwork(addr), addr)
+	if err != nil {
+		return err
+	}
+	runMetrics = &metrics{start: time.Now()}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/metrics", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+		runMetrics.write(w)
+	})
+	go http.Serve(ln, mux)
+	return nil
+}
+
+// scanStarted makes jobs the queue whose depth is reported.
+func (m *metrics) scanStarted(jobs chan job) {
+	if m == nil {
+		return
+	}
+	m.mu.Lock()
+	m.jobs = jobs
+	m.mu.Unlock()
+}
+
+// worker returns the byte counter of worker i, adding it on first use.
+func (m *metrics) worker(i int) *int64 {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for len(m.workers) <= i {
+		m.workers = append(m.workers, new(int64))
+	}
+	return m.workers[i]
+}
+
+// hashed counts the result r of worker i.
+func (m *metrics) hashed(i int, r *result) {
+	if m == nil {
+		return
+	}
+	switch {
+	case r.Err != nil:
+		atomic.AddInt64(&m.errors, 1)
+	case r.Cached:
+		atomic.AddInt64(&m.files, 1)
+		atomic.AddInt64(&m.cached, 1)
+	case r.Alias != "":
+		// A hard link of a file hashed once.
+		atomic.AddInt64(&m.files, 1)
+	default:
+		atomic.AddInt64(&m.files, 1)
+		atomic.AddInt64(&m.bytes, r.Size)
+		atomic.AddInt64(m.worker(i), r.Size)
+	}
+}
+
+func (m *metrics) write(w io.Writer) {
+	metric := func(name, typ, help string, v interface{}) {
+		fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n%s %v\n", name, help, name, typ, name, v)
+	}
+	metric("gosha1_files_hashed_total", "counter", "Files hashed or taken from a cache.", atomic.LoadInt64(&m.files))
+	metric("gosha1_files_cached_total", "counter", "Files whose sum was taken from -cache or -xattr.", atomic.LoadInt64(&m.cached))
+	metric("gosha1_bytes_hashed_total", "counter", "Bytes read and hashed.", atomic.LoadInt64(&m.bytes))
+	metric("gosha1_errors_total", "counter", "Files and directories that couldn't be read.", atomic.LoadInt64(&m.errors))
+	m.mu.Lock()
+	depth := 0
+	if m.jobs != nil {
+		depth = len(m.jobs)
+	}
+	workers := append([]*int64(nil), m.workers...)
+	m.mu.Unlock()
+	metric("gosha1_queue_depth", "gauge", "Files listed by the walker and waiting for a worker.", depth)
+	metric("gosha1_workers", "gauge", "Number of hashing workers.", workerCount())
+	metric("gosha1_start_time_seconds", "gauge", "Start of the run in seconds since the epoch.", m.start.Unix())
+	fmt.Fprintf(w, "# HELP gosha1_worker_bytes_hashed_total Bytes hashed by each worker, rate() gives its throughput.\n")
+	fmt.Fprintf(w, "# TYPE gosha1_worker_bytes_hashed_total counter\n")
+	for i, n := range workers {
+		fmt.Fprintf(w, "gosha1_worker_bytes_hashed_total{worker=\"%d\"} %d\n", i, atomic.LoadInt64(n))
+	}
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"github.com/rajder/gosha1/hashwalk"
+	"io"
+	"os"
+	"runtime/debug"
+)
+
+// mmapChunk is how much of a mapped file is hashed between checks of ctx
+// and -limit-rate.
+const mmapChunk = 4 << 20
+
+// mmapSums hashes f by mapping it into memory instead of reading it, for
+// -mmap. ok is false if f couldn't be mapped, e.g. on some network file
+// systems, and should be read instead. A file truncated while it is mapped
+// faults instead of returning an error, that fault is recovered and
+// reported as an error.
+func mmapSums(ctx context.Context, f *os.File, size int64, algos []string, tee io.Writer) (sums [][]byte, written int64, ok bool, err error) {
+	data, merr := mmapFile(f, size)
+	if merr != nil {
+		return nil, 0, false, nil
+	}
+	defer munmapFile(data)
+	defer debug.SetPanicOnFault(debug.SetPanicOnFault(true))
+	defer func() {
+		if recover() != nil {
+			sums, ok, err = nil, true, fmt.Errorf("%s: changed while it was hashed", f.Name())
+		}
+	}()
+	hs := hashwalk.NewHashes(algos)
+	ws := make([]io.Writer, 0, len(hs)+1)
+	for _, h := range hs {
+		ws = append(ws, h)
+	}
+	if tee != nil {
+		ws = append(ws, tee)
+	}
+	w := io.MultiWriter(ws...)
+	for off := 0; off < len(data); off += mmapChunk {
+		if err := ctx.Err(); err != nil {
+			return nil, written, true, err
+		}
+		end := off + mmapChunk
+		if end > len(data) {
+			end = len(data)
+		}
+		if readLimiter != nil {
+			if err := readLimiter.take(ctx, end-off); err != nil {
+				return nil, written, true, err
+			}
+		}
+		n, err := w.Write(data[off:end])
+		written += int64(n)
+		if err != nil {
+			return nil, written, true, err
+		}
+		if opts.noCachePollution {
+			dropCache(f, int64(off), int64(end-off))
+		}
+	}
+	for _, h := range hs {
+		sums = append(sums, h.Sum(nil))
+	}
+	return sums, written, true, nil
+}
@@ -0,0 +1,15 @@
+//go:build !linux && !darwin && !dragonfly && !freebsd && !netbsd && !openbsd
+// +build !linux,!darwin,!dragonfly,!freebsd,!netbsd,!openbsd
+
+package main
+
+import (
+	"errors"
+	"os"
+)
+
+func mmapFile(f *os.File, size int64) ([]byte, error) {
+	return nil, errors.New("mmap isn't supported on this platform")
+}
+
+func munmapFile(data []byte) {}
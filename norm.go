@@ -0,0 +1,140 @@
+package main
+
+import (
+	"sort"
+	"strings"
+	"unicode/utf8"
+)
+
+// Unicode normalization for -normalize. macOS writes file names decomposed
+// (NFD) while Linux keeps them as typed, mostly composed (NFC), so the same
+// tree differs byte for byte between the two. The standard library has no
+// normalization, the tables in normtables.go are generated from
+// golang.org/x/text.
+
+// Hangul syllables compose and decompose algorithmically.
+const (
+	hangulBase  = 0xAC00
+	hangulL     = 0x1100
+	hangulV     = 0x1161
+	hangulT     = 0x11A7
+	hangulLN    = 19
+	hangulVN    = 21
+	hangulTN    = 28
+	hangulCount = hangulLN * hangulVN * hangulTN
+)
+
+// normPath normalizes the path p per -normalize, paths as they are without.
+func normPath(p string) string {
+	switch opts.normalize {
+	case "nfc":
+		return nfc(p)
+	case "nfd":
+		return nfd(p)
+	}
+	return p
+}
+
+// normalized reports whether s needs no normalization: pure ASCII, or
+// invalid UTF-8, which is kept as is.
+func normalized(s string) bool {
+	return isASCII(s) || !utf8.ValidString(s)
+}
+
+func isASCII(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if s[i] >= utf8.RuneSelf {
+			return false
+		}
+	}
+	return true
+}
+
+// nfd returns the canonical decomposition of s, Normalization Form D.
+func nfd(s string) string {
+	if normalized(s) {
+		return s
+	}
+	return string(decompose(s))
+}
+
+func decompose(s string) []rune {
+	out := make([]rune, 0, len(s))
+	for _, r := range s {
+		switch d, ok := normDecomp[r]; {
+		case r >= hangulBase && r < hangulBase+hangulCount:
+			i := r - hangulBase
+			out = append(out, hangulL+i/(hangulVN*hangulTN), hangulV+i%(hangulVN*hangulTN)/hangulTN)
+			if t := i % hangulTN; t != 0 {
+				out = append(out, hangulT+t)
+			}
+		case ok:
+			out = append(out, []rune(d)...)
+		default:
+			out = append(out, r)
+		}
+	}
+	// Canonical ordering: runs of combining marks are sorted by class.
+	for i := 0; i < len(out); {
+		if normCCC[out[i]] == 0 {
+			i++
+			continue
+		}
+		j := i + 1
+		for j < len(out) && normCCC[out[j]] != 0 {
+			j++
+		}
+		run := out[i:j]
+		sort.SliceStable(run, func(a, b int) bool { return normCCC[run[a]] < normCCC[run[b]] })
+		i = j
+	}
+	return out
+}
+
+// nfc returns the canonical composition of s, Normalization Form C.
+func nfc(s string) string {
+	if normalized(s) {
+		return s
+	}
+	rs := decompose(s)
+	starter := -1 // Index of the last starter in the composed output.
+	out := rs[:0]
+	var lastCCC uint8
+	for _, r := range rs {
+		ccc := normCCC[r]
+		if starter >= 0 {
+			s := out[starter]
+			// A mark can join the starter unless a mark of the same or no
+			// class is between them.
+			blocked := len(out)-1 > starter && (lastCCC == 0 || lastCCC >= ccc)
+			if !blocked {
+				if c, ok := composePair(s, r); ok {
+					out[starter] = c
+					continue
+				}
+			}
+		}
+		if ccc == 0 {
+			starter = len(out)
+		}
+		lastCCC = ccc
+		out = append(out, r)
+	}
+	var b strings.Builder
+	for _, r := range out {
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+func composePair(a, b rune) (rune, bool) {
+	if a >= hangulL && a < hangulL+hangulLN && b >= hangulV && b < hangulV+hangulVN {
+		return hangulBase + ((a-hangulL)*hangulVN+b-hangulV)*hangulTN, true
+	}
+	if a >= hangulBase && a < hangulBase+hangulCount && (a-hangulBase)%hangulTN == 0 &&
+		b > hangulT && b < hangulT+hangulTN {
+		return a + b - hangulT, true
+	}
+	c, ok := normCompose[[2]rune{a, b}]
+	return c, ok
+}
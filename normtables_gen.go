@@ -0,0 +1,70 @@
+//go:build ignore
+// +build ignore
+
+// This program generates normtables.go, the Unicode tables of -normalize,
+// from golang.org/x/text/unicode/norm, which gosha1 doesn't depend on
+// otherwise. Run it with go run normtables_gen.go.
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"go/format"
+	"golang.org/x/text/unicode/norm"
+	"log"
+	"os"
+	"unicode/utf8"
+)
+
+func hangul(r rune) bool {
+	return r >= 0xAC00 && r < 0xAC00+11172
+}
+
+func main() {
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "// Code generated by go run normtables_gen.go. DO NOT EDIT.\n\n")
+	fmt.Fprintf(&b, "package main\n\n")
+	fmt.Fprintf(&b, "// normUnicode is the Unicode version of the tables.\n")
+	fmt.Fprintf(&b, "const normUnicode = %q\n\n", norm.Version)
+	var ccc, decomp, compose bytes.Buffer
+	for r := rune(0); r <= utf8.MaxRune; r++ {
+		if !utf8.ValidRune(r) {
+			continue
+		}
+		s := string(r)
+		if c := norm.NFD.PropertiesString(s).CCC(); c != 0 {
+			fmt.Fprintf(&ccc, "0x%X: %d,\n", r, c)
+		}
+		d := norm.NFD.String(s)
+		if d == s || hangul(r) {
+			continue
+		}
+		fmt.Fprintf(&decomp, "0x%X: %+q,\n", r, d)
+		// Primary composites: r is the composition of the composed form of
+		// its decomposition without the last rune and that rune.
+		rs := []rune(d)
+		if norm.NFC.String(s) != s || len(rs) < 2 {
+			continue
+		}
+		first := []rune(norm.NFC.String(string(rs[:len(rs)-1])))
+		last := rs[len(rs)-1]
+		if len(first) == 1 && norm.NFC.String(string(first[0])+string(last)) == s {
+			fmt.Fprintf(&compose, "{0x%X, 0x%X}: 0x%X,\n", first[0], last, r)
+		}
+	}
+	fmt.Fprintf(&b, "// normCCC is the canonical combining class of the runes that have one.\n")
+	fmt.Fprintf(&b, "var normCCC = map[rune]uint8{\n%s}\n\n", ccc.Bytes())
+	fmt.Fprintf(&b, "// normDecomp is the full canonical decomposition of the runes that\n")
+	fmt.Fprintf(&b, "// have one, except Hangul syllables, which decompose algorithmically.\n")
+	fmt.Fprintf(&b, "var normDecomp = map[rune]string{\n%s}\n\n", decomp.Bytes())
+	fmt.Fprintf(&b, "// normCompose is the primary composite of each pair of runes that\n")
+	fmt.Fprintf(&b, "// composes, except Hangul syllables.\n")
+	fmt.Fprintf(&b, "var normCompose = map[[2]rune]rune{\n%s}\n", compose.Bytes())
+	src, err := format.Source(b.Bytes())
+	if err != nil {
+		log.Fatal(err)
+	}
+	if err := os.WriteFile("normtables.go", src, 0666); err != nil {
+		log.Fatal(err)
+	}
+}
@@ -0,0 +1,475 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"github.com/rajder/gosha1/hashwalk"
+	"os"
+	"strings"
+	"time"
+)
+
+// Command line options.
+type options struct {
+	args             []string
+	cdc              bool
+	cdcMinPerc       float64
+	chunkDedup       bool
+	chunkSize        byteSize
+	fields           []string
+	algos            []string
+	cmp              bool
+	cmpBytes         bool
+	paranoid         bool
+	sparse           bool
+	slowest          int
+	timings          bool
+	detectCollisions bool
+	copy             bool
+	copyVerify       bool
+	cmpContent       bool
+	mergeManifests   bool
+	manifestDiff     bool
+	errorsJSON       string
+	bar              bool
+	prescan          bool
+	statusFiles      bool
+	casSkip          string
+	knownHashes      string
+	sign             string
+	sshCommand       string
+	serve            string
+	serveDB          string
+	workers          string
+	s3Endpoint       string
+	s3ETag           bool
+	checkpoint       string
+	tui              bool
+	resume           bool
+	verifyKey        string
+	knownShow        string
+	emptyFiles       string
+	emptyDirs        bool
+	respectGitignore bool
+	noIgnoreFiles    bool
+	ads              bool
+	normalize        string
+	caseCollisions   bool
+	syncFirst        bool
+	sqlite           string
+	foldCase         bool
+	fileTimeout      time.Duration
+	retries          int
+	retryDelay       time.Duration
+	timeout          time.Duration
+	limitRate        byteSize
+	bufferSize       byteSize
+	mmapMin          byteSize
+	splitMin         byteSize
+	pieceSize        byteSize
+	top              int
+	summaryOnly      bool
+	quiet            bool
+	verbose          bool
+	debug            bool
+	noCachePollution bool
+	cache            string
+	changedOnly      bool
+	keepUnder        string
+	dupes            bool
+	prefilterAlgo    string
+	commonBase       bool
+	absolute         bool
+	relativeTo       string
+	selftest         bool
+	pureGo           bool
+	bench            bool
+	depthRules       depthRules
+	sort             string
+	noSort           bool
+	types            []string
+	typeUnknown      string
+	metricsAddr      string
+	pprofAddr        string
+	cpuProfile       string
+	memProfile       string
+	listen           string
+	entropy          bool
+	hashMetadata     []string
+	prefetch         int
+	crossDirOnly     bool
+	annotate         string
+	check            bool
+	format           string
+	noCache          bool
+	jobs             int
+	autoJobs         bool
+	queueDepth       int
+	resultQueue      int
+	keepGoing        bool
+	listErrors       bool
+	perRoot          bool
+	hidden           bool
+	symlinks         string
+	stream           bool
+	spillAt          int
+	maxMemory        byteSize
+	exec             string
+	sumEncoding      string
+	ignoreDupes      string
+	diff             bool
+	exclude          globList
+	include          globList
+	tag              bool
+	linkDupes        bool
+	reflinkDupes     bool
+	dryRun           bool
+	deleteDupes      bool
+	confirm          bool
+	yes              bool
+	trash            string
+	undo             string
+	keep             string
+	keepPrefix       []string
+	treeDigest       bool
+	dupeDirs         bool
+	report           string
+	quick            int64
+	filesFrom        string
+	print0           bool
+	minSize          byteSize
+	maxSize          byteSize
+	newerThan        time.Time
+	olderThan        time.Time
+	output           string
+	shardOutput      int
+	oneFileSystem    bool
+	maxDepth         int
+	summaryJSON      string
+	xattr            bool
+	db               string
+	watch            bool
+	archives         bool
+	watchInterval    time.Duration
+
+	preserveRootOrder bool
+}
+
+var opts options
+
+func countTrue(bs ...bool) int {
+	n := 0
+	for _, b := range bs {
+		if b {
+			n++
+		}
+	}
+	return n
+}
+
+// flagGiven reports whether the named flag was set on the command line.
+func flagGiven(name string) bool {
+	given := false
+	flag.Visit(func(f *flag.Flag) {
+		given = given || f.Name == name
+	})
+	return given
+}
+
+// groupMode reports whether the output is made of duplicate groups.
+func (o *options) groupMode() bool {
+	return o.dupes || o.keepUnder != "" || o.annotate != "" || o.dedupMode() || o.tui
+}
+
+// dedupMode reports whether the redundant copies are replaced or deleted.
+func (o *options) dedupMode() bool {
+	return o.linkDupes || o.reflinkDupes || o.deleteDupes
+}
+
+// modeCount is the number of modes given that do something else than
+// hashing the roots.
+func (o *options) modeCount() int {
+	return countTrue(o.cmp, o.mergeManifests, o.manifestDiff, o.selftest, o.check, o.diff, o.undo != "", o.bench)
+}
+
+// fileOrder reports whether the file list is printed in another order than
+// by sum, which -spill-at can't merge.
+func (o *options) fileOrder() bool {
+	return o.noSort || o.sort == "path" || o.sort == "size" || o.sort == "mtime"
+}
+
+// spillable reports whether the results are only printed as the plain file
+// list, which -spill-at can sort without keeping them all.
+func (o *options) spillable() bool {
+	return !(o.groupMode() || o.cache != "" || o.sqlite != "" || o.db != "" || o.cdc ||
+		o.preserveRootOrder || o.format == "json" || o.emptyFiles == "separate" || o.casSkip != "" ||
+		o.knownHashes != "" || o.treeDigest || o.dupeDirs || o.report != "" || o.caseCollisions || o.quick > 0 ||
+		o.changedOnly || o.watch || o.serve != "" || o.fileOrder() || o.chunkDedup || o.copy ||
+		o.modeCount() > 0)
+}
+
+// validateOptions checks for contradictory or meaningless flag combinations
+// before any work is done and reports all of them at once.
+func validateOptions(o *options) error {
+	var bad []string
+	check := func(cond bool, msg string) {
+		if cond {
+			bad = append(bad, msg)
+		}
+	}
+	modes := o.modeCount()
+	check(modes > 1,
+		"only one of -check, -cmp, -diff, -merge-manifests, -manifest-diff, -selftest, -bench and -undo can be used")
+	check(o.diff && len(o.args) != 2, "-diff needs an old and a new manifest")
+	check(o.check && (len(o.args) == 0 || len(o.args) > 2), "-check needs a manifest and optionally a directory")
+	check(o.selftest && len(o.args) > 1, "-selftest takes at most one directory")
+	check(o.bench && len(o.args) > 1, "-bench takes at most one directory")
+	check(o.cmp && len(o.args) != 2, "-cmp needs exactly two files or two directories")
+	check(o.cmpContent && !o.cmp, "-cmp-content needs -cmp")
+	check(o.cmpBytes && !o.cmp, "-cmp-bytes needs -cmp")
+	check(o.detectCollisions && !o.groupMode(), "-detect-collisions needs -dupes, -keep-under, -annotate, -tui or one of the -dupes actions")
+	check(o.detectCollisions && len(o.algos) > 0 && o.algos[0] != "sha1", "-detect-collisions checks sha1 groups, the first of -algos must be sha1")
+	check(o.paranoid && !o.groupMode(), "-paranoid needs -dupes, -keep-under, -annotate, -tui or one of the -dupes actions")
+	check(o.copy && len(o.args) != 2, "-copy needs a source file or directory and a destination")
+	check(o.copyVerify && !o.copy, "-copy-verify needs -copy")
+	check(o.copy && (modes > 0 || o.groupMode() || o.stream || o.watch || o.serve != "" || o.workers != "" ||
+		o.cache != "" || o.xattr || o.checkpoint != "" || o.archives || o.quick > 0 || o.cdc || o.filesFrom != "" ||
+		o.symlinks == "target" || o.format == "json" || o.summaryOnly || o.spillAt > 0 || o.sqlite != "" || o.db != "" ||
+		o.listen != ""),
+		"-copy copies and lists the files, it can't be used with other modes, reports or -quick, -cache and -symlinks target")
+	check(o.mergeManifests && len(o.args) == 0, "-merge-manifests needs at least one manifest or directory")
+	check(o.manifestDiff && len(o.args) != 2, "-manifest-diff needs an old and a new manifest")
+	check(o.filesFrom != "" && (modes > 0 || len(o.args) > 0), "-files-from can't be used with directory arguments or other modes")
+	check(o.filesFrom != "" && o.bar, "-bar needs directories to count the files, not -files-from")
+	check(modes == 0 && len(o.args) == 0 && o.filesFrom == "" && o.serveDB == "",
+		"Arg 0 (dirpath) missing")
+	check(o.cdcMinPerc < 0 || o.cdcMinPerc > 100, "-cdc-min must be between 0 and 100")
+	check(o.chunkSize != 0 && (o.chunkSize < 4<<10 || o.chunkSize > 64<<20 || o.chunkSize&(o.chunkSize-1) != 0),
+		"-chunk-size must be a power of two from 4K to 64M")
+	check(o.chunkSize != 0 && !o.chunkDedup, "-chunk-size needs -chunk-dedup")
+	check(o.chunkDedup && (modes > 0 || o.stream || o.spillAt > 0 || o.watch || o.quick > 0 || o.prefilterAlgo != "" ||
+		o.workers != "" || o.checkpoint != "" || o.copy),
+		"-chunk-dedup reads every file in full for the totals, it can't be used with other modes, -stream, -spill-at, -watch, -quick, -prefilter-algo, -workers, -checkpoint or -copy")
+	check(o.changedOnly && o.cache == "", "-changed-only needs -cache")
+	check(o.noCache && o.cache == "" && !o.xattr, "-no-cache needs -cache or -xattr")
+	check(countTrue(o.changedOnly, o.keepUnder != "" || o.annotate != "", o.dupes) > 1,
+		"only one of -changed-only, -dupes and -keep-under or -annotate can be used")
+	check(o.changedOnly && (o.sqlite != "" || o.db != ""), "-sqlite and -db aren't written with -changed-only")
+	check(o.changedOnly && o.emptyFiles == "separate", "-empty-files separate has no effect with -changed-only")
+	check(o.preserveRootOrder && (o.changedOnly || o.groupMode()),
+		"-preserve-root-order can't be used with -changed-only, -dupes, -keep-under or -annotate")
+	check(o.changedOnly && o.cdc, "-cdc has no effect with -changed-only")
+	if o.prefilterAlgo != "" {
+		_, ok := hashwalk.Algorithms[o.prefilterAlgo]
+		check(!ok, "unknown -prefilter-algo "+o.prefilterAlgo+", valid algorithms: "+hashwalk.AlgorithmNames())
+		check(!o.groupMode(), "-prefilter-algo needs -dupes, -keep-under or -annotate")
+	}
+	switch o.sort {
+	case "", "sum", "hash":
+	case "copies", "wasted":
+		check(!o.groupMode(), "-sort "+o.sort+" needs -dupes, -keep-under or -annotate")
+	case "path", "size", "mtime":
+	default:
+		bad = append(bad, "-sort must be sum, hash, path, size or mtime, or copies or wasted for duplicate groups")
+	}
+	check(o.noSort && o.sort != "", "-no-sort and -sort can't be used together")
+	check(o.fileOrder() && (o.groupMode() || o.changedOnly || o.stream || o.dupeDirs || o.report != "" || o.summaryOnly),
+		"-sort path, size and mtime and -no-sort order the file list, they can't be used with -stream, -changed-only, -summary or the duplicate reports")
+	switch o.typeUnknown {
+	case "skip", "include":
+	default:
+		bad = append(bad, "-type-unknown must be skip or include")
+	}
+	check(!o.entropy && hasField(o.fields, "entropy"), "the entropy field needs -entropy")
+	check(o.slowest < 0, "-slowest can't be negative")
+	check(o.timings && o.format != "json" && o.listen == "", "-timings needs -format json or -listen")
+	check(flagGiven("exec") && strings.TrimSpace(o.exec) == "", "-exec needs a command")
+	check(!o.sparse && hasField(o.fields, "allocated"), "the allocated field needs -sparse")
+	check(o.hashMetadata == nil && hasField(o.fields, "meta"), "the meta field needs -hash-metadata")
+	check(o.hashMetadata != nil && o.format == "coreutils", "-format coreutils has no room for the -hash-metadata sum")
+	check(o.xattr && !xattrSupported, "-xattr is only supported on Linux")
+	check(o.maxDepth < 0, "-max-depth can't be negative")
+	check(o.jobs < 0, "-j can't be negative")
+	check(o.prefetch < 0, "-prefetch can't be negative")
+	check(o.crossDirOnly && !o.groupMode(), "-cross-dir-only needs -dupes, -keep-under or -annotate")
+	switch o.annotate {
+	case "", "delete", "link":
+	default:
+		bad = append(bad, "-annotate must be delete or link")
+	}
+	switch o.format {
+	case "text":
+	case "json":
+		check(o.groupMode() || o.changedOnly || o.preserveRootOrder,
+			"-format json only applies to the plain file list, not -dupes, -keep-under, -annotate, -changed-only or -preserve-root-order")
+	case "csv", "tsv":
+		check(o.groupMode() || o.changedOnly || o.preserveRootOrder || o.summaryOnly || o.emptyFiles == "separate",
+			"-format csv and tsv only apply to the plain file list, not -dupes, -keep-under, -annotate, -changed-only, -preserve-root-order, -summary or -empty-files separate")
+	case "coreutils":
+		check(o.groupMode() || o.changedOnly || o.preserveRootOrder,
+			"-format coreutils only applies to the plain file list, not -dupes, -keep-under, -annotate, -changed-only or -preserve-root-order")
+		check(len(o.algos) > 1 && !o.tag, "-format coreutils prints one sum per line, use -tag with several -algos")
+	default:
+		bad = append(bad, "-format must be text, csv, tsv, json or coreutils")
+	}
+	check(o.tag && o.format != "coreutils", "-tag needs -format coreutils")
+	check(o.listErrors && (o.format != "text" || o.groupMode() || o.summaryOnly || o.changedOnly || o.report != "" || o.dupeDirs),
+		"-list-errors adds to the plain file list, -format json has the failed files with an error_class already")
+	switch o.sumEncoding {
+	case "hex":
+	case "multihash", "sri":
+		if err := checkEncoding(o.sumEncoding, o.algos); err != nil {
+			bad = append(bad, err.Error())
+		}
+		check(o.format == "coreutils" || o.groupMode(),
+			"-encoding "+o.sumEncoding+" only applies to the file list, -format coreutils and the duplicate groups print hex")
+	default:
+		bad = append(bad, "-encoding must be "+strings.Join(sumEncodings, ", "))
+	}
+	check(countTrue(o.linkDupes, o.reflinkDupes, o.deleteDupes) > 1,
+		"only one of -link-dupes, -reflink-dupes and -delete-dupes can be used")
+	check(o.dedupMode() && (o.dupes || o.annotate != "" || o.changedOnly),
+		"-link-dupes, -reflink-dupes and -delete-dupes can't be used with -dupes, -annotate or -changed-only")
+	check(o.dedupMode() && o.symlinks == "target",
+		"-link-dupes, -reflink-dupes and -delete-dupes can't act on -symlinks target results")
+	check(o.reflinkDupes && !reflinkSupported, "-reflink-dupes is only supported on Linux")
+	check(o.dryRun && !o.dedupMode() && !o.tui, "-dry-run needs -link-dupes, -reflink-dupes, -delete-dupes or -tui")
+	check(o.tui && (o.dedupMode() || o.dupes || o.annotate != "" || o.keepUnder != "" || o.confirm || o.watch ||
+		o.format != "text" || o.summaryOnly), "-tui can't be used with the other duplicate reports and actions, -watch or -format")
+	check(o.tui && !rawTermSupported, "-tui is only supported on Linux")
+	check(o.confirm && (o.dryRun || !o.dedupMode()),
+		"-confirm needs -link-dupes, -reflink-dupes or -delete-dupes without -dry-run")
+	check(o.deleteDupes && !o.dryRun && o.trash == "" && !o.yes && (!o.confirm || !isTerminal(os.Stdin)),
+		"-delete-dupes deletes for good without a terminal to -confirm on, give -yes or -trash")
+	check(o.yes && !o.dedupMode(), "-yes needs -link-dupes, -reflink-dupes or -delete-dupes")
+	check(o.trash != "" && !o.dedupMode() && !o.tui, "-trash needs -link-dupes, -reflink-dupes, -delete-dupes or -tui")
+	check(o.undo != "" && len(o.args) > 0, "-undo takes no arguments, only the -trash directory")
+	switch o.keep {
+	case "shortest", "oldest", "newest":
+	default:
+		bad = append(bad, "-keep must be shortest, oldest or newest")
+	}
+	check(o.quiet && (o.verbose || o.debug), "-q can't be used with -v or -vv")
+	check(o.summaryOnly && (o.groupMode() || o.changedOnly || o.treeDigest || o.preserveRootOrder ||
+		o.format == "coreutils" || o.emptyFiles == "separate" || o.cdc),
+		"-summary only prints the totals, it can't be used with other reports")
+	check(o.top < 0, "-top can't be negative")
+	check(o.top > 0 && !o.groupMode(), "-top needs -dupes, -keep-under, -annotate or one of the -dupes actions")
+	check(o.quick < 0, "-quick can't be negative")
+	check(o.quick > 0 && !o.groupMode(), "-quick needs -dupes, -keep-under or -annotate")
+	check(o.quick > 0 && (o.prefilterAlgo != "" || o.cdc || o.sqlite != "" || o.db != "" || o.cache != ""),
+		"-quick can't be used with -prefilter-algo, -cdc, -sqlite, -db or -cache, which need every file's full sum")
+	check(o.shardOutput < 0 || o.shardOutput > 1<<16, "-shard-output must be between 1 and 65536")
+	check(o.shardOutput > 0 && o.output == "", "-shard-output needs -output for the names of the shards")
+	check(o.shardOutput > 0 && (o.groupMode() || o.dupeDirs || o.report != "" || o.summaryOnly || o.format == "json" ||
+		o.treeDigest || o.preserveRootOrder || o.emptyFiles == "separate" || o.emptyDirs || o.caseCollisions || o.cdc ||
+		o.stream || o.watch || o.changedOnly || o.serve != "" || o.sign != ""),
+		"-shard-output only splits the sorted file list, it can't be used with -stream, -sign or other reports")
+	check(o.output != "" && modes > 0, "-output only applies to hashing, not -check, -cmp, -diff and other modes")
+	check(o.maxSize > 0 && o.minSize > o.maxSize, "-min-size is larger than -max-size")
+	check(!o.newerThan.IsZero() && !o.olderThan.IsZero() && !o.newerThan.Before(o.olderThan),
+		"-newer-than is not before -older-than, no file can match")
+	check(o.print0 && (o.format == "json" || o.format == "csv" || o.format == "tsv"),
+		"-print0 doesn't apply to -format json, csv or tsv")
+	check(o.treeDigest && (o.groupMode() || o.changedOnly || o.stream || o.preserveRootOrder || o.format != "text"),
+		"-tree-digest only applies to the plain text file list")
+	check(o.dupeDirs && (o.groupMode() || o.changedOnly || o.stream || o.watch || o.preserveRootOrder ||
+		o.treeDigest || o.summaryOnly || o.format != "text" || o.quick > 0 || o.pieceSize > 0),
+		"-dupe-dirs is a report of its own, it can't be used with the other reports, -format, -watch or -quick")
+	check(o.emptyDirs && (o.format != "text" || o.stream || o.watch || o.filesFrom != "" || o.summaryOnly || modes > 0),
+		"-empty-dirs only applies to walking and the text reports, not -format, -stream, -watch, -files-from or -summary")
+	check(o.ads && !adsSupported, "-ads is only supported on Windows")
+	check(o.ads && (o.dedupMode() || o.tui || o.xattr),
+		"-ads streams can't be linked, deleted or given extended attributes, it can't be used with the -dupes actions, -tui or -xattr")
+	check(o.caseCollisions && (o.foldCase || o.format != "text" || o.stream || o.watch || o.summaryOnly || modes > 0),
+		"-case-collisions only applies to the text reports, not -case-insensitive-fs, -format, -stream, -watch or -summary")
+	check(o.perRoot && o.commonBase, "only one of -per-root and -common-base can be used")
+	check(o.absolute && o.relativeTo != "", "only one of -absolute and -relative-to can be used")
+	check((o.absolute || o.relativeTo != "") && (o.perRoot || o.commonBase),
+		"-absolute and -relative-to set the printed paths, -per-root and -common-base can't be used with them")
+	check((o.absolute || o.relativeTo != "") && (modes > 0 || o.filesFrom != ""),
+		"-absolute and -relative-to only apply to hashing directory arguments")
+	switch o.symlinks {
+	case "skip", "follow", "target":
+	default:
+		bad = append(bad, "-symlinks must be skip, follow or target")
+	}
+	check(o.stream && (o.groupMode() || o.cache != "" || o.sqlite != "" || o.db != "" || o.cdc ||
+		o.preserveRootOrder || o.format == "json" && !o.summaryOnly || o.emptyFiles == "separate" || o.casSkip != "" ||
+		o.knownHashes != ""),
+		"-stream only prints the plain file list, it can't be used with options that need all results")
+	check(o.spillAt < 0, "-spill-at can't be negative")
+	check(o.spillAt > 0 && (o.stream || !o.spillable()),
+		"-spill-at only sorts the plain file list, it can't be used with -stream or options that need all results")
+	check(o.archives && (o.prefilterAlgo != "" || o.quick > 0 || o.dedupMode()),
+		"-archives can't be used with -prefilter-algo, -quick, -link-dupes, -reflink-dupes or -delete-dupes, archive members can't be reopened, linked or deleted")
+	check(o.fileTimeout < 0, "-file-timeout can't be negative")
+	check(o.queueDepth < 1 || o.resultQueue < 0, "-queue-depth must be at least 1 and -result-queue can't be negative")
+	check(o.autoJobs && (o.workers != "" || o.filesFrom != "" || o.serve != ""),
+		"-auto-jobs probes the roots, it can't be used with -workers, -serve or -files-from")
+	check(o.retries < 0 || o.retryDelay < 0, "-retries and -retry-delay can't be negative")
+	check(o.timeout < 0, "-timeout can't be negative")
+	check(o.bufferSize > 1<<30, "-buffer-size can't be larger than 1G")
+	check(o.noCachePollution && o.prefetch > 0, "-prefetch fills the page cache, it can't be used with -no-cache-pollution")
+	check(o.limitRate > 0 && o.prefetch > 0, "-prefetch reads ahead without -limit-rate, only one of them can be used")
+	check(o.watch && (modes > 0 || o.groupMode() || o.stream || o.changedOnly || o.noCache ||
+		o.format != "text" || o.output != "" || o.filesFrom != "" || o.preserveRootOrder || o.treeDigest ||
+		o.quick > 0 || o.prefilterAlgo != "" || o.cdc || o.entropy || o.sqlite != "" || o.summaryJSON != "" ||
+		o.emptyFiles == "separate" || o.listen != "" || o.casSkip != "" || o.knownHashes != ""),
+		"-watch only prints the changed files, it can't be used with other modes or reports")
+	check(o.watch && len(o.algos) > 1, "-watch only keeps the first of -algos between passes, give only one")
+	check(o.watch && o.watchInterval <= 0, "-watch-interval must be positive")
+	check(o.sign != "" && o.output == "", "-sign needs -output, the file to sign")
+	check(o.verifyKey != "" && !o.check, "-verify-key needs -check")
+	check(o.pieceSize > 0 && (o.format != "text" && o.format != "json" || o.groupMode() || o.changedOnly ||
+		o.summaryOnly || o.treeDigest),
+		"-piece-size only applies to the plain file list in text or json")
+	check(o.pieceSize > 0 && (o.cache != "" || o.xattr || o.quick > 0 || o.mmapMin > 0 || o.watch),
+		"-piece-size needs every file read in full, it can't be used with -cache, -xattr, -quick, -mmap or -watch")
+	check(o.s3ETag && (len(o.algos) != 1 || o.algos[0] != "md5"), "-s3-etag needs -algos md5, S3 ETags are MD5 sums")
+	check(o.serveDB != "" && o.db == "", "-serve-db needs the -db database to serve")
+	check(o.serveDB != "" && (modes > 0 || o.watch || o.serve != "" || o.workers != "" || o.copy || o.filesFrom != ""),
+		"-serve-db can't be used with other modes, -serve, -workers or -files-from")
+	check(o.serveDB != "" && len(o.args) > 0 && sharedToken() == "",
+		"-serve-db rescans need the shared secret in "+tokenEnv+", which POST /rescan must send")
+	check(o.serve != "" && (modes > 0 || o.watch || o.workers != "" || len(o.args) == 0),
+		"-serve needs the roots to serve and can't be used with other modes or -workers")
+	check(o.serve != "" && listenNetwork(o.serve) == "tcp" && sharedToken() == "",
+		"-serve on a TCP address needs the shared secret in "+tokenEnv+", which the -workers coordinators send")
+	check(o.workers != "" && (o.cdc || o.entropy || o.pieceSize > 0 || o.quick > 0 || o.archives || o.mmapMin > 0 ||
+		o.prefetch > 0 || o.noCachePollution || o.limitRate > 0 || o.syncFirst),
+		"-workers agents only return sums, they can't be used with -cdc, -entropy, -piece-size, -quick, -archives or the local read options")
+	check(o.resume && o.checkpoint == "", "-resume needs -checkpoint, the journal of the interrupted run")
+	check(o.checkpoint != "" && (modes > 0 || o.serve != "" || o.watch || o.cdc || o.entropy || o.pieceSize > 0 ||
+		o.quick > 0 || o.archives),
+		"-checkpoint only keeps full sums while hashing, it can't be used with other modes, -serve, -watch, -cdc, -entropy, -piece-size, -quick or -archives")
+	switch o.report {
+	case "", "usage":
+	default:
+		bad = append(bad, "-report must be usage")
+	}
+	check(o.report != "" && (o.groupMode() || o.dupeDirs || o.changedOnly || o.stream || o.watch || o.preserveRootOrder ||
+		o.treeDigest || o.summaryOnly || o.format != "text" || o.quick > 0 || o.pieceSize > 0),
+		"-report is a report of its own, it can't be used with the other reports, -format, -watch or -quick")
+	switch o.normalize {
+	case "", "nfc", "nfd":
+	default:
+		bad = append(bad, "-normalize must be nfc or nfd")
+	}
+	switch o.knownShow {
+	case "known", "unknown":
+	default:
+		bad = append(bad, "-known-show must be known or unknown")
+	}
+	check(o.knownHashes != "" && (o.quick > 0 || o.changedOnly),
+		"-known-hashes needs full sums and can't be used with -quick or -changed-only")
+	switch o.emptyFiles {
+	case "group", "skip", "separate":
+	default:
+		bad = append(bad, "-empty-files must be group, skip or separate")
+	}
+	if len(bad) > 0 {
+		return errors.New("invalid options:\n  " + strings.Join(bad, "\n  "))
+	}
+	return nil
+}
@@ -0,0 +1,32 @@
+package main
+
+import "sort"
+
+// outputOrder returns rs, sorted by sum and then path, in the -sort order of
+// the file list, or with -no-sort in the order the results came in. Paths
+// are compared byte by byte with / separators rather than by the collation
+// of a locale, equal sizes and mtimes go by path and equal paths by sum, so
+// the same files are listed the same way on every platform and diffs
+// between runs stay small.
+func outputOrder(rs resultSlice) resultSlice {
+	var less func(a, b *result) bool
+	switch {
+	case opts.noSort:
+		less = func(a, b *result) bool { return a.Seq < b.Seq }
+	case opts.sort == "path":
+		less = func(a, b *result) bool { return normPath(a.Path) < normPath(b.Path) }
+	case opts.sort == "size":
+		less = func(a, b *result) bool {
+			return a.Size < b.Size || a.Size == b.Size && normPath(a.Path) < normPath(b.Path)
+		}
+	case opts.sort == "mtime":
+		less = func(a, b *result) bool {
+			return a.ModTime.Before(b.ModTime) || a.ModTime.Equal(b.ModTime) && normPath(a.Path) < normPath(b.Path)
+		}
+	default:
+		return rs
+	}
+	out := append(resultSlice(nil), rs...)
+	sort.SliceStable(out, func(i, j int) bool { return less(&out[i], &out[j]) })
+	return out
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+)
+
+// atomicOutput is the -output file. The results are written to a temporary
+// file next to it, which only replaces it once the run succeeded, so a
+// failed or interrupted run never leaves a truncated manifest behind.
+type atomicOutput struct {
+	f    *os.File
+	path string
+}
+
+func createOutput(path string) (*atomicOutput, error) {
+	tmp := filepath.Join(filepath.Dir(path), "."+filepath.Base(path)+".tmp")
+	f, err := os.OpenFile(tmp, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0666)
+	if err != nil {
+		return nil, err
+	}
+	return &atomicOutput{f, path}, nil
+}
+
+// commit syncs the temporary file and renames it into place.
+func (o *atomicOutput) commit() error {
+	err := o.f.Sync()
+	if cerr := o.f.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(o.f.Name(), o.path)
+	}
+	if err != nil {
+		os.Remove(o.f.Name())
+	}
+	return err
+}
+
+// abort removes the temporary file, leaving any previous output as it was.
+func (o *atomicOutput) abort() {
+	o.f.Close()
+	os.Remove(o.f.Name())
+}
+
+// shardedOutput is the -output file split into -shard-output files by sum
+// prefix, FILE.0 to FILE.N-1 with the numbers padded to the same width.
+// Each shard holds a contiguous range of sums, so every shard is sorted on
+// its own and the shards in order are the whole sorted manifest.
+type shardedOutput struct {
+	outs []*atomicOutput
+}
+
+// shards is the sharded output the results are printed to, nil without
+// -shard-output.
+var shards *shardedOutput
+
+func createShards(path string, n int) (*shardedOutput, error) {
+	s := &shardedOutput{}
+	width := len(strconv.Itoa(n - 1))
+	for i := 0; i < n; i++ {
+		o, err := createOutput(fmt.Sprintf("%s.%0*d", path, width, i))
+		if err != nil {
+			s.abort()
+			return nil, err
+		}
+		s.outs = append(s.outs, o)
+	}
+	return s, nil
+}
+
+// shard returns the output for sum, by its first two bytes.
+func (s *shardedOutput) shard(sum []byte) *os.File {
+	var prefix int
+	if len(sum) >= 2 {
+		prefix = int(sum[0])<<8 | int(sum[1])
+	} else if len(sum) == 1 {
+		prefix = int(sum[0]) << 8
+	}
+	return s.outs[prefix*len(s.outs)>>16].f
+}
+
+// commit commits all shards. They are renamed one after the other, a
+// failure can leave some of them replaced.
+func (s *shardedOutput) commit() error {
+	for i, o := range s.outs {
+		if err := o.commit(); err != nil {
+			for _, o := range s.outs[i+1:] {
+				o.abort()
+			}
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *shardedOutput) abort() {
+	for _, o := range s.outs {
+		o.abort()
+	}
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"github.com/rajder/gosha1/hashwalk"
+	"hash"
+	"os"
+)
+
+// pieceHasher is an io.Writer hashing everything written to it in pieces of
+// a fixed size, like BitTorrent, for -piece-size. A sum that doesn't match
+// only says a file changed, the pieces say where.
+type pieceHasher struct {
+	size   int64
+	h      hash.Hash
+	n      int64 // Bytes in the current piece.
+	pieces [][]byte
+}
+
+func newPieceHasher(algo string, size int64) *pieceHasher {
+	return &pieceHasher{size: size, h: hashwalk.NewHashes([]string{algo})[0]}
+}
+
+func (p *pieceHasher) Write(b []byte) (int, error) {
+	written := len(b)
+	for len(b) > 0 {
+		n := int64(len(b))
+		if rest := p.size - p.n; n > rest {
+			n = rest
+		}
+		p.h.Write(b[:n])
+		p.n += n
+		b = b[n:]
+		if p.n == p.size {
+			p.pieces = append(p.pieces, p.h.Sum(nil))
+			p.h.Reset()
+			p.n = 0
+		}
+	}
+	return written, nil
+}
+
+// Pieces returns the sum of each piece, the last one may be shorter. An
+// empty file has no pieces.
+func (p *pieceHasher) Pieces() [][]byte {
+	if p.n > 0 {
+		p.pieces = append(p.pieces, p.h.Sum(nil))
+		p.h.Reset()
+		p.n = 0
+	}
+	return p.pieces
+}
+
+// printPieces prints the pieces of r below its line, as comments so the
+// manifest still works with sha1sum -c.
+func printPieces(r *result) {
+	for i, p := range r.Pieces {
+		printLine("# piece: %d %x", i, p)
+	}
+}
+
+// hashPieces rehashes the pieces of the file at path, for -check to find
+// which of them changed.
+func hashPieces(ctx context.Context, path, algo string, size int64) ([][]byte, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	p := newPieceHasher(algo, size)
+	if _, _, err := hashwalk.SumReaderContext(ctx, limitRead(ctx, f), []string{algo}, p); err != nil {
+		return nil, err
+	}
+	return p.Pieces(), nil
+}
+
+// badPieces prints a "piece" line with the byte range of each piece of the
+// manifest entry e that differs from the file at path, now size bytes, or
+// is missing from it, and returns how many there are.
+func badPieces(ctx context.Context, e *manifestEntry, path, algo string, size int64) (int, error) {
+	got, err := hashPieces(ctx, path, algo, e.PieceSize)
+	if err != nil {
+		return 0, err
+	}
+	bad := 0
+	n := len(e.Pieces)
+	if len(got) > n {
+		n = len(got)
+	}
+	for i := 0; i < n; i++ {
+		if i < len(e.Pieces) && i < len(got) && bytes.Equal(e.Pieces[i], got[i]) {
+			continue
+		}
+		start, end := int64(i)*e.PieceSize, int64(i+1)*e.PieceSize-1
+		if i >= len(e.Pieces)-1 && end >= size && size > start {
+			// The last piece is shorter, or beyond the old end of the file.
+			end = size - 1
+		}
+		printLine("piece\t%d-%d\t%s", start, end, e.Path)
+		bad++
+	}
+	return bad, nil
+}
@@ -0,0 +1,25 @@
+package main
+
+import "context"
+
+// prefetchJobs passes the jobs from in on to the returned channel, starting
+// to read each file into the page cache up to n files before a worker picks
+// it up, so I/O latency overlaps with hashing. The returned channel closes
+// when in does or ctx is done.
+func prefetchJobs(ctx context.Context, in <-chan job, n int) <-chan job {
+	out := make(chan job, n)
+	go func() {
+		defer close(out)
+		for j := range in {
+			prefetch(j.Path)
+			select {
+			case out <- j:
+			case <-ctx.Done():
+				for range in {
+				}
+				return
+			}
+		}
+	}()
+	return out
+}
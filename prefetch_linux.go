@@ -0,0 +1,32 @@
+//go:build linux && (amd64 || arm64)
+// +build linux
+// +build amd64 arm64
+
+package main
+
+import (
+	"os"
+	"syscall"
+)
+
+const (
+	fadvWillNeed = 3 // POSIX_FADV_WILLNEED
+	fadvDontNeed = 4 // POSIX_FADV_DONTNEED
+)
+
+// prefetch asks the kernel to start reading the whole file at path in the
+// background. Errors are ignored, the worker reports them when hashing.
+func prefetch(path string) {
+	f, err := os.Open(path)
+	if err != nil {
+		return
+	}
+	syscall.Syscall6(syscall.SYS_FADVISE64, f.Fd(), 0, 0, fadvWillNeed, 0, 0)
+	f.Close()
+}
+
+// dropCache tells the kernel the n bytes of f at off won't be needed again,
+// so their clean pages are dropped from the page cache.
+func dropCache(f *os.File, off, n int64) {
+	syscall.Syscall6(syscall.SYS_FADVISE64, f.Fd(), uintptr(off), uintptr(n), fadvDontNeed, 0, 0)
+}
@@ -0,0 +1,24 @@
+//go:build !linux || !(amd64 || arm64)
+// +build !linux !amd64,!arm64
+
+package main
+
+import (
+	"io"
+	"os"
+)
+
+// prefetch primes the page cache by reading the file at path, there is no
+// read-ahead hint to give on this platform. Errors are ignored, the worker
+// reports them when hashing.
+func prefetch(path string) {
+	f, err := os.Open(path)
+	if err != nil {
+		return
+	}
+	io.Copy(io.Discard, f)
+	f.Close()
+}
+
+// dropCache does nothing, there is no hint to give on this platform.
+func dropCache(f *os.File, off, n int64) {}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"context"
+	"github.com/rajder/gosha1/hashwalk"
+	"os"
+	"sort"
+)
+
+// filtered counts the files the size filter, -prefilter-algo and -quick
+// left out as unique, and their bytes, which the totals still count so they
+// don't depend on the filters that ran.
+var filtered struct {
+	files int
+	bytes int64
+}
+
+// addFiltered counts the files of all that aren't in kept as filtered,
+// leaving out hard link aliases like summarize.
+func addFiltered(all, kept resultSlice) {
+	for _, r := range withoutAliases(all) {
+		filtered.files++
+		filtered.bytes += r.Size
+	}
+	for _, r := range withoutAliases(kept) {
+		filtered.files--
+		filtered.bytes -= r.Size
+	}
+}
+
+// prefilterCandidates returns the files sharing their -prefilter-algo sum
+// with another file. Only these can be duplicates, the rest are left out.
+func prefilterCandidates(pre resultSlice) resultSlice {
+	sort.Sort(pre)
+	var candidates resultSlice
+	for _, g := range duplicateGroups(pre) {
+		candidates = append(candidates, g...)
+	}
+	addFiltered(pre, candidates)
+	log("Prefiltered  :", len(pre)-len(candidates), "unique by", opts.prefilterAlgo+",",
+		len(candidates), "to confirm")
+	return candidates
+}
+
+// candidateJobs returns a job producer hashing the files in rs again.
+func candidateJobs(rs resultSlice) jobProducer {
+	return func(ctx context.Context, jobs chan<- job) error {
+		for _, r := range rs {
+			fi, err := os.Stat(r.Path)
+			if err != nil {
+				return err
+			}
+			select {
+			case jobs <- job{File: hashwalk.File{Path: r.Path, Info: fi, Root: r.Root, Alias: r.Alias}}:
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		}
+		return nil
+	}
+}
+
+// sizeCandidates runs walk without hashing and returns the files whose size
+// another file shares. A file with a unique size can't have a duplicate.
+func sizeCandidates(ctx context.Context, walk jobProducer) ([]job, error) {
+	jobs := make(chan job)
+	done := make(chan struct{})
+	var all []job
+	go func() {
+		for j := range jobs {
+			all = append(all, j)
+		}
+		close(done)
+	}()
+	err := walk(ctx, jobs)
+	close(jobs)
+	<-done
+	if err != nil {
+		return nil, err
+	}
+	sizes := make(map[int64]int)
+	for _, j := range all {
+		sizes[j.size()]++
+	}
+	var candidates []job
+	for _, j := range all {
+		if j.Err != nil || sizes[j.size()] > 1 {
+			candidates = append(candidates, j)
+		} else if j.Alias == "" {
+			filtered.files++
+			filtered.bytes += j.size()
+		}
+	}
+	log("Size filter  :", len(all)-len(candidates), "unique by size,", len(candidates), "to hash")
+	return candidates, nil
+}
+
+// jobList returns a job producer sending the jobs in list.
+func jobList(list []job) jobProducer {
+	return func(ctx context.Context, jobs chan<- job) error {
+		for _, j := range list {
+			select {
+			case jobs <- j:
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		}
+		return nil
+	}
+}
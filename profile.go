@@ -0,0 +1,68 @@
+package main
+
+import (
+	"net"
+	"net/http"
+	"net/http/pprof"
+	"os"
+	"runtime"
+	rpprof "runtime/pprof"
+)
+
+var cpuProfile *os.File
+
+// startProfiles starts -cpuprofile and serves the net/http/pprof handlers on
+// -pprof-addr, e.g. for go tool pprof http://host:6060/debug/pprof/profile.
+func startProfiles() error {
+	if opts.pprofAddr != "" {
+		ln, err := net.Listen(listenNetwork(opts.pprofAddr), opts.pprofAddr)
+		if err != nil {
+			return err
+		}
+		mux := http.NewServeMux()
+		mux.HandleFunc("/debug/pprof/", pprof.Index)
+		mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+		mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+		mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+		mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+		go http.Serve(ln, mux)
+	}
+	if opts.cpuProfile != "" {
+		f, err := os.Create(opts.cpuProfile)
+		if err != nil {
+			return err
+		}
+		if err := rpprof.StartCPUProfile(f); err != nil {
+			f.Close()
+			return err
+		}
+		cpuProfile = f
+	}
+	return nil
+}
+
+// stopProfiles finishes -cpuprofile and writes -memprofile, once.
+func stopProfiles() {
+	if cpuProfile != nil {
+		rpprof.StopCPUProfile()
+		if err := cpuProfile.Close(); err != nil {
+			logWarning(err)
+		}
+		cpuProfile = nil
+	}
+	if opts.memProfile != "" {
+		path := opts.memProfile
+		opts.memProfile = ""
+		f, err := os.Create(path)
+		if err != nil {
+			logWarning(err)
+			return
+		}
+		defer f.Close()
+		// Up to date statistics of what is still in use.
+		runtime.GC()
+		if err := rpprof.WriteHeapProfile(f); err != nil {
+			logWarning(err)
+		}
+	}
+}
@@ -0,0 +1,162 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+	"sync/atomic"
+	"time"
+	"unicode/utf8"
+)
+
+// prescan counts the files and bytes below the roots concurrently with the
+// hashing, giving the progress bar its total.
+type prescan struct {
+	files int64
+	bytes int64
+	done  int32
+}
+
+func startPrescan(roots []string) *prescan {
+	p := &prescan{}
+	jobs := make(chan job)
+	go func() {
+		walkRoots(roots, nil)(context.Background(), jobs)
+		close(jobs)
+	}()
+	go func() {
+		for j := range jobs {
+			atomic.AddInt64(&p.files, 1)
+			atomic.AddInt64(&p.bytes, j.size())
+		}
+		atomic.StoreInt32(&p.done, 1)
+	}()
+	return p
+}
+
+func (p *prescan) totals() (files, bytes int64, done bool) {
+	files = atomic.LoadInt64(&p.files)
+	bytes = atomic.LoadInt64(&p.bytes)
+	done = atomic.LoadInt32(&p.done) == 1
+	return
+}
+
+func isTerminal(f *os.File) bool {
+	fi, err := f.Stat()
+	return err == nil && fi.Mode()&os.ModeCharDevice != 0
+}
+
+// progressBar renders a single, rewritten status line on stderr.
+type progressBar struct {
+	pre   *prescan
+	start time.Time
+	last  time.Time
+}
+
+func newProgressBar(roots []string) *progressBar {
+	return &progressBar{pre: startPrescan(roots), start: time.Now()}
+}
+
+// prescanResults returns the totals of hashing the files in rs again.
+func prescanResults(rs resultSlice) *prescan {
+	p := &prescan{files: int64(len(rs)), done: 1}
+	for _, r := range rs {
+		p.bytes += r.Size
+	}
+	return p
+}
+
+// prescanJobs returns the totals of hashing the files in list.
+func prescanJobs(list []job) *prescan {
+	p := &prescan{files: int64(len(list)), done: 1}
+	for _, j := range list {
+		p.bytes += j.size()
+	}
+	return p
+}
+
+// newProgressBarTotal makes a bar for hashing the files in rs.
+func newProgressBarTotal(rs resultSlice) *progressBar {
+	return &progressBar{pre: prescanResults(rs), start: time.Now()}
+}
+
+// newProgressBarJobs returns a bar for hashing the files in list.
+func newProgressBarJobs(list []job) *progressBar {
+	return &progressBar{pre: prescanJobs(list), start: time.Now()}
+}
+
+// update redraws the bar at most a few times per second, current is the
+// file last hashed.
+func (b *progressBar) update(files int, bytes int64, current string) {
+	now := time.Now()
+	if now.Sub(b.last) < 200*time.Millisecond {
+		return
+	}
+	b.last = now
+	width := terminalWidth(stderr)
+	if width <= 0 {
+		width = 80
+	}
+	fmt.Fprint(stderr, "\r", b.render(width, files, bytes, current, now))
+}
+
+func (b *progressBar) finish() {
+	fmt.Fprint(stderr, "\r\x1b[K")
+}
+
+func (b *progressBar) render(width, files int, bytes int64, current string, now time.Time) string {
+	totFiles, totBytes, done := b.pre.totals()
+	frac := 0.0
+	if totBytes > 0 {
+		frac = float64(bytes) / float64(totBytes)
+	}
+	if frac > 1 {
+		frac = 1
+	}
+	elapsed := now.Sub(b.start).Seconds()
+	rate := 0.0
+	if elapsed > 0 {
+		rate = float64(bytes) / elapsed
+	}
+	eta := "--"
+	if done && rate > 0 {
+		left := time.Duration(float64(totBytes-bytes) / rate * float64(time.Second))
+		eta = left.Round(time.Second).String()
+	}
+	approx := "~"
+	if done {
+		approx = ""
+	}
+	info := fmt.Sprintf(" %s%5.1f%% %d/%s%d files %.2f MB/s ETA %s",
+		approx, 100*frac, files, approx, totFiles, rate/1024/1024, eta)
+	room := width - len(info) - 3
+	barLen := room
+	if current != "" {
+		barLen = room / 2
+	}
+	if barLen < 10 {
+		return info
+	}
+	n := int(frac * float64(barLen))
+	line := "[" + strings.Repeat("#", n) + strings.Repeat("-", barLen-n) + "]" + info
+	if current != "" {
+		line += " " + shortenLeft(current, room-barLen)
+	}
+	return line + "\x1b[K"
+}
+
+// shortenLeft cuts s to at most n bytes, replacing the start by "...".
+func shortenLeft(s string, n int) string {
+	if len(s) <= n {
+		return s
+	}
+	if n <= 3 {
+		return ""
+	}
+	i := len(s) - (n - 3)
+	for i < len(s) && !utf8.RuneStart(s[i]) {
+		i++
+	}
+	return "..." + s[i:]
+}
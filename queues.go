@@ -0,0 +1,91 @@
+package main
+
+import (
+	"fmt"
+	"github.com/rajder/gosha1/hashwalk"
+	rtmetrics "runtime/metrics"
+	"time"
+)
+
+// jobQueueDepth is the size of the queue of files walked ahead of the
+// workers, -queue-depth or jobQueue.
+func jobQueueDepth() int {
+	if opts.queueDepth > 0 {
+		return opts.queueDepth
+	}
+	return jobQueue
+}
+
+// queueStats samples how full the scan's queues are on each tick of the
+// status line, whether or not results arrived, and the CPU time used, to
+// tell in the status line what the run is waiting for.
+type queueStats struct {
+	s               *hashwalk.Scan[result]
+	jobs, results   float64 // Sums of the fill fractions.
+	samples         int
+	cpu             float64 // CPU seconds at the start of the interval.
+	start           time.Time
+	sample          []rtmetrics.Sample
+	jobsCap, resCap int
+}
+
+func newQueueStats(s *hashwalk.Scan[result]) *queueStats {
+	q := &queueStats{s: s, sample: []rtmetrics.Sample{{Name: "/cpu/classes/user:cpu-seconds"}}}
+	_, q.jobsCap = s.Queued()
+	q.resCap = cap(s.Results)
+	q.reset()
+	return q
+}
+
+func (q *queueStats) cpuSeconds() float64 {
+	rtmetrics.Read(q.sample)
+	if q.sample[0].Value.Kind() != rtmetrics.KindFloat64 {
+		return 0
+	}
+	return q.sample[0].Value.Float64()
+}
+
+func (q *queueStats) reset() {
+	q.jobs, q.results, q.samples = 0, 0, 0
+	q.cpu = q.cpuSeconds()
+	q.start = time.Now()
+}
+
+func (q *queueStats) add() {
+	if q.jobsCap > 0 {
+		n, _ := q.s.Queued()
+		q.jobs += float64(n) / float64(q.jobsCap)
+	}
+	if q.resCap > 0 {
+		q.results += float64(len(q.s.Results)) / float64(q.resCap)
+	}
+	q.samples++
+}
+
+// String gives the average fill of the walk and result queues since the
+// last reset, the CPU use as a share of the cores the workers can use, and
+// what bounds the run: the walk when the workers find the queue empty while
+// it is still walking, the CPU when they use their cores, the output when
+// results wait to be printed, else reading.
+func (q *queueStats) String() string {
+	n := float64(q.samples)
+	if n == 0 {
+		n = 1
+	}
+	jobs, results := q.jobs/n, q.results/n
+	cores := workerCount()
+	if cores > numCPU {
+		cores = numCPU
+	}
+	cpu := (q.cpuSeconds() - q.cpu) / time.Since(q.start).Seconds() / float64(cores)
+	bound := "read"
+	switch {
+	case q.jobsCap > 0 && jobs < 0.1 && !q.s.Produced():
+		bound = "walk"
+	case cpu >= 0.8:
+		bound = "cpu"
+	case q.resCap > 0 && results > 0.9:
+		bound = "output"
+	}
+	return fmt.Sprintf("queue: %.0f%%\tresults: %.0f%%\tcpu: %.0f%%\t%s bound", 100*jobs, 100*results, 100*cpu, bound)
+}
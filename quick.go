@@ -0,0 +1,62 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"github.com/rajder/gosha1/hashwalk"
+	"io"
+	"os"
+	"sort"
+)
+
+// quickSums hashes the size and the first and last n bytes of files larger
+// than 2n, for -quick. Smaller files are hashed whole, as calcSums does.
+// The tee only sees the bytes of whole files.
+func quickSums(ctx context.Context, path string, algos []string, n int64, tee io.Writer) ([][]byte, int64, error) {
+	f, err := os.Open(hashwalk.LongPath(path))
+	if err != nil {
+		return nil, 0, err
+	}
+	defer f.Close()
+	if opts.syncFirst {
+		if err := f.Sync(); err != nil {
+			return nil, 0, err
+		}
+	}
+	fi, err := f.Stat()
+	if err != nil {
+		return nil, 0, err
+	}
+	if fi.Size() <= 2*n {
+		return sumFile(ctx, f, algos, tee)
+	}
+	// Holes in the head and tail read as zeros without touching the disk,
+	// -sparse has nothing to skip there.
+	var hdr [8]byte
+	binary.BigEndian.PutUint64(hdr[:], uint64(fi.Size()))
+	r := io.MultiReader(bytes.NewReader(hdr[:]), io.LimitReader(f, n),
+		io.NewSectionReader(f, fi.Size()-n, n))
+	sums, _, err := hashwalk.SumReaderContext(ctx, limitRead(ctx, r), algos, nil)
+	return sums, fi.Size(), err
+}
+
+// quickCandidates splits the quick pass results sharing a sum with another
+// file into the ones already hashed whole and the partially hashed ones
+// that still need a full hash. The others can't have a duplicate.
+func quickCandidates(pre resultSlice) (whole, partial resultSlice) {
+	sort.Sort(pre)
+	for _, g := range duplicateGroups(pre) {
+		for _, r := range g {
+			if r.Quick {
+				partial = append(partial, r)
+			} else {
+				whole = append(whole, r)
+			}
+		}
+	}
+	addFiltered(pre, append(append(resultSlice(nil), whole...), partial...))
+	log("Quick filter :", len(pre)-len(whole)-len(partial), "unique by size, head and tail,",
+		len(partial), "to hash in full")
+	return whole, partial
+}
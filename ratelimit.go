@@ -0,0 +1,84 @@
+package main
+
+import (
+	"context"
+	"io"
+	"sync"
+	"time"
+)
+
+// rateLimiter is a token bucket shared by all the workers, for -limit-rate.
+// Reads take tokens as they go and wait once the bucket is in debt, so the
+// aggregate read rate stays at rate bytes per second.
+type rateLimiter struct {
+	rate  float64 // Bytes per second.
+	burst float64
+
+	mu     sync.Mutex
+	tokens float64
+	last   time.Time
+}
+
+// readLimiter is nil without -limit-rate.
+var readLimiter *rateLimiter
+
+// newRateLimiter allows bursts of a tenth of a second, but at least one
+// 64K read.
+func newRateLimiter(rate int64) *rateLimiter {
+	burst := float64(rate) / 10
+	if burst < 64*1024 {
+		burst = 64 * 1024
+	}
+	return &rateLimiter{rate: float64(rate), burst: burst, tokens: burst, last: time.Now()}
+}
+
+// take takes n tokens and waits until the bucket is out of debt.
+func (l *rateLimiter) take(ctx context.Context, n int) error {
+	l.mu.Lock()
+	now := time.Now()
+	l.tokens += now.Sub(l.last).Seconds() * l.rate
+	if l.tokens > l.burst {
+		l.tokens = l.burst
+	}
+	l.last = now
+	l.tokens -= float64(n)
+	wait := time.Duration(-l.tokens / l.rate * float64(time.Second))
+	l.mu.Unlock()
+	if wait <= 0 {
+		return nil
+	}
+	t := time.NewTimer(wait)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+type limitedReader struct {
+	ctx context.Context
+	r   io.Reader
+}
+
+func (lr *limitedReader) Read(p []byte) (int, error) {
+	if max := int(readLimiter.burst); len(p) > max {
+		p = p[:max]
+	}
+	n, err := lr.r.Read(p)
+	if n > 0 {
+		if werr := readLimiter.take(lr.ctx, n); werr != nil && err == nil {
+			err = werr
+		}
+	}
+	return n, err
+}
+
+// limitRead returns r throttled by -limit-rate, or r itself without it.
+func limitRead(ctx context.Context, r io.Reader) io.Reader {
+	if readLimiter == nil {
+		return r
+	}
+	return &limitedReader{ctx, r}
+}
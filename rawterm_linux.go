@@ -0,0 +1,38 @@
+//go:build linux
+// +build linux
+
+package main
+
+import (
+	"os"
+	"syscall"
+	"unsafe"
+)
+
+const rawTermSupported = true
+
+// makeRaw switches the terminal f to unbuffered input without echo, so -tui
+// gets each key as it is pressed. The returned function restores it.
+func makeRaw(f *os.File) (func(), error) {
+	var old syscall.Termios
+	if err := termios(f, syscall.TCGETS, &old); err != nil {
+		return nil, err
+	}
+	raw := old
+	raw.Iflag &^= syscall.ICRNL | syscall.IXON
+	raw.Lflag &^= syscall.ICANON | syscall.ECHO | syscall.ISIG | syscall.IEXTEN
+	raw.Cc[syscall.VMIN] = 1
+	raw.Cc[syscall.VTIME] = 0
+	if err := termios(f, syscall.TCSETS, &raw); err != nil {
+		return nil, err
+	}
+	return func() { termios(f, syscall.TCSETS, &old) }, nil
+}
+
+func termios(f *os.File, req uintptr, t *syscall.Termios) error {
+	_, _, errno := syscall.Syscall(syscall.SYS_IOCTL, f.Fd(), req, uintptr(unsafe.Pointer(t)))
+	if errno != 0 {
+		return errno
+	}
+	return nil
+}
@@ -0,0 +1,15 @@
+//go:build !linux
+// +build !linux
+
+package main
+
+import (
+	"errors"
+	"os"
+)
+
+const rawTermSupported = false
+
+func makeRaw(f *os.File) (func(), error) {
+	return nil, errors.New("raw terminal input isn't supported on this platform")
+}
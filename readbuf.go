@@ -0,0 +1,49 @@
+package main
+
+import (
+	"os"
+	"sync"
+)
+
+// bufPool holds the -buffer-size read buffers of the workers.
+var bufPool = sync.Pool{New: func() interface{} {
+	b := make([]byte, int(opts.bufferSize))
+	return &b
+}}
+
+// readBuffer returns a -buffer-size read buffer, or nil for the default
+// 32K, to be given back with putBuffer.
+func readBuffer() *[]byte {
+	if opts.bufferSize == 0 {
+		return nil
+	}
+	return bufPool.Get().(*[]byte)
+}
+
+func putBuffer(b *[]byte) {
+	if b != nil {
+		bufPool.Put(b)
+	}
+}
+
+// dropChunk is how much is read before -no-cache-pollution drops it from
+// the page cache.
+const dropChunk = 8 << 20
+
+// dropBehind reads f and drops what was read from the page cache every
+// dropChunk bytes, so hashing a huge tree doesn't evict everything else
+// cached on the machine.
+type dropBehind struct {
+	f          *os.File
+	done, read int64
+}
+
+func (d *dropBehind) Read(p []byte) (int, error) {
+	n, err := d.f.Read(p)
+	d.read += int64(n)
+	if d.read-d.done >= dropChunk || err != nil && d.read > d.done {
+		dropCache(d.f, d.done, d.read-d.done)
+		d.done = d.read
+	}
+	return n, err
+}
@@ -0,0 +1,15 @@
+//go:build !linux
+// +build !linux
+
+package main
+
+import (
+	"errors"
+	"os"
+)
+
+const reflinkSupported = false
+
+func cloneFile(dst, src *os.File) error {
+	return errors.New("reflinks aren't supported on this platform")
+}
@@ -0,0 +1,190 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"os/exec"
+	"sort"
+	"strings"
+)
+
+// remoteRoot is a root like sftp://user@host:22/backup or ssh://host/backup.
+// There is no SSH client in the standard library, so the tree is hashed on
+// the remote host by the coreutils sum tool of the algorithm, run through
+// the system's ssh, and only the sums come back.
+type remoteRoot struct {
+	dest string // user@host
+	port string
+	path string
+}
+
+// remoteTools are the sum tools run on the remote host.
+var remoteTools = map[string]string{
+	"sha1": "sha1sum", "sha256": "sha256sum", "sha512": "sha512sum", "md5": "md5sum", "blake2b": "b2sum",
+	"blake3": "b3sum", "xxh64": "xxh64sum",
+}
+
+// parseRemote parses s as a remote root, ok is false for local paths.
+func parseRemote(s string) (*remoteRoot, bool, error) {
+	if !strings.HasPrefix(s, "sftp://") && !strings.HasPrefix(s, "ssh://") {
+		return nil, false, nil
+	}
+	u, err := url.Parse(s)
+	if err != nil {
+		return nil, true, err
+	}
+	if u.Hostname() == "" {
+		return nil, true, fmt.Errorf("%s: no host", s)
+	}
+	rr := &remoteRoot{dest: u.Hostname(), port: u.Port(), path: u.Path}
+	if u.User != nil {
+		rr.dest = u.User.Username() + "@" + rr.dest
+	}
+	// sftp://host/~/backup is relative to the home directory, like scp.
+	if strings.HasPrefix(rr.path, "/~/") {
+		rr.path = rr.path[3:]
+	}
+	if rr.path == "" || rr.path == "/~" {
+		rr.path = "."
+	}
+	return rr, true, nil
+}
+
+// isRemote reports whether root is an sftp://, ssh:// or s3:// root.
+func isRemote(root string) bool {
+	for _, scheme := range []string{"sftp://", "ssh://", "s3://"} {
+		if strings.HasPrefix(root, scheme) {
+			return true
+		}
+	}
+	return false
+}
+
+func hasRemoteRoot(args []string) bool {
+	for _, a := range args {
+		if isRemote(a) {
+			return true
+		}
+	}
+	return false
+}
+
+// remoteTree hashes the files of the remote root with algos, only the first
+// of them for ssh. Paths are slash separated and relative to the root.
+// sized is false when the sizes aren't known.
+func remoteTree(ctx context.Context, root string, algos []string) (rs resultSlice, sized bool, err error) {
+	if strings.HasPrefix(root, "s3://") {
+		rr, err := parseS3Root(root)
+		if err != nil {
+			return nil, false, err
+		}
+		rs, err = s3Sums(ctx, rr, algos)
+		return rs, true, err
+	}
+	rr, _, err := parseRemote(root)
+	if err != nil {
+		return nil, false, err
+	}
+	rs, err = remoteSums(ctx, rr, algos[0])
+	return rs, false, err
+}
+
+// shellQuote quotes s for the remote POSIX shell.
+func shellQuote(s string) string {
+	return "'" + strings.Replace(s, "'", `'\''`, -1) + "'"
+}
+
+// remoteSums hashes the files below rr with algo and returns a result per
+// file, with the slash separated path relative to rr as Path. Sizes and
+// mtimes aren't known. -hidden, -exclude and -include apply as for local
+// trees.
+func remoteSums(ctx context.Context, rr *remoteRoot, algo string) (resultSlice, error) {
+	tool, ok := remoteTools[algo]
+	if !ok {
+		return nil, fmt.Errorf("remote roots can't be hashed with %s, only with %s", algo, remoteAlgoNames())
+	}
+	args := strings.Fields(opts.sshCommand)
+	if rr.port != "" {
+		args = append(args, "-p", rr.port)
+	}
+	script := fmt.Sprintf("cd %s && find . -type f -print0 | xargs -0 %s --", shellQuote(rr.path), tool)
+	args = append(args, "--", rr.dest, script)
+	cmd := exec.CommandContext(ctx, args[0], args[1:]...)
+	var stderrBuf bytes.Buffer
+	cmd.Stderr = &stderrBuf
+	out, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	logVerbose("Remote       :", strings.Join(args[:len(args)-1], " "), tool)
+	if err := cmd.Start(); err != nil {
+		return nil, err
+	}
+	var rs resultSlice
+	sc := bufio.NewScanner(out)
+	sc.Buffer(make([]byte, 64*1024), 1024*1024)
+	for sc.Scan() {
+		e, ok := parseCoreutilsLine(sc.Text())
+		if !ok {
+			continue
+		}
+		rel := strings.TrimPrefix(e.Path, "./")
+		if !opts.hidden && hasDotElement(rel) || !wantPath(rel, false) {
+			continue
+		}
+		rs = append(rs, result{Path: rel, Sum: e.Sum, Sums: [][]byte{e.Sum}})
+	}
+	serr := sc.Err()
+	if err := cmd.Wait(); err != nil {
+		msg := strings.TrimSpace(stderrBuf.String())
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) && exitErr.ExitCode() == 123 && len(rs) > 0 {
+			// xargs: some files couldn't be read.
+			logWarning(rr.dest+":", msg)
+		} else {
+			if msg != "" {
+				err = fmt.Errorf("%v: %s", err, msg)
+			}
+			return nil, fmt.Errorf("%s:%s: %v", rr.dest, rr.path, err)
+		}
+	}
+	return rs, serr
+}
+
+func remoteAlgoNames() string {
+	var names []string
+	for a := range remoteTools {
+		names = append(names, a)
+	}
+	sort.Strings(names)
+	return strings.Join(names, ",")
+}
+
+// processRemote prints the sums of the remote root, like processRoots does
+// for a local one. Options that act on the files or reread them don't work
+// remotely.
+func processRemote(root string) error {
+	if opts.dedupMode() || opts.cache != "" || opts.xattr || opts.cdc || opts.entropy || opts.quick > 0 ||
+		opts.pieceSize > 0 || opts.archives || opts.sqlite != "" || opts.db != "" || opts.listen != "" {
+		return errors.New("remote roots only support the reports of the sums, not options that act on or reread the files")
+	}
+	if len(opts.algos) > 1 && !strings.HasPrefix(root, "s3://") {
+		return errors.New("an ssh root is hashed by its host's sum tool, give only one of -algos")
+	}
+	ctx, cancel := runContext()
+	defer cancel()
+	rs, _, err := remoteTree(ctx, root, opts.algos)
+	if err != nil {
+		// ssh is killed when interrupted, which isn't ctx's error.
+		if serr := stopError(ctx, ctx.Err()); serr != nil {
+			return serr
+		}
+		return err
+	}
+	sort.Sort(rs)
+	return report("", rs, nil)
+}
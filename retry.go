@@ -0,0 +1,35 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"github.com/rajder/gosha1/hashwalk"
+	"os"
+	"syscall"
+	"time"
+)
+
+// retried counts the reads retried after a transient error, for -retries.
+var retried int64
+
+// transientError reports whether err may go away when the file is read
+// again: I/O errors and timeouts, as USB enclosures and network mounts
+// give on a hiccup. Missing files and permission errors are final.
+func transientError(err error) bool {
+	var te *hashwalk.TimeoutError
+	return errors.As(err, &te) || errors.Is(err, syscall.EIO) || errors.Is(err, syscall.ETIMEDOUT) ||
+		errors.Is(err, syscall.EAGAIN) || errors.Is(err, os.ErrDeadlineExceeded)
+}
+
+// retryWait waits before the retry n, counting from 1: -retry-delay,
+// doubled for each further retry. It returns false if ctx is done first.
+func retryWait(ctx context.Context, n int) bool {
+	t := time.NewTimer(opts.retryDelay << uint(n-1))
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
@@ -0,0 +1,279 @@
+package main
+
+import (
+	"context"
+	"github.com/rajder/gosha1/hashwalk"
+	"os"
+	"path/filepath"
+)
+
+// printWalkStats prints what the walk left out.
+func printWalkStats(s *hashwalk.Stats) {
+	if s.Cycles > 0 {
+		log("Cycles       :", s.Cycles, "directories reached again through symlinks skipped")
+	}
+	if s.Mounts > 0 {
+		log("Mounts       :", s.Mounts, "directories on other file systems skipped")
+	}
+	if s.HardLinks > 0 {
+		log("Hard links   :", s.HardLinks, "paths of files already hashed through another link, not counted as duplicates")
+	}
+	if s.Hidden > 0 {
+		log("Hidden       :", s.Hidden, "dot directories skipped, -hidden includes them")
+	}
+}
+
+// emptyDirs are the directories without entries found by the walk, for
+// -empty-dirs.
+var emptyDirs []string
+
+// walkRoots returns a job producer walking each root in turn, counting
+// into stats if it isn't nil. A root inside another one, or the same
+// directory given twice, is only walked as part of the first.
+func walkRoots(roots []string, stats *hashwalk.Stats) jobProducer {
+	return func(ctx context.Context, jobs chan<- job) error {
+		w := newWalker(ctx, jobs, stats)
+		inside := overlappingRoots(roots)
+		for i, root := range roots {
+			if outer, ok := inside[i]; ok {
+				if stats != nil {
+					log("Overlap      :", root, "is walked as part of", roots[outer])
+				}
+				continue
+			}
+			logVerbose("Walking      :", root)
+			err := w.Walk(root, i)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+}
+
+// newWalker returns a walker sending the files to jobs, with the filters
+// and walk options of the command line, counting into stats if it isn't
+// nil.
+func newWalker(ctx context.Context, jobs chan<- job, stats *hashwalk.Stats) *hashwalk.Walker {
+	o := hashwalk.WalkOptions{
+		Hidden:        opts.hidden,
+		Symlinks:      opts.symlinks,
+		FoldCase:      opts.foldCase,
+		OneFileSystem: opts.oneFileSystem,
+		KeepGoing:     opts.keepGoing,
+		MaxDepth:      opts.maxDepth,
+		DirWorkers:    workerCount(),
+		Stats:         stats,
+		EnterDir:      enterDir,
+		Keep:          keepEntry,
+		Skipped: func(path, reason string) {
+			logVerbose("Skipped      :", path, "("+reason+")")
+		},
+		// The workers give the aliases the sums of their link, see
+		// linkedSums.
+		HardLinks: true,
+	}
+	if opts.emptyDirs || opts.copy {
+		o.EmptyDir = func(path string) {
+			emptyDirs = append(emptyDirs, path)
+		}
+	}
+	return hashwalk.NewWalker(ctx, o, func(ctx context.Context, f hashwalk.File) error {
+		j := job{File: f}
+		regular := f.Err == nil && f.Link == "" && f.Info.Mode().IsRegular()
+		j.Archive = regular && opts.archives && isArchive(f.Path)
+		if err := sendJob(ctx, jobs, j); err != nil {
+			return err
+		}
+		if regular && opts.ads {
+			return sendStreams(ctx, jobs, j)
+		}
+		return nil
+	})
+}
+
+// sendJob sends j to jobs, ctx's error once it is done.
+func sendJob(ctx context.Context, jobs chan<- job, j job) error {
+	select {
+	case jobs <- j:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// walkFilter is the Dir.State of the walk: the ignore rules in effect and
+// the depth limit of -depth-rule, files deeper than limit are left out, a
+// negative limit means no limit.
+type walkFilter struct {
+	ign   *gitIgnore
+	limit int
+}
+
+// enterDir applies the -depth-rule matching d and reads its ignore files.
+func enterDir(d *hashwalk.Dir) {
+	f := walkFilter{limit: -1}
+	if parent, ok := d.State.(walkFilter); ok {
+		f = parent
+	}
+	if rule, ok := opts.depthRules.match(d.Rel); ok && d.Depth > 0 {
+		f.limit = d.Depth + rule.Max
+	}
+	if opts.respectGitignore || !opts.noIgnoreFiles {
+		f.ign = f.ign.enter(d.Path, d.Rel)
+	}
+	d.State = f
+}
+
+// keepEntry applies the path, ignore file, depth, size, time and type
+// filters to the entry e of d.
+func keepEntry(d *hashwalk.Dir, e *hashwalk.Entry) bool {
+	f, _ := d.State.(walkFilter)
+	isDir := e.Info.IsDir()
+	if !wantPath(e.Rel, isDir) {
+		return false
+	}
+	if opts.respectGitignore && isDir && e.Info.Name() == ".git" || f.ign.ignored(e.Rel, isDir) {
+		logVerbose("Skipped      :", e.Path, "(ignore file)")
+		return false
+	}
+	if e.Link != "" {
+		return f.limit < 0 || d.Depth+1 <= f.limit
+	}
+	if isDir {
+		return f.limit < 0 || d.Depth+2 <= f.limit || opts.depthRules.mayMatchBelow(d.Rel)
+	}
+	if f.limit >= 0 && d.Depth+1 > f.limit {
+		return false
+	}
+	if opts.emptyFiles == "skip" && e.Info.Size() == 0 || !wantSize(e.Info.Size()) || !wantModTime(e.Info.ModTime()) {
+		return false
+	}
+	return wantType(e.Path)
+}
+
+// overlappingRoots maps the index of each root that is inside another
+// root, or the same as an earlier one, to the index of that root. Roots are
+// compared by file ID, so a directory reached through a symlink or a bind
+// mount is found as well.
+func overlappingRoots(roots []string) map[int]int {
+	if len(roots) < 2 {
+		return nil
+	}
+	ids := make([]string, len(roots))
+	for i, root := range roots {
+		if id, ok := pathID(root); ok {
+			ids[i] = id
+		}
+	}
+	inside := make(map[int]int)
+	for i, root := range roots {
+		if ids[i] == "" {
+			continue
+		}
+		dir, err := filepath.Abs(root)
+		if err != nil {
+			continue
+		}
+		if real, err := filepath.EvalSymlinks(dir); err == nil {
+			dir = real
+		}
+		// The same directory counts as inside an earlier root only, an
+		// ancestor as inside any.
+		for j := 0; j < i; j++ {
+			if ids[j] == ids[i] {
+				inside[i] = j
+				break
+			}
+		}
+		for _, ok := inside[i]; !ok && filepath.Dir(dir) != dir; _, ok = inside[i] {
+			dir = filepath.Dir(dir)
+			id, _ := pathID(dir)
+			for j := range roots {
+				if j != i && ids[j] != "" && ids[j] == id {
+					inside[i] = j
+					break
+				}
+			}
+		}
+	}
+	return inside
+}
+
+// pathID returns the hashwalk.FileID of the file or directory at path, following
+// symlinks, false if it can't be read.
+func pathID(path string) (string, bool) {
+	fi, err := os.Stat(path)
+	if err != nil {
+		return "", false
+	}
+	return hashwalk.FileID(path, fi), true
+}
+
+// resolveRoots returns the roots to walk and the base printed paths are
+// relative to. A single root is used as is. Several roots are made absolute
+// and printed as absolute paths, or with -common-base relative to their
+// deepest common ancestor. With -per-root they are walked as given, so each
+// path starts with its root argument. An empty base means the paths as
+// walked.
+func resolveRoots(args []string) (roots []string, base string, err error) {
+	if opts.absolute || opts.relativeTo != "" {
+		return rebaseRoots(args)
+	}
+	if len(args) == 1 {
+		return args, args[0], nil
+	}
+	if opts.perRoot {
+		return args, "", nil
+	}
+	for _, a := range args {
+		p, err := filepath.Abs(a)
+		if err != nil {
+			return nil, "", err
+		}
+		roots = append(roots, p)
+	}
+	if !opts.commonBase {
+		return roots, "", nil
+	}
+	base, ok := commonAncestor(roots)
+	if !ok {
+		logWarning("the roots have no common ancestor, printing absolute paths.")
+	}
+	return roots, base, nil
+}
+
+// rebaseRoots makes the roots absolute for -absolute and -relative-to, so
+// the printed paths don't depend on the working directory: absolute, or
+// relative to the -relative-to directory, with .. for paths outside it.
+func rebaseRoots(args []string) (roots []string, base string, err error) {
+	for _, a := range args {
+		p, err := filepath.Abs(a)
+		if err != nil {
+			return nil, "", err
+		}
+		roots = append(roots, p)
+	}
+	if opts.relativeTo != "" {
+		if base, err = filepath.Abs(opts.relativeTo); err != nil {
+			return nil, "", err
+		}
+	}
+	return roots, base, nil
+}
+
+// commonAncestor returns the deepest directory containing all the absolute
+// paths, false if there is none, e.g. for different Windows drives.
+func commonAncestor(paths []string) (string, bool) {
+	base := filepath.Clean(paths[0])
+	for _, p := range paths[1:] {
+		for !isUnder(p, base) {
+			parent := filepath.Dir(base)
+			if parent == base {
+				return "", false
+			}
+			base = parent
+		}
+	}
+	return base, true
+}
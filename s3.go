@@ -0,0 +1,328 @@
+package main
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"github.com/rajder/gosha1/hashwalk"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// s3Root is an s3://bucket/prefix root. Objects are listed and streamed
+// through the S3 REST API with requests signed by hand (Signature Version
+// 4), the standard library has no AWS client. Credentials come from
+// AWS_ACCESS_KEY_ID, AWS_SECRET_ACCESS_KEY and AWS_SESSION_TOKEN, without
+// them the requests are anonymous. The region is AWS_REGION or us-east-1.
+type s3Root struct {
+	bucket string
+	prefix string // Empty or ending in a slash.
+
+	endpoint *url.URL // Scheme and host, path style requests when set by -s3-endpoint.
+	region   string
+	key      string
+	secret   string
+	token    string
+}
+
+func parseS3Root(s string) (*s3Root, error) {
+	u, err := url.Parse(s)
+	if err != nil {
+		return nil, err
+	}
+	if u.Host == "" {
+		return nil, fmt.Errorf("%s: no bucket", s)
+	}
+	rr := &s3Root{bucket: u.Host, prefix: strings.TrimPrefix(u.Path, "/")}
+	if rr.prefix != "" && !strings.HasSuffix(rr.prefix, "/") {
+		rr.prefix += "/"
+	}
+	rr.region = os.Getenv("AWS_REGION")
+	if rr.region == "" {
+		rr.region = os.Getenv("AWS_DEFAULT_REGION")
+	}
+	if rr.region == "" {
+		rr.region = "us-east-1"
+	}
+	rr.key = os.Getenv("AWS_ACCESS_KEY_ID")
+	rr.secret = os.Getenv("AWS_SECRET_ACCESS_KEY")
+	rr.token = os.Getenv("AWS_SESSION_TOKEN")
+	endpoint := opts.s3Endpoint
+	if endpoint == "" {
+		endpoint = os.Getenv("AWS_ENDPOINT_URL")
+	}
+	if endpoint != "" {
+		rr.endpoint, err = url.Parse(endpoint)
+		if err != nil || rr.endpoint.Host == "" {
+			return nil, fmt.Errorf("invalid S3 endpoint %q", endpoint)
+		}
+	}
+	return rr, nil
+}
+
+// objectURL is the URL of key, or of the bucket for an empty key.
+func (rr *s3Root) objectURL(key string, query url.Values) *url.URL {
+	u := &url.URL{Scheme: "https", Host: rr.bucket + ".s3." + rr.region + ".amazonaws.com", Path: "/" + key}
+	if rr.endpoint != nil {
+		u = &url.URL{Scheme: rr.endpoint.Scheme, Host: rr.endpoint.Host, Path: "/" + rr.bucket + "/" + key}
+	}
+	u.RawPath = s3Escape(u.Path, false)
+	u.RawQuery = s3Query(query)
+	return u
+}
+
+// get sends a signed GET request for key and returns the response, or an
+// error with S3's message for anything but 200 OK.
+func (rr *s3Root) get(ctx context.Context, key string, query url.Values) (*http.Response, error) {
+	u := rr.objectURL(key, query)
+	req, err := http.NewRequestWithContext(ctx, "GET", u.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	if rr.key != "" {
+		rr.sign(req, u, time.Now().UTC())
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		defer resp.Body.Close()
+		var e struct {
+			Code    string
+			Message string
+		}
+		xml.NewDecoder(io.LimitReader(resp.Body, 64*1024)).Decode(&e)
+		if e.Code == "" {
+			e.Code = resp.Status
+		}
+		return nil, fmt.Errorf("s3://%s/%s: %s %s", rr.bucket, key, e.Code, e.Message)
+	}
+	return resp, nil
+}
+
+// emptySHA256 is the payload hash of a request without a body.
+const emptySHA256 = "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"
+
+// sign adds the Signature Version 4 headers of the GET request req for u.
+func (rr *s3Root) sign(req *http.Request, u *url.URL, now time.Time) {
+	date := now.Format("20060102T150405Z")
+	req.Header.Set("X-Amz-Date", date)
+	req.Header.Set("X-Amz-Content-Sha256", emptySHA256)
+	if rr.token != "" {
+		req.Header.Set("X-Amz-Security-Token", rr.token)
+	}
+	names := []string{"host"}
+	for name := range req.Header {
+		names = append(names, strings.ToLower(name))
+	}
+	sort.Strings(names)
+	var canonical strings.Builder
+	fmt.Fprintf(&canonical, "%s\n%s\n%s\n", req.Method, u.EscapedPath(), u.RawQuery)
+	for _, name := range names {
+		v := u.Host
+		if name != "host" {
+			v = req.Header.Get(name)
+		}
+		fmt.Fprintf(&canonical, "%s:%s\n", name, strings.TrimSpace(v))
+	}
+	signed := strings.Join(names, ";")
+	fmt.Fprintf(&canonical, "\n%s\n%s", signed, emptySHA256)
+	scope := date[:8] + "/" + rr.region + "/s3/aws4_request"
+	sum := sha256.Sum256([]byte(canonical.String()))
+	toSign := "AWS4-HMAC-SHA256\n" + date + "\n" + scope + "\n" + hex.EncodeToString(sum[:])
+	k := hmacSHA256([]byte("AWS4"+rr.secret), date[:8])
+	for _, part := range []string{rr.region, "s3", "aws4_request"} {
+		k = hmacSHA256(k, part)
+	}
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%x",
+		rr.key, scope, signed, hmacSHA256(k, toSign)))
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	h := hmac.New(sha256.New, key)
+	io.WriteString(h, data)
+	return h.Sum(nil)
+}
+
+// s3Escape percent-encodes s as Signature Version 4 wants, everything but
+// the unreserved characters and, unless all is set, slashes.
+func s3Escape(s string, all bool) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if 'A' <= c && c <= 'Z' || 'a' <= c && c <= 'z' || '0' <= c && c <= '9' ||
+			c == '-' || c == '_' || c == '.' || c == '~' || c == '/' && !all {
+			b.WriteByte(c)
+			continue
+		}
+		fmt.Fprintf(&b, "%%%02X", c)
+	}
+	return b.String()
+}
+
+// s3Query is the canonical query string, sorted by key.
+func s3Query(q url.Values) string {
+	keys := make([]string, 0, len(q))
+	for k := range q {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	var parts []string
+	for _, k := range keys {
+		for _, v := range q[k] {
+			parts = append(parts, s3Escape(k, true)+"="+s3Escape(v, true))
+		}
+	}
+	return strings.Join(parts, "&")
+}
+
+type s3Object struct {
+	Key          string
+	Size         int64
+	LastModified time.Time
+	ETag         string
+}
+
+// list returns the objects below the prefix, leaving out "directory"
+// markers ending in a slash.
+func (rr *s3Root) list(ctx context.Context) ([]s3Object, error) {
+	var objects []s3Object
+	token := ""
+	for {
+		q := url.Values{"list-type": {"2"}, "prefix": {rr.prefix}}
+		if token != "" {
+			q.Set("continuation-token", token)
+		}
+		resp, err := rr.get(ctx, "", q)
+		if err != nil {
+			return nil, err
+		}
+		var page struct {
+			Contents              []s3Object
+			IsTruncated           bool
+			NextContinuationToken string
+		}
+		err = xml.NewDecoder(resp.Body).Decode(&page)
+		resp.Body.Close()
+		if err != nil {
+			return nil, fmt.Errorf("s3://%s/%s: %v", rr.bucket, rr.prefix, err)
+		}
+		for _, o := range page.Contents {
+			if !strings.HasSuffix(o.Key, "/") {
+				objects = append(objects, o)
+			}
+		}
+		if !page.IsTruncated || page.NextContinuationToken == "" {
+			return objects, nil
+		}
+		token = page.NextContinuationToken
+	}
+}
+
+// etagSum returns the MD5 of an object from its ETag, which it is for
+// objects uploaded in one part without SSE-KMS. Multipart ETags have a
+// -N suffix and are no MD5.
+func etagSum(etag string) ([]byte, bool) {
+	sum, err := hex.DecodeString(strings.Trim(etag, `"`))
+	return sum, err == nil && len(sum) == 16
+}
+
+// s3Sums hashes the objects below rr with algos, streaming them with a
+// worker per -jobs. Path is the key relative to the prefix. With -s3-etag
+// and -algos md5 the sums of single part uploads are taken from their
+// ETags instead, without downloading them.
+func s3Sums(ctx context.Context, rr *s3Root, algos []string) (resultSlice, error) {
+	objects, err := rr.list(ctx)
+	if err != nil {
+		return nil, err
+	}
+	var rs resultSlice
+	var todo []s3Object
+	for _, o := range objects {
+		rel := strings.TrimPrefix(o.Key, rr.prefix)
+		if !opts.hidden && hasDotElement(rel) || !wantPath(rel, false) || !wantSize(o.Size) || !wantModTime(o.LastModified) ||
+			opts.emptyFiles == "skip" && o.Size == 0 {
+			continue
+		}
+		if sum, ok := etagSum(o.ETag); ok && opts.s3ETag {
+			rs = append(rs, result{Path: rel, Sum: sum, Sums: [][]byte{sum}, Size: o.Size,
+				ModTime: o.LastModified, Cached: true})
+			continue
+		}
+		todo = append(todo, o)
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	objs := make(chan s3Object)
+	var mu sync.Mutex
+	var firstErr error
+	var wg sync.WaitGroup
+	for i := 0; i < workerCount(); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for o := range objs {
+				r, err := rr.hashObject(ctx, o, algos)
+				mu.Lock()
+				if err != nil && opts.keepGoing && ctx.Err() == nil {
+					logWarning(err)
+				} else if err != nil && firstErr == nil {
+					firstErr = err
+					cancel()
+				} else if err == nil {
+					rs = append(rs, r)
+				}
+				mu.Unlock()
+			}
+		}()
+	}
+	for _, o := range todo {
+		select {
+		case objs <- o:
+		case <-ctx.Done():
+		}
+	}
+	close(objs)
+	wg.Wait()
+	if firstErr != nil {
+		return nil, firstErr
+	}
+	return rs, ctx.Err()
+}
+
+func (rr *s3Root) hashObject(ctx context.Context, o s3Object, algos []string) (result, error) {
+	resp, err := rr.get(ctx, o.Key, nil)
+	if err != nil {
+		return result{}, err
+	}
+	defer resp.Body.Close()
+	sums, size, err := hashwalk.SumReaderContext(ctx, limitRead(ctx, resp.Body), algos, nil)
+	if err != nil {
+		return result{}, fmt.Errorf("s3://%s/%s: %v", rr.bucket, o.Key, err)
+	}
+	rel := strings.TrimPrefix(o.Key, rr.prefix)
+	logDebug("read s3://%s/%s: %d bytes", rr.bucket, o.Key, size)
+	return result{Path: rel, Sum: sums[0], Sums: sums, Size: size, ModTime: o.LastModified}, nil
+}
+
+// hasDotElement reports whether any element of the slash separated rel is
+// a dot name, the keys and paths the walker leaves out along with the dot
+// directories they are in.
+func hasDotElement(rel string) bool {
+	for _, e := range strings.Split(rel, "/") {
+		if hashwalk.IsDotPath(e) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"os"
+	"os/signal"
+	"syscall"
+)
+
+// errInterrupted ends a run stopped by Ctrl-C or SIGTERM.
+var errInterrupted = errors.New("interrupted, the results are incomplete")
+
+// errDeadline ends a run stopped by -timeout.
+var errDeadline = errors.New("the -timeout ran out, the results are incomplete")
+
+// runContext returns the context of a run, which is done on Ctrl-C or
+// SIGTERM and once -timeout has passed.
+func runContext() (context.Context, context.CancelFunc) {
+	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	if opts.timeout <= 0 {
+		return ctx, cancel
+	}
+	ctx, cancelTimeout := context.WithTimeout(ctx, opts.timeout)
+	return ctx, func() {
+		cancelTimeout()
+		cancel()
+	}
+}
+
+// stopError returns errInterrupted or errDeadline if err is from ctx being
+// done, nil otherwise.
+func stopError(ctx context.Context, err error) error {
+	if ctx.Err() == nil || !errors.Is(err, context.Canceled) && !errors.Is(err, context.DeadlineExceeded) {
+		return nil
+	}
+	if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		return errDeadline
+	}
+	return errInterrupted
+}
+
+// A jobProducer feeds files to hash to jobs, e.g. by walking directories. It
+// should stop and return ctx.Err() when ctx is done.
+type jobProducer func(ctx context.Context, jobs chan<- job) error
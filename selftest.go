@@ -0,0 +1,149 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"github.com/rajder/gosha1/hashwalk"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+// The tree written by -selftest and the SHA-1 expected for each file. Dot
+// files are skipped by the walker and must not show up in the results.
+var selftestFiles = []struct {
+	path    string
+	content string
+	sum     string
+}{
+	{"a.txt", "hello\n", "f572d396fae9206628714fb2ce00f72e94f2258f"},
+	{"sub/b.txt", "hello\n", "f572d396fae9206628714fb2ce00f72e94f2258f"},
+	{"sub/deeper/c.txt", "abc", "a9993e364706816aba3e25717850c26c9cd0d89d"},
+	{"empty", "", "da39a3ee5e6b4b0d3255bfef95601890afd80709"},
+	{".hidden/d.txt", "hidden", ""},
+}
+
+// runSelftest writes a small tree below dir (the system temp dir if empty),
+// runs it through the walker, workers and duplicate grouping, and checks the
+// results. Returns the exit code, 0 if everything passed.
+func runSelftest(dir string) int {
+	tmp, err := os.MkdirTemp(dir, "gosha1-selftest-")
+	if err != nil {
+		logError(err)
+		return exitError
+	}
+	defer os.RemoveAll(tmp)
+	for _, f := range selftestFiles {
+		p := filepath.Join(tmp, filepath.FromSlash(f.path))
+		err = os.MkdirAll(filepath.Dir(p), 0700)
+		if err == nil {
+			err = os.WriteFile(p, []byte(f.content), 0600)
+		}
+		if err != nil {
+			logError(err)
+			return exitError
+		}
+	}
+	opts = options{algos: []string{"sha1"}, emptyFiles: "group", pureGo: opts.pureGo}
+	var failed []result
+	spec := hashSpec{algos: opts.algos}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	rs, err := collect(produceConcurrent(ctx, spec, walkRoots([]string{tmp}, nil)), nil, &failed)
+	ok := true
+	check := func(pass bool, what string) {
+		status := "PASS"
+		if !pass {
+			status = "FAIL"
+			ok = false
+		}
+		fmt.Fprintf(stdout, "%s\t%s\n", status, what)
+	}
+	check(err == nil && len(failed) == 0, fmt.Sprintf("hash tree in %s", tmp))
+	sort.Sort(rs)
+	got := make(map[string]string)
+	for _, r := range rs {
+		got[filepath.ToSlash(relPath(tmp, r.Path))] = fmt.Sprintf("%x", r.Sum)
+	}
+	want := 0
+	for _, f := range selftestFiles {
+		if f.sum == "" {
+			_, found := got[f.path]
+			check(!found, "skip "+f.path)
+			continue
+		}
+		want++
+		check(got[f.path] == f.sum, "sha1 of "+f.path)
+	}
+	check(len(rs) == want, fmt.Sprintf("%d files found", want))
+	groups := duplicateGroups(rs)
+	check(len(groups) == 1 && len(groups[0]) == 2 &&
+		got["a.txt"] == fmt.Sprintf("%x", groups[0][0].Sum), "one duplicate group of a.txt and sub/b.txt")
+	check(selftestRoundTrip(tmp, []string{"sha1", "sha256"}, ""), "-check a manifest written with -algos sha1,sha256")
+	check(selftestRoundTrip(tmp, []string{"sha1"}, "size,path,sum"), "-check a manifest written with -fields size,path,sum")
+	if opts.pureGo {
+		log("SHA-1        :", "pure Go of gosha1 (-pure-go)")
+	} else {
+		log("SHA-1        :", "crypto/sha1,", sha1Backend())
+	}
+	log("SHA-1 MB/s   :", sha1Throughput())
+	if !ok {
+		fmt.Fprintln(stdout, "FAIL")
+		return exitFound
+	}
+	fmt.Fprintln(stdout, "PASS")
+	return 0
+}
+
+// sha1Throughput hashes 64 MB from memory and returns the MB/s, the speed
+// limit of the CPU without any disk.
+func sha1Throughput() string {
+	buf := make([]byte, 1<<20)
+	h := hashwalk.Algorithms["sha1"]()
+	start := time.Now()
+	for i := 0; i < 64; i++ {
+		h.Write(buf)
+	}
+	return fmt.Sprintf("%.0f", 64/time.Since(start).Seconds())
+}
+
+// selftestRoundTrip hashes tmp with algos, writes the manifest with fields
+// (empty for the default) into it and reports whether -check, which leaves
+// the manifest itself out, finds everything the same.
+func selftestRoundTrip(tmp string, algos []string, fields string) bool {
+	saved, savedOut, savedLevel := opts, stdout, verbosity
+	defer func() { opts, stdout, verbosity = saved, savedOut, savedLevel }()
+	opts.algos = algos
+	opts.fields, _ = parseFields(fields, algos)
+	verbosity = levelQuiet
+	var failed []result
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	rs, err := collect(produceConcurrent(ctx, hashSpec{algos: algos}, walkRoots([]string{tmp}, nil)), nil, &failed)
+	if err != nil || len(failed) > 0 {
+		return false
+	}
+	sort.Sort(rs)
+	manifest := filepath.Join(tmp, ".manifest")
+	f, err := os.Create(manifest)
+	if err != nil {
+		return false
+	}
+	defer os.Remove(manifest)
+	stdout = f
+	printAlgosHeader()
+	for i := range rs {
+		printResult(tmp, &rs[i])
+	}
+	if err := f.Close(); err != nil {
+		return false
+	}
+	null, err := os.OpenFile(os.DevNull, os.O_WRONLY, 0)
+	if err != nil {
+		return false
+	}
+	defer null.Close()
+	stdout = null
+	return runCheck(manifest, tmp) == 0
+}
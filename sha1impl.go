@@ -0,0 +1,65 @@
+//go:build !purego
+// +build !purego
+
+package main
+
+import (
+	"os"
+	"runtime"
+	"strings"
+)
+
+// sha1Backend describes the crypto/sha1 code used on this CPU. crypto/sha1
+// picks its assembly at run time from the internal/cpu features, which
+// can't be imported, and golang.org/x/sys/cpu isn't vendored, so the
+// features are read from /proc/cpuinfo, on Linux only.
+func sha1Backend() string {
+	switch runtime.GOARCH {
+	case "amd64":
+		f := cpuFlags("flags")
+		switch {
+		case f == nil:
+			return "SHA-NI or AVX2 assembly if the CPU has them, or Go"
+		case f["sha_ni"] && f["avx"] && f["sse4_1"] && f["ssse3"]:
+			return "SHA-NI assembly"
+		case f["avx"] && f["avx2"] && f["bmi1"] && f["bmi2"]:
+			return "AVX2 assembly"
+		}
+		return "Go, the CPU has neither SHA-NI nor AVX2"
+	case "arm64":
+		f := cpuFlags("Features")
+		switch {
+		case f == nil:
+			return "ARMv8 SHA1 assembly if the CPU has it, or Go"
+		case f["sha1"]:
+			return "ARMv8 SHA1 assembly"
+		}
+		return "Go, the CPU has no SHA1 instructions"
+	case "s390x":
+		return "CPACF if the CPU has it, or Go"
+	case "386", "arm", "loong64", "riscv64":
+		return runtime.GOARCH + " assembly"
+	}
+	return "Go, there is no assembly for " + runtime.GOARCH
+}
+
+// cpuFlags returns the words of the first line of /proc/cpuinfo starting
+// with key, nil if there is none.
+func cpuFlags(key string) map[string]bool {
+	b, err := os.ReadFile("/proc/cpuinfo")
+	if err != nil {
+		return nil
+	}
+	for _, line := range strings.Split(string(b), "\n") {
+		name, value, ok := strings.Cut(line, ":")
+		if !ok || strings.TrimSpace(name) != key {
+			continue
+		}
+		flags := make(map[string]bool)
+		for _, f := range strings.Fields(value) {
+			flags[f] = true
+		}
+		return flags
+	}
+	return nil
+}
@@ -0,0 +1,10 @@
+//go:build purego
+// +build purego
+
+package main
+
+// sha1Backend describes the crypto/sha1 code used. The purego build tag
+// makes crypto/sha1 skip its assembly on every CPU.
+func sha1Backend() string {
+	return "Go (built with -tags purego)"
+}
@@ -0,0 +1,196 @@
+package main
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"github.com/rajder/gosha1/hashwalk"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// A key of -sign or -verify-key. It is either a PEM Ed25519 key, as made by
+// openssl genpkey -algorithm ed25519, whose signature is written to
+// MANIFEST.sig, or a minisign key, whose signature is written to
+// MANIFEST.minisig and can also be checked with minisign -V.
+type signKey struct {
+	priv     ed25519.PrivateKey // Only for -sign.
+	pub      ed25519.PublicKey
+	minisign bool
+	keyID    []byte // Of a minisign key.
+}
+
+// sigPath is where the signature of manifest with key k is kept.
+func (k *signKey) sigPath(manifest string) string {
+	if k.minisign {
+		return manifest + ".minisig"
+	}
+	return manifest + ".sig"
+}
+
+// loadSigningKey reads the secret key for -sign. Encrypted minisign keys
+// aren't supported, the standard library has no scrypt.
+func loadSigningKey(path string) (*signKey, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	if block, _ := pem.Decode(data); block != nil {
+		key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", path, err)
+		}
+		priv, ok := key.(ed25519.PrivateKey)
+		if !ok {
+			return nil, fmt.Errorf("%s: not an Ed25519 key", path)
+		}
+		return &signKey{priv: priv, pub: priv.Public().(ed25519.PublicKey)}, nil
+	}
+	b, err := minisignBlob(data)
+	if err != nil || len(b) != 158 || string(b[:2]) != "Ed" || string(b[4:6]) != "B2" {
+		return nil, fmt.Errorf("%s: not a PEM Ed25519 or minisign secret key", path)
+	}
+	if string(b[2:4]) != "\x00\x00" {
+		return nil, fmt.Errorf("%s: the minisign key is encrypted, create one with minisign -G -W", path)
+	}
+	priv := ed25519.PrivateKey(append([]byte(nil), b[62:126]...))
+	pub := priv.Public().(ed25519.PublicKey)
+	if !bytes.Equal(ed25519.NewKeyFromSeed(priv.Seed()), priv) {
+		return nil, fmt.Errorf("%s: corrupt minisign secret key", path)
+	}
+	return &signKey{priv: priv, pub: pub, minisign: true, keyID: b[54:62]}, nil
+}
+
+// loadVerifyKey reads the public key for -verify-key from a file, or takes s
+// itself as a minisign public key as printed by minisign -G.
+func loadVerifyKey(s string) (*signKey, error) {
+	data, err := os.ReadFile(s)
+	if errors.Is(err, os.ErrNotExist) {
+		if b, berr := minisignBlob([]byte(s)); berr == nil && len(b) == 42 {
+			data, err = []byte(s), nil
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+	if block, _ := pem.Decode(data); block != nil {
+		key, err := x509.ParsePKIXPublicKey(block.Bytes)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", s, err)
+		}
+		pub, ok := key.(ed25519.PublicKey)
+		if !ok {
+			return nil, fmt.Errorf("%s: not an Ed25519 key", s)
+		}
+		return &signKey{pub: pub}, nil
+	}
+	b, err := minisignBlob(data)
+	if err != nil || len(b) != 42 || string(b[:2]) != "Ed" {
+		return nil, fmt.Errorf("%s: not a PEM Ed25519 or minisign public key", s)
+	}
+	return &signKey{pub: ed25519.PublicKey(b[10:]), minisign: true, keyID: b[2:10]}, nil
+}
+
+// minisignBlob decodes the base64 line of a minisign key or signature file,
+// skipping the untrusted comment.
+func minisignBlob(data []byte) ([]byte, error) {
+	for _, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "untrusted comment:") {
+			continue
+		}
+		return base64.StdEncoding.DecodeString(line)
+	}
+	return nil, errors.New("no key")
+}
+
+// blake2b512 is the prehash of minisign signatures.
+func blake2b512(data []byte) []byte {
+	h := hashwalk.Algorithms["blake2b"]()
+	h.Write(data)
+	return h.Sum(nil)
+}
+
+// signManifest writes the detached signature of the manifest file.
+func signManifest(manifest string, k *signKey) error {
+	data, err := os.ReadFile(manifest)
+	if err != nil {
+		return err
+	}
+	var out string
+	if !k.minisign {
+		out = base64.StdEncoding.EncodeToString(ed25519.Sign(k.priv, data)) + "\n"
+	} else {
+		sig := append(append([]byte("ED"), k.keyID...), ed25519.Sign(k.priv, blake2b512(data))...)
+		trusted := fmt.Sprintf("timestamp:%d\tfile:%s\thashed", time.Now().Unix(), filepath.Base(manifest))
+		global := ed25519.Sign(k.priv, append(append([]byte(nil), sig[10:]...), trusted...))
+		out = "untrusted comment: signature from gosha1 secret key\n" +
+			base64.StdEncoding.EncodeToString(sig) + "\n" +
+			"trusted comment: " + trusted + "\n" +
+			base64.StdEncoding.EncodeToString(global) + "\n"
+	}
+	o, err := createOutput(k.sigPath(manifest))
+	if err != nil {
+		return err
+	}
+	if _, err := o.f.WriteString(out); err != nil {
+		o.abort()
+		return err
+	}
+	return o.commit()
+}
+
+// verifyManifest checks the detached signature of the manifest file with k.
+func verifyManifest(manifest string, k *signKey) error {
+	data, err := os.ReadFile(manifest)
+	if err != nil {
+		return err
+	}
+	sigPath := k.sigPath(manifest)
+	sigData, err := os.ReadFile(sigPath)
+	if err != nil {
+		return fmt.Errorf("%s isn't signed: %v", manifest, err)
+	}
+	bad := fmt.Errorf("%s: the signature doesn't match %s, it was modified or signed with another key", sigPath, manifest)
+	if !k.minisign {
+		sig, err := base64.StdEncoding.DecodeString(strings.TrimSpace(string(sigData)))
+		if err != nil || !ed25519.Verify(k.pub, data, sig) {
+			return bad
+		}
+		return nil
+	}
+	lines := strings.Split(strings.TrimSpace(string(sigData)), "\n")
+	if len(lines) != 4 || !strings.HasPrefix(lines[2], "trusted comment: ") {
+		return fmt.Errorf("%s: not a minisign signature", sigPath)
+	}
+	sig, err := base64.StdEncoding.DecodeString(strings.TrimSpace(lines[1]))
+	if err != nil || len(sig) != 74 {
+		return fmt.Errorf("%s: not a minisign signature", sigPath)
+	}
+	if !bytes.Equal(sig[2:10], k.keyID) {
+		return fmt.Errorf("%s: signed with another key", sigPath)
+	}
+	msg := data
+	switch string(sig[:2]) {
+	case "ED":
+		msg = blake2b512(data)
+	case "Ed":
+	default:
+		return fmt.Errorf("%s: unknown signature algorithm", sigPath)
+	}
+	if !ed25519.Verify(k.pub, msg, sig[10:]) {
+		return bad
+	}
+	global, err := base64.StdEncoding.DecodeString(strings.TrimSpace(lines[3]))
+	trusted := strings.TrimPrefix(strings.TrimRight(lines[2], "\r"), "trusted comment: ")
+	if err != nil || !ed25519.Verify(k.pub, append(append([]byte(nil), sig[10:]...), trusted...), global) {
+		return fmt.Errorf("%s: the trusted comment was modified", sigPath)
+	}
+	return nil
+}
@@ -0,0 +1,43 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// byteSize is a flag of a number of bytes with an optional K, M, G or T
+// suffix (powers of 1024), e.g. 10K or 2G.
+type byteSize int64
+
+var sizeSuffixes = []struct {
+	suffix string
+	mult   int64
+}{{"K", 1 << 10}, {"M", 1 << 20}, {"G", 1 << 30}, {"T", 1 << 40}}
+
+func (b *byteSize) String() string {
+	return strconv.FormatInt(int64(*b), 10)
+}
+
+func (b *byteSize) Set(s string) error {
+	num := strings.TrimSuffix(strings.ToUpper(strings.TrimSpace(s)), "B")
+	mult := int64(1)
+	for _, sfx := range sizeSuffixes {
+		if strings.HasSuffix(num, sfx.suffix) {
+			num, mult = strings.TrimSuffix(num, sfx.suffix), sfx.mult
+			break
+		}
+	}
+	n, err := strconv.ParseFloat(num, 64)
+	if err != nil || n < 0 {
+		return fmt.Errorf("invalid size %q, e.g. 4096, 10K, 100M or 2G", s)
+	}
+	*b = byteSize(n * float64(mult))
+	return nil
+}
+
+// wantSize reports whether a file of size bytes is within -min-size and
+// -max-size, where a -max-size of 0 means no limit.
+func wantSize(size int64) bool {
+	return size >= int64(opts.minSize) && (opts.maxSize == 0 || size <= int64(opts.maxSize))
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+)
+
+// slowestFiles keeps the n files that took longest to hash, for -slowest.
+type slowestFiles struct {
+	mu  sync.Mutex
+	n   int
+	top resultSlice // Slowest first.
+}
+
+// slowest is nil without -slowest.
+var slowest *slowestFiles
+
+// add records r if it is among the slowest so far. Cached files and hard
+// link aliases weren't read and are left out.
+func (s *slowestFiles) add(r *result) {
+	if s == nil || r.Err != nil || r.Cached || r.Alias != "" {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(s.top) == s.n && r.Duration <= s.top[len(s.top)-1].Duration {
+		return
+	}
+	i := sort.Search(len(s.top), func(i int) bool { return s.top[i].Duration < r.Duration })
+	keep := result{Path: r.Path, Size: r.Size, Duration: r.Duration}
+	s.top = append(s.top, result{})
+	copy(s.top[i+1:], s.top[i:])
+	s.top[i] = keep
+	if len(s.top) > s.n {
+		s.top = s.top[:s.n]
+	}
+}
+
+// print logs the slowest files with their read speed: a file far slower
+// than others of its size points at a failing disk or a bad network path.
+func (s *slowestFiles) print() {
+	if s == nil || len(s.top) == 0 {
+		return
+	}
+	log("Slowest      :", len(s.top), "files")
+	for _, r := range s.top {
+		mbps := 0.0
+		if r.Duration > 0 {
+			mbps = float64(r.Size) / 1024 / 1024 / r.Duration.Seconds()
+		}
+		log(fmt.Sprintf("\t%v\t%.2f MB/s\t%s", r.Duration.Round(time.Millisecond), mbps, r.Path))
+	}
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"bytes"
+	"sort"
+)
+
+// snapshotDiff compares two manifests by path: files only in the new one
+// are added, only in the old one removed, and with a different sum
+// modified. A removed and an added file with the same sum are reported as
+// renamed instead. It returns 0 without differences and 1 with, 2 on error.
+func snapshotDiff(oldPath, newPath string) int {
+	oldEntries, err := readManifest(oldPath)
+	if err == nil {
+		var newEntries []manifestEntry
+		newEntries, err = readManifest(newPath)
+		if err == nil {
+			return printSnapshotDiff(oldEntries, newEntries)
+		}
+	}
+	logError(err)
+	return 2
+}
+
+func printSnapshotDiff(oldEntries, newEntries []manifestEntry) int {
+	before := make(map[string][]byte, len(oldEntries))
+	for _, e := range oldEntries {
+		before[e.Path] = e.Sum
+	}
+	after := make(map[string][]byte, len(newEntries))
+	for _, e := range newEntries {
+		after[e.Path] = e.Sum
+	}
+	var modified, removed []string
+	for p, sum := range before {
+		n, ok := after[p]
+		if !ok {
+			removed = append(removed, p)
+		} else if !bytes.Equal(sum, n) {
+			modified = append(modified, p)
+		}
+	}
+	var added []string
+	for p := range after {
+		if _, ok := before[p]; !ok {
+			added = append(added, p)
+		}
+	}
+	sort.Strings(modified)
+	sort.Strings(removed)
+	sort.Strings(added)
+	addedBySum := make(map[string][]string)
+	for _, p := range added {
+		k := string(after[p])
+		addedBySum[k] = append(addedBySum[k], p)
+	}
+	renamed := make(map[string]string)
+	for _, p := range removed {
+		k := string(before[p])
+		if cands := addedBySum[k]; len(cands) > 0 {
+			renamed[p] = cands[0]
+			addedBySum[k] = cands[1:]
+		}
+	}
+	moved := make(map[string]bool, len(renamed))
+	for _, n := range renamed {
+		moved[n] = true
+	}
+	var nAdded, nRemoved int
+	for _, p := range added {
+		if !moved[p] {
+			printLine("added\t%s", p)
+			nAdded++
+		}
+	}
+	for _, p := range removed {
+		if n, ok := renamed[p]; ok {
+			printLine("renamed\t%s\t%s", p, n)
+			continue
+		}
+		printLine("removed\t%s", p)
+		nRemoved++
+	}
+	for _, p := range modified {
+		printLine("modified\t%s", p)
+	}
+	log("Added        :", nAdded)
+	log("Removed      :", nRemoved)
+	log("Modified     :", len(modified))
+	log("Renamed      :", len(renamed))
+	if nAdded+nRemoved+len(modified)+len(renamed) > 0 {
+		return 1
+	}
+	return 0
+}
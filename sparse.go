@@ -0,0 +1,76 @@
+package main
+
+import (
+	"io"
+	"os"
+	"sync/atomic"
+)
+
+// sparseFiles and holeBytes count the sparse files hashed with -sparse and
+// the bytes of their holes that weren't read.
+var sparseFiles, holeBytes int64
+
+// sparseReader reads a sparse file for -sparse: the data extents from the
+// file, the holes between them as zeros without reading them, so the sum is
+// that of the logical contents.
+type sparseReader struct {
+	f       *os.File
+	off     int64
+	size    int64
+	dataEnd int64 // End of the data extent off is in, if it is.
+}
+
+// newSparseReader returns a sparseReader for f if it is sparse, taking up
+// less space than its size, and the file system can tell where its holes
+// are.
+func newSparseReader(f *os.File, fi os.FileInfo) (io.Reader, bool) {
+	alloc, ok := allocatedSize(fi)
+	if !ok || alloc >= fi.Size() || !holesSupported {
+		return nil, false
+	}
+	if _, err := seekData(f, 0); err != nil && err != io.EOF {
+		return nil, false
+	}
+	atomic.AddInt64(&sparseFiles, 1)
+	return &sparseReader{f: f, size: fi.Size()}, true
+}
+
+func (s *sparseReader) Read(p []byte) (int, error) {
+	if s.off >= s.size {
+		return 0, io.EOF
+	}
+	if s.off >= s.dataEnd {
+		data, err := seekData(s.f, s.off)
+		if err == io.EOF {
+			data = s.size
+		} else if err != nil {
+			return 0, err
+		}
+		if data > s.off {
+			n := len(p)
+			if int64(n) > data-s.off {
+				n = int(data - s.off)
+			}
+			for i := range p[:n] {
+				p[i] = 0
+			}
+			s.off += int64(n)
+			atomic.AddInt64(&holeBytes, int64(n))
+			return n, nil
+		}
+		if s.dataEnd, err = seekHole(s.f, s.off); err != nil {
+			return 0, err
+		}
+	}
+	if int64(len(p)) > s.dataEnd-s.off {
+		p = p[:s.dataEnd-s.off]
+	}
+	n, err := s.f.ReadAt(p, s.off)
+	s.off += int64(n)
+	if err == io.EOF && s.off < s.size {
+		err = io.ErrUnexpectedEOF
+	} else if err == io.EOF {
+		err = nil
+	}
+	return n, err
+}
@@ -0,0 +1,33 @@
+package main
+
+import (
+	"errors"
+	"io"
+	"os"
+	"syscall"
+)
+
+// holesSupported tells whether seekData and seekHole can find the holes of
+// sparse files, with lseek's SEEK_DATA and SEEK_HOLE.
+const holesSupported = true
+
+const (
+	seekDataWhence = 3
+	seekHoleWhence = 4
+)
+
+// seekData returns the start of the first data at or after off, io.EOF if
+// there is none before the end of the file.
+func seekData(f *os.File, off int64) (int64, error) {
+	n, err := f.Seek(off, seekDataWhence)
+	if errors.Is(err, syscall.ENXIO) {
+		return 0, io.EOF
+	}
+	return n, err
+}
+
+// seekHole returns the start of the first hole at or after off, the end of
+// the file counting as one.
+func seekHole(f *os.File, off int64) (int64, error) {
+	return f.Seek(off, seekHoleWhence)
+}
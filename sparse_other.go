@@ -0,0 +1,21 @@
+//go:build !linux
+// +build !linux
+
+package main
+
+import (
+	"errors"
+	"os"
+)
+
+// holesSupported tells whether seekData and seekHole can find the holes of
+// sparse files, only on Linux for now.
+const holesSupported = false
+
+func seekData(f *os.File, off int64) (int64, error) {
+	return 0, errors.New("finding holes isn't supported on this platform")
+}
+
+func seekHole(f *os.File, off int64) (int64, error) {
+	return 0, errors.New("finding holes isn't supported on this platform")
+}
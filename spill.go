@@ -0,0 +1,243 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"container/heap"
+	"context"
+	"encoding/gob"
+	"github.com/rajder/gosha1/hashwalk"
+	"io"
+	"os"
+	"sort"
+	"time"
+)
+
+// External sorting for -spill-at: the results are kept in memory up to
+// that many, then sorted and written to a temporary file as a run, and the
+// runs are merged while printing. Memory use stays bounded by the run size,
+// whatever the number of files.
+
+// spillRecord is the part of a result a run keeps, good results only have
+// no error and, without -cdc, no chunks.
+type spillRecord struct {
+	Path    string
+	Sum     []byte
+	Sums    [][]byte
+	Size    int64
+	ModTime time.Time
+	Pieces  [][]byte
+	Entropy float64
+	Root    int
+	Cached  bool
+	Quick   bool
+	Retries int
+	Meta    []byte
+	Alias   string
+}
+
+// spiller sorts the results it is given into runs.
+type spiller struct {
+	limit int
+	buf   resultSlice
+	runs  []*os.File
+}
+
+// add buffers r, spilling the buffer as a run once it is full.
+func (sp *spiller) add(r result) error {
+	sp.buf = append(sp.buf, r)
+	if len(sp.buf) < sp.limit {
+		return nil
+	}
+	return sp.spill()
+}
+
+// spill writes the sorted buffer to a new temporary file.
+func (sp *spiller) spill() error {
+	sort.Sort(sp.buf)
+	f, err := os.CreateTemp("", "gosha1-run-*")
+	if err != nil {
+		return err
+	}
+	sp.runs = append(sp.runs, f)
+	bw := bufio.NewWriter(f)
+	enc := gob.NewEncoder(bw)
+	for i := range sp.buf {
+		r := &sp.buf[i]
+		rec := spillRecord{r.Path, r.Sum, r.Sums, r.Size, r.ModTime, r.Pieces, r.Entropy, r.Root, r.Cached, r.Quick, r.Retries, r.Meta, r.Alias}
+		if err := enc.Encode(&rec); err != nil {
+			return err
+		}
+	}
+	if err := bw.Flush(); err != nil {
+		return err
+	}
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	logVerbose("Spilled      :", len(sp.buf), "results to", f.Name())
+	sp.buf = sp.buf[:0]
+	return nil
+}
+
+// close removes the runs.
+func (sp *spiller) close() {
+	for _, f := range sp.runs {
+		f.Close()
+		os.Remove(f.Name())
+	}
+}
+
+// runHead is the next result of a run, or of the buffer when dec is nil.
+type runHead struct {
+	r   result
+	dec *gob.Decoder
+	buf resultSlice
+}
+
+// next advances h, false at the end of its run.
+func (h *runHead) next() (bool, error) {
+	if h.dec == nil {
+		if len(h.buf) == 0 {
+			return false, nil
+		}
+		h.r, h.buf = h.buf[0], h.buf[1:]
+		return true, nil
+	}
+	var rec spillRecord
+	if err := h.dec.Decode(&rec); err != nil {
+		if err == io.EOF {
+			return false, nil
+		}
+		return false, err
+	}
+	h.r = result{Path: rec.Path, Sum: rec.Sum, Sums: rec.Sums, Size: rec.Size, ModTime: rec.ModTime,
+		Pieces: rec.Pieces, Entropy: rec.Entropy, Root: rec.Root, Cached: rec.Cached, Quick: rec.Quick, Retries: rec.Retries,
+		Meta: rec.Meta, Alias: rec.Alias}
+	return true, nil
+}
+
+type runHeap []*runHead
+
+func (h runHeap) Len() int            { return len(h) }
+func (h runHeap) Less(i, j int) bool  { return lessResult(&h[i].r, &h[j].r) }
+func (h runHeap) Swap(i, j int)       { h[i], h[j] = h[j], h[i] }
+func (h *runHeap) Push(x interface{}) { *h = append(*h, x.(*runHead)) }
+func (h *runHeap) Pop() interface{} {
+	old := *h
+	x := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return x
+}
+
+// merge calls each with the results of all runs and the buffer in sorted
+// order.
+func (sp *spiller) merge(each func(r *result)) error {
+	sort.Sort(sp.buf)
+	heads := []*runHead{{buf: sp.buf}}
+	for _, f := range sp.runs {
+		heads = append(heads, &runHead{dec: gob.NewDecoder(bufio.NewReader(f))})
+	}
+	var h runHeap
+	for _, rh := range heads {
+		ok, err := rh.next()
+		if err != nil {
+			return err
+		}
+		if ok {
+			h = append(h, rh)
+		}
+	}
+	heap.Init(&h)
+	for len(h) > 0 {
+		rh := h[0]
+		r := rh.r
+		each(&r)
+		ok, err := rh.next()
+		if err != nil {
+			return err
+		}
+		if ok {
+			heap.Fix(&h, 0)
+		} else {
+			heap.Pop(&h)
+		}
+	}
+	return nil
+}
+
+// sortSpilled collects the results of s like collect, spilling sorted runs
+// of -spill-at results, and prints them merged like the sorted file list,
+// or only their totals with -summary. An interrupted scan prints the files
+// hashed so far. The summary stats are returned for -summary-json.
+func sortSpilled(ctx context.Context, s *hashwalk.Scan[result], bar *progressBar, failed *[]result, basepath string) (summary, error) {
+	sp := &spiller{limit: opts.spillAt}
+	defer sp.close()
+	var spillErr error
+	err := collectEach(s, bar, failed, func(r result) {
+		if r.Alias != "" && r.Sum == nil {
+			// The link failed.
+			return
+		}
+		if spillErr == nil {
+			spillErr = sp.add(r)
+		}
+	})
+	if spillErr != nil {
+		return summary{}, spillErr
+	}
+	serr := stopError(ctx, err)
+	if err != nil && serr == nil {
+		return summary{}, err
+	}
+	if serr == errDeadline {
+		logWarning("-timeout ran out, printing the files hashed so far")
+	} else if serr != nil {
+		logWarning("interrupted, printing the files hashed so far")
+	}
+	if !opts.summaryOnly {
+		printAlgosHeader()
+	}
+	// The merged results are sorted by sum, so copies are next to each
+	// other as for summarize.
+	var t summary
+	var high int
+	var sum []byte
+	merr := sp.merge(func(r *result) {
+		if !opts.summaryOnly {
+			printResult(basepath, r)
+		}
+		if r.Alias != "" {
+			// Hard link aliases don't count, as for summarize.
+			return
+		}
+		t.Files++
+		t.TotalBytes += r.Size
+		if r.Entropy >= highEntropy {
+			high++
+		}
+		if t.Files > 1 && bytes.Equal(r.Sum, sum) {
+			t.Duplicates++
+			t.DupBytes += r.Size
+			return
+		}
+		sum = r.Sum
+		t.Unique++
+	})
+	if merr != nil {
+		return t, merr
+	}
+	if opts.summaryOnly && serr == nil {
+		if perr := printSummaryOnly(t); perr != nil {
+			return t, perr
+		}
+	}
+	if len(sp.runs) > 0 {
+		log("Spilled      :", len(sp.runs), "sorted runs to temporary files")
+	}
+	printStats(t, high)
+	if opts.emptyDirs {
+		printEmptyDirs(basepath)
+	}
+	return t, err
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"context"
+	"github.com/rajder/gosha1/hashwalk"
+	"io"
+	"os"
+	"sync"
+)
+
+// splitHashable reports whether f, of size bytes, is hashed in parallel
+// pieces for -split-min: only BLAKE3 can be, on its own, and without
+// anything else reading along.
+func splitHashable(algos []string, size int64, tee io.Writer) bool {
+	return opts.splitMin > 0 && size >= int64(opts.splitMin) && size > hashwalk.Blake3SubtreeSize &&
+		len(algos) == 1 && algos[0] == "blake3" && tee == nil
+}
+
+// splitSums hashes f with BLAKE3 using workerCount() goroutines, each
+// reading and hashing whole hashwalk.Blake3SubtreeSize pieces of it, so a
+// single large file doesn't hash on one core. The last piece, which may be
+// partial, is hashed after the others.
+func splitSums(ctx context.Context, f *os.File, size int64) ([][]byte, int64, error) {
+	pieces := (size - 1) / hashwalk.Blake3SubtreeSize
+	cvs := make([][32]byte, pieces)
+	next := make(chan int64)
+	var mu sync.Mutex
+	var firstErr error
+	fail := func(err error) {
+		mu.Lock()
+		if firstErr == nil {
+			firstErr = err
+		}
+		mu.Unlock()
+	}
+	var wg sync.WaitGroup
+	for w := 0; w < workerCount(); w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			buf := make([]byte, hashwalk.Blake3SubtreeSize)
+			for i := range next {
+				r := limitRead(ctx, io.NewSectionReader(f, i*hashwalk.Blake3SubtreeSize, hashwalk.Blake3SubtreeSize))
+				if _, err := io.ReadFull(r, buf); err != nil {
+					if err == io.EOF || err == io.ErrUnexpectedEOF {
+						err = &os.PathError{Op: "read", Path: f.Name(), Err: io.ErrUnexpectedEOF}
+					}
+					fail(err)
+					continue
+				}
+				cvs[i] = hashwalk.Blake3Subtree(buf, i)
+			}
+		}()
+	}
+	for i := int64(0); i < pieces; i++ {
+		if ctx.Err() != nil {
+			break
+		}
+		next <- i
+	}
+	close(next)
+	wg.Wait()
+	if err := ctx.Err(); err != nil {
+		return nil, 0, err
+	}
+	if firstErr != nil {
+		return nil, 0, firstErr
+	}
+	h := hashwalk.NewBlake3After(cvs)
+	off := pieces * hashwalk.Blake3SubtreeSize
+	n, err := io.Copy(h, limitRead(ctx, io.NewSectionReader(f, off, size-off)))
+	if err != nil {
+		return nil, 0, err
+	}
+	if off+n != size {
+		return nil, 0, &os.PathError{Op: "read", Path: f.Name(), Err: io.ErrUnexpectedEOF}
+	}
+	return [][]byte{h.Sum(nil)}, size, nil
+}
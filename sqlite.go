@@ -0,0 +1,115 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/hex"
+	_ "modernc.org/sqlite"
+	"path/filepath"
+	"time"
+)
+
+// sqliteDriver is the database/sql name of the vendored modernc.org/sqlite
+// driver, a pure Go SQLite that needs neither cgo nor the sqlite3 command.
+const sqliteDriver = "sqlite"
+
+// openSQLite opens the SQLite database dbpath, creating it if needed.
+func openSQLite(dbpath string) (*sql.DB, error) {
+	db, err := sql.Open(sqliteDriver, dbpath)
+	if err != nil {
+		return nil, err
+	}
+	// One connection, so the -serve-db lookups and a rescan's writes never
+	// find the database busy.
+	db.SetMaxOpenConns(1)
+	return db, nil
+}
+
+// filesSchema replaces the files table of -sqlite.
+const filesSchema = `DROP TABLE IF EXISTS files;
+CREATE TABLE files (sum TEXT NOT NULL, path TEXT NOT NULL, size INTEGER NOT NULL, mtime INTEGER NOT NULL);
+CREATE INDEX files_sum ON files (sum);`
+
+// writeSQLite stores rs in the SQLite database dbpath, replacing any
+// previous files table.
+func writeSQLite(dbpath, basepath string, rs resultSlice) error {
+	db, err := openSQLite(dbpath)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	return inTx(db, filesSchema, "INSERT INTO files VALUES (?, ?, ?, ?)", func(insert *sql.Stmt) error {
+		for i := range rs {
+			r := &rs[i]
+			if _, err := insert.Exec(hex.EncodeToString(r.Sum), relPath(basepath, r.Path), r.Size, r.ModTime.Unix()); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// manifestSchema creates the manifest table of -db if it's missing.
+const manifestSchema = `CREATE TABLE IF NOT EXISTS manifest (path TEXT PRIMARY KEY, hash TEXT NOT NULL, size INTEGER NOT NULL, mtime INTEGER NOT NULL, first_seen INTEGER NOT NULL, last_seen INTEGER NOT NULL);
+CREATE INDEX IF NOT EXISTS manifest_hash ON manifest (hash);
+CREATE INDEX IF NOT EXISTS manifest_last_seen ON manifest (last_seen);`
+
+// manifestUpsert inserts or updates a manifest row by absolute path.
+// first_seen is kept from the first run that saw a path.
+const manifestUpsert = `INSERT INTO manifest VALUES (?1, ?2, ?3, ?4, ?5, ?5)
+ON CONFLICT (path) DO UPDATE SET hash = excluded.hash, size = excluded.size, mtime = excluded.mtime, last_seen = excluded.last_seen`
+
+// writeDB upserts rs into the manifest table of the SQLite database dbpath,
+// for -db.
+func writeDB(dbpath string, rs resultSlice) error {
+	db, err := openSQLite(dbpath)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	return upsertManifest(db, rs)
+}
+
+// upsertManifest upserts rs into the manifest table of db. Results without
+// a sum, e.g. hard link aliases whose sums weren't resolved, are left out
+// rather than stored with an empty hash.
+func upsertManifest(db *sql.DB, rs resultSlice) error {
+	now := time.Now().Unix()
+	return inTx(db, manifestSchema, manifestUpsert, func(upsert *sql.Stmt) error {
+		for i := range rs {
+			r := &rs[i]
+			if len(r.Sum) == 0 {
+				continue
+			}
+			p, err := filepath.Abs(r.Path)
+			if err != nil {
+				return err
+			}
+			if _, err := upsert.Exec(p, hex.EncodeToString(r.Sum), r.Size, r.ModTime.Unix(), now); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// inTx runs schema and then rows with query prepared, in one transaction
+// that is rolled back if either fails.
+func inTx(db *sql.DB, schema, query string, rows func(stmt *sql.Stmt) error) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if _, err := tx.Exec(schema); err != nil {
+		return err
+	}
+	stmt, err := tx.Prepare(query)
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+	if err := rows(stmt); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
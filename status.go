@@ -0,0 +1,106 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"unicode/utf8"
+)
+
+// workerFiles holds the file each worker is hashing, for -status-files,
+// nil without it.
+var workerFiles *currentFiles
+
+type currentFiles struct {
+	mu    sync.Mutex
+	paths []string // By worker, empty while a worker waits.
+}
+
+func (c *currentFiles) set(worker int, path string) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	for len(c.paths) <= worker {
+		c.paths = append(c.paths, "")
+	}
+	c.paths[worker] = path
+	c.mu.Unlock()
+}
+
+func (c *currentFiles) list() []string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return append([]string(nil), c.paths...)
+}
+
+// statusTotals are the files and bytes the status line counts its percent
+// complete against, nil unless something counted them first: -prescan,
+// the size filter of the duplicate modes or -prefilter-algo.
+var statusTotals *prescan
+
+// statusLines draws the once a second status on stderr, rewritten in place
+// on a terminal and scrolling otherwise.
+type statusLines struct {
+	tty   bool
+	drawn int // Lines on screen to go back over.
+}
+
+func newStatusLines() *statusLines {
+	return &statusLines{tty: isTerminal(stderr)}
+}
+
+func (l *statusLines) draw(lines []string) {
+	if !l.tty {
+		for _, s := range lines {
+			fmt.Fprintln(stderr, s)
+		}
+		return
+	}
+	width := terminalWidth(stderr)
+	if width <= 0 {
+		width = 80
+	}
+	var b strings.Builder
+	if l.drawn > 1 {
+		fmt.Fprintf(&b, "\x1b[%dA", l.drawn-1)
+	}
+	for i, s := range lines {
+		if i > 0 {
+			b.WriteString("\n")
+		}
+		// Tabs would run past the width.
+		b.WriteString("\r" + shortenRight(strings.Replace(s, "\t", "  ", -1), width-1) + "\x1b[K")
+	}
+	// Lines left over from a longer status before.
+	b.WriteString("\x1b[J")
+	fmt.Fprint(stderr, b.String())
+	l.drawn = len(lines)
+}
+
+// clear removes the status from a terminal, before the next output.
+func (l *statusLines) clear() {
+	if !l.tty || l.drawn == 0 {
+		return
+	}
+	if l.drawn > 1 {
+		fmt.Fprintf(stderr, "\x1b[%dA", l.drawn-1)
+	}
+	fmt.Fprint(stderr, "\r\x1b[J")
+	l.drawn = 0
+}
+
+// shortenRight cuts s to at most n bytes, replacing the end by "...".
+func shortenRight(s string, n int) string {
+	if len(s) <= n {
+		return s
+	}
+	if n <= 3 {
+		return ""
+	}
+	i := n - 3
+	for i > 0 && !utf8.RuneStart(s[i]) {
+		i--
+	}
+	return s[:i] + "..."
+}
@@ -0,0 +1,60 @@
+//go:build linux
+// +build linux
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+)
+
+// Magic numbers of the network file systems in statfs(2)'s f_type.
+var networkFS = map[uint32]string{
+	0x6969:     "nfs",
+	0x517B:     "smb",
+	0xFF534D42: "cifs",
+	0xFE534D42: "smb2",
+	0x65735546: "fuse",
+	0x00C36400: "ceph",
+	0x01021997: "9p",
+	0x47504653: "gpfs",
+	0x0BD00BD0: "lustre",
+}
+
+// probeStorage tells what path is stored on: a network file system from its
+// statfs type, else whether its block device is rotational from sysfs.
+func probeStorage(path string) storage {
+	var sfs syscall.Statfs_t
+	if err := syscall.Statfs(path, &sfs); err == nil {
+		if name, ok := networkFS[uint32(sfs.Type)]; ok {
+			return storage{kind: storageNetwork, detail: name}
+		}
+	}
+	var st syscall.Stat_t
+	if err := syscall.Stat(path, &st); err != nil {
+		return storage{}
+	}
+	dev := uint64(st.Dev)
+	major := (dev>>8)&0xfff | (dev>>32)&^0xfff
+	minor := dev&0xff | (dev>>12)&^0xff
+	sys := fmt.Sprintf("/sys/dev/block/%d:%d", major, minor)
+	// A partition has the queue of its disk one level up.
+	for _, q := range []string{filepath.Join(sys, "queue", "rotational"), filepath.Join(sys, "..", "queue", "rotational")} {
+		b, err := os.ReadFile(q)
+		if err != nil {
+			continue
+		}
+		name := filepath.Base(sys)
+		if link, err := filepath.EvalSymlinks(sys); err == nil {
+			name = filepath.Base(link)
+		}
+		if strings.TrimSpace(string(b)) == "1" {
+			return storage{kind: storageRotational, detail: name}
+		}
+		return storage{kind: storageSolid, detail: name}
+	}
+	return storage{}
+}
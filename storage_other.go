@@ -0,0 +1,9 @@
+//go:build !linux
+// +build !linux
+
+package main
+
+// probeStorage can't tell the kind of storage outside Linux.
+func probeStorage(path string) storage {
+	return storage{}
+}
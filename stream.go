@@ -0,0 +1,68 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"github.com/rajder/gosha1/hashwalk"
+)
+
+// tally keeps the summary stats of results arriving in any order. Only the
+// sums are remembered, not the results.
+type tally struct {
+	summary
+	seen map[string]bool
+	high int
+}
+
+// add counts r, hard link aliases don't count, as for summarize.
+func (t *tally) add(r *result) {
+	if r.Alias != "" {
+		return
+	}
+	if t.seen == nil {
+		t.seen = make(map[string]bool)
+	}
+	t.Files++
+	t.TotalBytes += r.Size
+	if r.Entropy >= highEntropy {
+		t.high++
+	}
+	if t.seen[string(r.Sum)] {
+		t.Duplicates++
+		t.DupBytes += r.Size
+		return
+	}
+	t.seen[string(r.Sum)] = true
+	t.Unique++
+}
+
+// streamResults prints the results of s as they arrive, for -stream, or
+// only their totals with -summary. Memory use only grows with the number of
+// distinct sums. The summary stats are
+// returned for -summary-json.
+func streamResults(s *hashwalk.Scan[result], bar *progressBar, failed *[]result, basepath string) (summary, error) {
+	if !opts.summaryOnly {
+		printAlgosHeader()
+	}
+	var t tally
+	err := collectEach(s, bar, failed, func(r result) {
+		if r.Alias != "" && r.Sum == nil {
+			// The link failed.
+			return
+		}
+		if !opts.summaryOnly {
+			printResult(basepath, &r)
+		}
+		t.add(&r)
+	})
+	if err != nil && !errors.Is(err, context.Canceled) && !errors.Is(err, context.DeadlineExceeded) {
+		return t.summary, err
+	}
+	if opts.summaryOnly && err == nil {
+		if perr := printSummaryOnly(t.summary); perr != nil {
+			return t.summary, perr
+		}
+	}
+	printStats(t.summary, t.high)
+	return t.summary, err
+}
@@ -0,0 +1,16 @@
+//go:build !linux && !darwin && !dragonfly && !freebsd && !netbsd && !openbsd
+// +build !linux,!darwin,!dragonfly,!freebsd,!netbsd,!openbsd
+
+package main
+
+import "os"
+
+// terminalWidth returns 0, meaning unknown, on this platform.
+func terminalWidth(f *os.File) int {
+	return 0
+}
+
+// terminalSize returns zeros, meaning unknown, on this platform.
+func terminalSize(f *os.File) (rows, cols int) {
+	return 0, 0
+}
@@ -0,0 +1,29 @@
+//go:build linux || darwin || dragonfly || freebsd || netbsd || openbsd
+// +build linux darwin dragonfly freebsd netbsd openbsd
+
+package main
+
+import (
+	"os"
+	"syscall"
+	"unsafe"
+)
+
+// terminalWidth returns the number of columns of the terminal f, or 0 if it
+// can't be determined.
+func terminalWidth(f *os.File) int {
+	_, cols := terminalSize(f)
+	return cols
+}
+
+// terminalSize returns the rows and columns of the terminal f, or zeros if
+// they can't be determined.
+func terminalSize(f *os.File) (rows, cols int) {
+	var ws struct{ Row, Col, Xpixel, Ypixel uint16 }
+	_, _, errno := syscall.Syscall(syscall.SYS_IOCTL, f.Fd(),
+		uintptr(syscall.TIOCGWINSZ), uintptr(unsafe.Pointer(&ws)))
+	if errno != 0 {
+		return 0, 0
+	}
+	return int(ws.Row), int(ws.Col)
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"github.com/rajder/gosha1/hashwalk"
+	"io"
+	"strconv"
+	"time"
+)
+
+// durationValue is a flag.Value of a duration like 1m30s that also takes a
+// plain number of seconds, e.g. -file-timeout 30.
+type durationValue struct {
+	d *time.Duration
+}
+
+func (v durationValue) String() string {
+	if v.d == nil {
+		return "0s"
+	}
+	return v.d.String()
+}
+
+func (v durationValue) Set(s string) error {
+	if n, err := strconv.ParseFloat(s, 64); err == nil {
+		*v.d = time.Duration(n * float64(time.Second))
+		return nil
+	}
+	d, err := time.ParseDuration(s)
+	if err != nil {
+		return fmt.Errorf("invalid duration %q, e.g. 30 (seconds), 30s or 1m30s", s)
+	}
+	*v.d = d
+	return nil
+}
+
+// calcSumsTimeout is calcSums giving up after -file-timeout, see
+// hashwalk.WithTimeout. The tee must not be used after a timeout.
+func calcSumsTimeout(ctx context.Context, path string, algos []string, tee io.Writer) ([][]byte, int64, error) {
+	return withTimeout(ctx, path, func(ctx context.Context) ([][]byte, int64, error) {
+		return calcSums(ctx, path, algos, tee)
+	})
+}
+
+// withTimeout runs sum, giving up on it after -file-timeout.
+func withTimeout(ctx context.Context, path string, sum func(ctx context.Context) ([][]byte, int64, error)) ([][]byte, int64, error) {
+	return hashwalk.WithTimeout(ctx, path, opts.fileTimeout, sum)
+}
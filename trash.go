@@ -0,0 +1,272 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// The -trash directory keeps the redundant copies the -dupes actions
+// delete or replace, so -undo can put them back: each is hard linked to
+// <trash>/<run>/<its absolute path> before the action and, once it is done,
+// recorded in <trash>/journal as
+//
+//	ACTION<TAB>"trashed path"<TAB>"original path"<TAB>"kept path"
+//
+// with the trashed path relative to the trash directory. The paths are Go
+// quoted, so tabs and newlines in file names can't break the lines.
+const trashJournal = "journal"
+
+// trash is the prepared -trash directory, empty until openTrash.
+var trash string
+
+// trashRun names this run's directory in the trash.
+var trashRun = time.Now().Format("20060102-150405") + "-" + strconv.Itoa(os.Getpid())
+
+var scanRootsReal []string
+
+// scanRoots returns the roots the -dupes actions may act below, absolute
+// and with symlinks resolved: the root arguments, or the current directory
+// for -files-from.
+func scanRoots() []string {
+	if scanRootsReal != nil {
+		return scanRootsReal
+	}
+	roots := opts.args
+	if len(roots) == 0 {
+		roots = []string{"."}
+	}
+	for _, r := range roots {
+		abs, err := filepath.Abs(r)
+		if err == nil {
+			if real, err := filepath.EvalSymlinks(abs); err == nil {
+				abs = real
+			}
+		}
+		scanRootsReal = append(scanRootsReal, abs)
+	}
+	return scanRootsReal
+}
+
+// outsideRoots reports whether path, with the symlinks of its directory
+// resolved, is outside the scan roots, so an action on it would reach
+// somewhere the user didn't ask to change.
+func outsideRoots(path string) bool {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return true
+	}
+	dir, err := filepath.EvalSymlinks(filepath.Dir(abs))
+	if err != nil {
+		return true
+	}
+	return !belowRoots(filepath.Join(dir, filepath.Base(abs)), scanRoots())
+}
+
+// openTrash creates the -trash directory unless there already is one. It
+// can't be below a scan root, where later scans would find the copies in
+// it again.
+func openTrash() error {
+	if opts.trash == "" || trash != "" {
+		return nil
+	}
+	below := fmt.Errorf("-trash %s is below a scan root, put it elsewhere on the same file system", opts.trash)
+	abs, err := filepath.Abs(opts.trash)
+	if err != nil {
+		return err
+	}
+	if belowRoots(abs, scanRoots()) {
+		return below
+	}
+	if err := os.MkdirAll(abs, 0700); err != nil {
+		return err
+	}
+	if abs, err = filepath.EvalSymlinks(abs); err != nil {
+		return err
+	}
+	if belowRoots(abs, scanRoots()) {
+		return below
+	}
+	trash = abs
+	return nil
+}
+
+// applyAction applies a to path in favour of keep, first linking path into
+// the trash with -trash and recording it in the journal once a is done.
+func applyAction(a dedupAction, keep, path string) error {
+	if trash == "" {
+		return a.apply(keep, path)
+	}
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return err
+	}
+	rel := filepath.Join(trashRun, strings.TrimPrefix(abs, filepath.VolumeName(abs)))
+	dst := filepath.Join(trash, rel)
+	if err := os.MkdirAll(filepath.Dir(dst), 0700); err != nil {
+		return err
+	}
+	// A link fails across file systems, the trash must be on the same one.
+	if err := os.Link(abs, dst); err != nil {
+		return err
+	}
+	if err := a.apply(keep, path); err != nil {
+		os.Remove(dst)
+		return err
+	}
+	keepAbs, _ := filepath.Abs(keep)
+	j, err := os.OpenFile(filepath.Join(trash, trashJournal), os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0600)
+	if err == nil {
+		err = writeTrashEntry(j, trashEntry{a.name, filepath.ToSlash(rel), abs, keepAbs})
+		if cerr := j.Close(); err == nil {
+			err = cerr
+		}
+	}
+	if err != nil {
+		return fmt.Errorf("%s is in the trash as %s but not in its journal: %v", path, dst, err)
+	}
+	return nil
+}
+
+type trashEntry struct {
+	action, trashed, path, keep string
+}
+
+// writeTrashEntry writes e as a journal line, with e.trashed relative to the
+// trash directory.
+func writeTrashEntry(w io.Writer, e trashEntry) error {
+	_, err := fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", e.action, strconv.Quote(e.trashed), strconv.Quote(e.path), strconv.Quote(e.keep))
+	return err
+}
+
+// runUndo puts the files in the trash dir back where the -dupes actions
+// took them from, the last first. Those that can't be restored stay in the
+// trash and the journal: a deleted file is only restored if nothing took
+// its place, a linked or cloned one only if the link or clone is still
+// there.
+func runUndo(dir string) error {
+	jpath := filepath.Join(dir, trashJournal)
+	f, err := os.Open(jpath)
+	if err != nil {
+		return err
+	}
+	var entries []trashEntry
+	sc := bufio.NewScanner(f)
+	n := 0
+	for sc.Scan() {
+		n++
+		cols := strings.Split(sc.Text(), "\t")
+		if len(cols) != 4 {
+			f.Close()
+			return fmt.Errorf("%s:%d: expected an action and three paths separated by tabs", jpath, n)
+		}
+		for i := 1; i < len(cols); i++ {
+			if cols[i], err = strconv.Unquote(cols[i]); err != nil {
+				f.Close()
+				return fmt.Errorf("%s:%d: the paths must be quoted", jpath, n)
+			}
+		}
+		entries = append(entries, trashEntry{cols[0], filepath.Join(dir, filepath.FromSlash(cols[1])), cols[2], cols[3]})
+	}
+	f.Close()
+	if err := sc.Err(); err != nil {
+		return err
+	}
+	var kept []trashEntry
+	restored := 0
+	for i := len(entries) - 1; i >= 0; i-- {
+		e := entries[i]
+		skip, err := undoEntry(e)
+		if err != nil {
+			logWarning(err)
+			skip = err.Error()
+		}
+		if skip != "" {
+			printLine("SKIP\t%s: %s", e.path, skip)
+			kept = append([]trashEntry{e}, kept...)
+			continue
+		}
+		printLine("RESTORE\t%s", e.path)
+		restored++
+	}
+	if len(kept) == 0 {
+		err = os.Remove(jpath)
+	} else {
+		err = rewriteJournal(jpath, dir, kept)
+	}
+	if err != nil {
+		return err
+	}
+	removeEmptyDirs(dir)
+	log("Restored     :", restored)
+	if len(kept) > 0 {
+		return fmt.Errorf("%d files could not be restored, they stay in %s", len(kept), dir)
+	}
+	return nil
+}
+
+func rewriteJournal(jpath, dir string, entries []trashEntry) error {
+	out, err := createOutput(jpath)
+	if err != nil {
+		return err
+	}
+	for _, e := range entries {
+		rel, _ := filepath.Rel(dir, e.trashed)
+		if err := writeTrashEntry(out.f, trashEntry{e.action, filepath.ToSlash(rel), e.path, e.keep}); err != nil {
+			out.abort()
+			return err
+		}
+	}
+	return out.commit()
+}
+
+// undoEntry renames the trashed file of e back. It returns why it didn't,
+// or an empty string if it did.
+func undoEntry(e trashEntry) (string, error) {
+	tfi, err := os.Lstat(e.trashed)
+	if err != nil {
+		return "", err
+	}
+	fi, err := os.Lstat(e.path)
+	switch {
+	case e.action == "DELETE" && err == nil:
+		return "something else is there now", nil
+	case e.action == "DELETE" && !os.IsNotExist(err):
+		return "", err
+	case e.action != "DELETE" && err != nil:
+		return "the " + strings.ToLower(e.action) + " isn't there any more", nil
+	case e.action == "LINK":
+		kfi, err := os.Lstat(e.keep)
+		if err != nil || !os.SameFile(fi, kfi) {
+			return "it isn't a link to " + e.keep + " any more", nil
+		}
+	case e.action == "REFLINK":
+		// A clone keeps the size and mtime of the file it replaced.
+		if fi.Size() != tfi.Size() || !fi.ModTime().Equal(tfi.ModTime()) {
+			return "the clone changed since", nil
+		}
+	}
+	if err := os.MkdirAll(filepath.Dir(e.path), 0755); err != nil {
+		return "", err
+	}
+	return "", os.Rename(e.trashed, e.path)
+}
+
+// removeEmptyDirs removes the empty directories below dir, deepest first.
+func removeEmptyDirs(dir string) {
+	var dirs []string
+	filepath.Walk(dir, func(p string, fi os.FileInfo, err error) error {
+		if err == nil && fi.IsDir() && p != dir {
+			dirs = append(dirs, p)
+		}
+		return nil
+	})
+	for i := len(dirs) - 1; i >= 0; i-- {
+		os.Remove(dirs[i])
+	}
+}
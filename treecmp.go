@@ -0,0 +1,158 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+)
+
+// walkBoth walks the roots at the same time, each with its own walker, so
+// trees on different disks are read in parallel.
+func walkBoth(roots []string) jobProducer {
+	return func(ctx context.Context, jobs chan<- job) error {
+		errs := make([]error, len(roots))
+		var wg sync.WaitGroup
+		for i, root := range roots {
+			wg.Add(1)
+			go func(i int, root string) {
+				defer wg.Done()
+				logVerbose("Walking      :", root)
+				errs[i] = newWalker(ctx, jobs, nil).Walk(root, i)
+			}(i, root)
+		}
+		wg.Wait()
+		for _, err := range errs {
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+}
+
+// isDir reports whether path is a directory.
+func isDir(path string) bool {
+	fi, err := os.Stat(path)
+	return err == nil && fi.IsDir()
+}
+
+// compareTrees hashes the trees a and b and prints a line per difference:
+// only-a or only-b for a relative path in one tree only, differ for a path
+// in both with other content. With byContent paths don't matter, only-a and
+// only-b list the files whose content isn't anywhere in the other tree.
+// With verify, equal sums at the same path are confirmed byte by byte. Either
+// tree may be a remote root, e.g. sftp://host/backup or s3://bucket/backup. It
+// returns the number of differences.
+func compareTrees(a, b string, byContent, verify bool) (int, error) {
+	roots := []string{a, b}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	trees := [2]map[string]*result{{}, {}}
+	// Remote roots are hashed on their host, the local ones here.
+	var local []string
+	var localTree []int
+	hasSizes := true
+	for t, root := range roots {
+		if !isRemote(root) {
+			local = append(local, root)
+			localTree = append(localTree, t)
+			continue
+		}
+		if verify {
+			return 0, errors.New("-cmp-bytes can't compare files on a remote root")
+		}
+		rs, sized, err := remoteTree(ctx, root, opts.algos)
+		if err != nil {
+			return 0, err
+		}
+		hasSizes = hasSizes && sized
+		for i := range rs {
+			trees[t][rs[i].Path] = &rs[i]
+		}
+	}
+	var failed []result
+	if len(local) > 0 {
+		rs, err := collect(produceConcurrent(ctx, hashSpec{algos: opts.algos}, walkBoth(local)), nil, &failed)
+		if err != nil {
+			return 0, err
+		}
+		for i := range rs {
+			r := &rs[i]
+			trees[localTree[r.Root]][filepath.ToSlash(relPath(local[r.Root], r.Path))] = r
+		}
+	}
+	var labels = [2]string{"only-a", "only-b"}
+	var only [2]int
+	differ, same := 0, 0
+	if byContent {
+		var sums [2]map[string]bool
+		for t := range trees {
+			sums[t] = make(map[string]bool, len(trees[t]))
+			for _, r := range trees[t] {
+				sums[t][string(r.Sum)] = true
+			}
+		}
+		for t := range trees {
+			for _, p := range sortedPaths(trees[t]) {
+				if sums[1-t][string(trees[t][p].Sum)] {
+					same++
+					continue
+				}
+				printLine("%s\t%s", labels[t], p)
+				only[t]++
+			}
+		}
+	} else {
+		for t := range trees {
+			for _, p := range sortedPaths(trees[t]) {
+				if _, ok := trees[1-t][p]; !ok {
+					printLine("%s\t%s", labels[t], p)
+					only[t]++
+				}
+			}
+		}
+		for _, p := range sortedPaths(trees[0]) {
+			ra, rb := trees[0][p], trees[1][p]
+			if rb == nil {
+				continue
+			}
+			eq := (ra.Size == rb.Size || !hasSizes) && bytes.Equal(ra.Sum, rb.Sum)
+			if eq && verify {
+				var err error
+				eq, err = sameContent(ra.Path, rb.Path)
+				if err != nil {
+					return 0, err
+				}
+			}
+			if !eq {
+				printLine("differ\t%s", p)
+				differ++
+				continue
+			}
+			same++
+		}
+	}
+	log("Only in A    :", only[0])
+	log("Only in B    :", only[1])
+	if !byContent {
+		log("Differ       :", differ)
+	}
+	log("Same         :", same)
+	if err := failedFilesError(failed); err != nil {
+		return 0, err
+	}
+	return only[0] + only[1] + differ, nil
+}
+
+func sortedPaths(tree map[string]*result) []string {
+	ps := make([]string, 0, len(tree))
+	for p := range tree {
+		ps = append(ps, p)
+	}
+	sort.Strings(ps)
+	return ps
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"fmt"
+	"github.com/rajder/gosha1/hashwalk"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// dirDigest is the rollup sum of one directory for -tree-digest.
+type dirDigest struct {
+	Path  string
+	Sum   []byte
+	depth int
+}
+
+// treeDigests computes a digest per directory from the sorted
+// "<sum>  <name>" lines of its files and subdirectories (names of
+// subdirectories end in /), hashed with the first of the -algos. Parents
+// are computed from their children up to the top directory, which comes
+// last. Directories without any hashed file below them don't count.
+func treeDigests(basepath string, rs resultSlice) []dirDigest {
+	children := make(map[string]map[string][]byte)
+	add := func(dir, name string, sum []byte) {
+		if children[dir] == nil {
+			children[dir] = make(map[string][]byte)
+		}
+		children[dir][name] = sum
+	}
+	for _, r := range rs {
+		p := filepath.ToSlash(relPath(basepath, r.Path))
+		add(pathDir(p), pathBase(p), r.Sum)
+	}
+	dirs := make([]dirDigest, 0, len(children))
+	for d := range children {
+		dirs = append(dirs, dirDigest{Path: d, depth: dirDepth(d)})
+	}
+	// Parents are added on the way up, so keep pulling in new ones.
+	for i := 0; i < len(dirs); i++ {
+		d := dirs[i].Path
+		if parent := pathDir(d); parent != d {
+			if _, ok := children[parent]; !ok {
+				children[parent] = make(map[string][]byte)
+				dirs = append(dirs, dirDigest{Path: parent, depth: dirDepth(parent)})
+			}
+		}
+	}
+	sort.Slice(dirs, func(i, j int) bool {
+		if dirs[i].depth != dirs[j].depth {
+			return dirs[i].depth > dirs[j].depth
+		}
+		return dirs[i].Path < dirs[j].Path
+	})
+	for i := range dirs {
+		d := &dirs[i]
+		names := make([]string, 0, len(children[d.Path]))
+		for name := range children[d.Path] {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		h := hashwalk.NewHashes(opts.algos[:1])[0]
+		for _, name := range names {
+			fmt.Fprintf(h, "%x  %s\n", children[d.Path][name], name)
+		}
+		d.Sum = h.Sum(nil)
+		if parent := pathDir(d.Path); parent != d.Path {
+			add(parent, pathBase(d.Path)+"/", d.Sum)
+		}
+	}
+	return dirs
+}
+
+func pathDir(p string) string {
+	i := strings.LastIndex(p, "/")
+	switch {
+	case i < 0:
+		if p == "." {
+			return p
+		}
+		return "."
+	case i == 0:
+		return "/"
+	}
+	return p[:i]
+}
+
+func pathBase(p string) string {
+	return p[strings.LastIndex(p, "/")+1:]
+}
+
+func dirDepth(d string) int {
+	if d == "." || d == "/" {
+		return 0
+	}
+	return strings.Count(strings.TrimPrefix(d, "/"), "/") + 1
+}
+
+// printTreeDigests prints a "sum<TAB>dir/" line per directory, deepest first
+// and the top directory last, and logs the top digest.
+func printTreeDigests(basepath string, rs resultSlice) {
+	dirs := treeDigests(basepath, rs)
+	for _, d := range dirs {
+		p := d.Path
+		if !strings.HasSuffix(p, "/") {
+			p += "/"
+		}
+		printLine("%x\t%s", d.Sum, p)
+	}
+	if len(dirs) > 0 {
+		log("Tree digest  :", fmt.Sprintf("%x", dirs[len(dirs)-1].Sum))
+	}
+}
@@ -0,0 +1,453 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"github.com/rajder/gosha1/hashwalk"
+	"os"
+	"strings"
+	"time"
+)
+
+// The -tui duplicate browser: a full screen list of the duplicate groups on
+// the terminal, where files are marked for deletion or linking with the
+// keyboard and the marks are only acted on after confirming them. It draws
+// with plain ANSI escapes on /dev/tty, so stdout still gets the action log.
+
+// tuiKeys is the help line at the bottom of the screen.
+const tuiKeys = "↑↓/jk move  n/p group  d delete  l link  space unmark  x execute  q quit"
+
+// tuiMark is what to do with a file on executing.
+type tuiMark byte
+
+const (
+	markNone   tuiMark = ' '
+	markDelete tuiMark = 'D'
+	markLink   tuiMark = 'L'
+)
+
+// tuiRow is a line of the list, a group header when file is -1.
+type tuiRow struct {
+	group, file int
+}
+
+type tui struct {
+	tty      *os.File
+	out      *bufio.Writer
+	basepath string
+	groups   []resultSlice
+	marks    [][]tuiMark
+	rows     []tuiRow
+	cursor   int
+	top      int // First row on screen.
+	status   string
+}
+
+// runTUI browses the duplicate groups of the sorted rs and acts on the
+// files marked when the user confirms.
+func runTUI(basepath string, rs resultSlice) error {
+	groups := reportedGroups(rs)
+	found = found || len(groups) > 0
+	if len(groups) == 0 {
+		log("Duplicates   : 0, nothing to browse")
+		return nil
+	}
+	tty, err := os.OpenFile("/dev/tty", os.O_RDWR, 0)
+	if err != nil {
+		return fmt.Errorf("-tui needs a terminal: %v", err)
+	}
+	defer tty.Close()
+	restore, err := makeRaw(tty)
+	if err != nil {
+		return fmt.Errorf("-tui needs a terminal: %v", err)
+	}
+	t := &tui{tty: tty, out: bufio.NewWriter(tty), basepath: basepath, groups: groups}
+	for gi, g := range groups {
+		t.marks = append(t.marks, make([]tuiMark, len(g)))
+		for i := range g {
+			t.marks[gi][i] = markNone
+		}
+		t.rows = append(t.rows, tuiRow{gi, -1})
+		for i := range g {
+			t.rows = append(t.rows, tuiRow{gi, i})
+		}
+	}
+	// Alternate screen, hidden cursor.
+	t.out.WriteString("\x1b[?1049h\x1b[?25l")
+	execute, err := t.loop()
+	t.out.WriteString("\x1b[?25h\x1b[?1049l")
+	t.out.Flush()
+	restore()
+	if err != nil || !execute {
+		if err == nil {
+			log("Quit         : nothing done")
+		}
+		return err
+	}
+	return t.execute()
+}
+
+// loop handles keys until the user quits or confirms executing the marks.
+func (t *tui) loop() (bool, error) {
+	in := bufio.NewReader(t.tty)
+	for {
+		t.draw()
+		key, err := readKey(in)
+		if err != nil {
+			return false, err
+		}
+		t.status = ""
+		row := t.rows[t.cursor]
+		switch key {
+		case "up", "k":
+			t.move(-1)
+		case "down", "j":
+			t.move(1)
+		case "pgup":
+			t.move(-t.listHeight())
+		case "pgdn":
+			t.move(t.listHeight())
+		case "home", "g":
+			t.move(-len(t.rows))
+		case "end", "G":
+			t.move(len(t.rows))
+		case "n", "tab":
+			t.moveGroup(1)
+		case "p":
+			t.moveGroup(-1)
+		case "d":
+			t.mark(row, markDelete)
+		case "l":
+			t.mark(row, markLink)
+		case " ", "u":
+			t.mark(row, markNone)
+		case "x", "enter":
+			if err := t.checkMarks(); err != nil {
+				t.status = err.Error()
+				continue
+			}
+			del, link, reclaim := t.counts()
+			if del+link == 0 {
+				t.status = "nothing marked"
+				continue
+			}
+			verb := "Execute"
+			if opts.dryRun {
+				verb = "Dry run"
+			}
+			t.status = fmt.Sprintf("%s: delete %d and link %d files, reclaiming %.1f MB? [y/N]",
+				verb, del, link, float64(reclaim)/1024/1024)
+			t.draw()
+			answer, err := readKey(in)
+			if err != nil {
+				return false, err
+			}
+			if answer == "y" || answer == "Y" {
+				return true, nil
+			}
+			t.status = "not executed"
+		case "q", "ctrl-c", "esc":
+			return false, nil
+		}
+	}
+}
+
+// readKey reads a key press, naming the special keys used.
+func readKey(in *bufio.Reader) (string, error) {
+	b, err := in.ReadByte()
+	if err != nil {
+		return "", err
+	}
+	switch b {
+	case 3:
+		return "ctrl-c", nil
+	case '\t':
+		return "tab", nil
+	case '\r', '\n':
+		return "enter", nil
+	case 0x1b:
+	default:
+		return string(b), nil
+	}
+	if in.Buffered() == 0 {
+		return "esc", nil
+	}
+	seq := []byte{}
+	for in.Buffered() > 0 && len(seq) < 8 {
+		c, _ := in.ReadByte()
+		seq = append(seq, c)
+		if len(seq) > 1 && (c >= 'A' && c <= 'Z' || c == '~') {
+			break
+		}
+	}
+	switch strings.TrimLeft(string(seq), "[O") {
+	case "A":
+		return "up", nil
+	case "B":
+		return "down", nil
+	case "H", "1~":
+		return "home", nil
+	case "F", "4~":
+		return "end", nil
+	case "5~":
+		return "pgup", nil
+	case "6~":
+		return "pgdn", nil
+	}
+	return "", nil
+}
+
+func (t *tui) move(n int) {
+	t.cursor += n
+	if t.cursor < 0 {
+		t.cursor = 0
+	}
+	if t.cursor >= len(t.rows) {
+		t.cursor = len(t.rows) - 1
+	}
+}
+
+// moveGroup moves to the header of the next or previous group.
+func (t *tui) moveGroup(n int) {
+	g := t.rows[t.cursor].group + n
+	if g < 0 || g >= len(t.groups) {
+		return
+	}
+	for i, row := range t.rows {
+		if row.group == g && row.file < 0 {
+			t.cursor = i
+			return
+		}
+	}
+}
+
+// mark sets the mark of the file in row, or on a group header those of the
+// copies -keep would remove, so one key marks a whole group.
+func (t *tui) mark(row tuiRow, m tuiMark) {
+	if row.file >= 0 {
+		t.marks[row.group][row.file] = m
+		t.move(1)
+		return
+	}
+	g := t.groups[row.group]
+	plan, err := planGroup(g)
+	if err != nil {
+		t.status = err.Error()
+		return
+	}
+	for i := range g {
+		t.marks[row.group][i] = markNone
+		if &g[i] != plan.Keep && m != markNone {
+			t.marks[row.group][i] = m
+		}
+	}
+	t.moveGroup(1)
+}
+
+// keptFile is the index of the file of group gi that the linked copies
+// point to: the one -keep picks if it is unmarked, else the first unmarked
+// one, -1 if all are marked.
+func (t *tui) keptFile(gi int) int {
+	g := t.groups[gi]
+	if plan, err := planGroup(g); err == nil {
+		for i := range g {
+			if &g[i] == plan.Keep && t.marks[gi][i] == markNone {
+				return i
+			}
+		}
+	}
+	for i := range g {
+		if t.marks[gi][i] == markNone {
+			return i
+		}
+	}
+	return -1
+}
+
+// checkMarks refuses to remove every copy of a file.
+func (t *tui) checkMarks() error {
+	for gi := range t.groups {
+		if t.keptFile(gi) < 0 {
+			return fmt.Errorf("all copies in group %d are marked, unmark one to keep", gi+1)
+		}
+	}
+	return nil
+}
+
+func (t *tui) counts() (del, link int, reclaim int64) {
+	for gi, g := range t.groups {
+		for i, m := range t.marks[gi] {
+			switch m {
+			case markDelete:
+				del++
+			case markLink:
+				link++
+			default:
+				continue
+			}
+			reclaim += g[i].Size
+		}
+	}
+	return
+}
+
+// listHeight is the number of list rows on screen, below the title and
+// above the preview, status and help lines.
+func (t *tui) listHeight() int {
+	rows, _ := terminalSize(t.tty)
+	if rows < 10 {
+		rows = 24
+	}
+	return rows - 6
+}
+
+func (t *tui) draw() {
+	_, cols := terminalSize(t.tty)
+	if cols < 20 {
+		cols = 80
+	}
+	h := t.listHeight()
+	if t.cursor < t.top {
+		t.top = t.cursor
+	}
+	if t.cursor >= t.top+h {
+		t.top = t.cursor - h + 1
+	}
+	var total int64
+	for _, g := range t.groups {
+		total += wasted(g)
+	}
+	del, link, _ := t.counts()
+	w := t.out
+	w.WriteString("\x1b[H\x1b[2J")
+	t.line(fmt.Sprintf("gosha1: %d duplicate groups, %.1f MB wasted, %d marked for deletion, %d for linking",
+		len(t.groups), float64(total)/1024/1024, del, link), cols, "\x1b[1m")
+	for i := t.top; i < t.top+h; i++ {
+		if i >= len(t.rows) {
+			w.WriteString("\r\n")
+			continue
+		}
+		row := t.rows[i]
+		g := t.groups[row.group]
+		var s string
+		if row.file < 0 {
+			s = fmt.Sprintf("%d. %d copies of %.1f MB, %x", row.group+1, len(g), float64(g[0].Size)/1024/1024, g[0].Sum)
+		} else {
+			s = fmt.Sprintf("   [%c] %s", t.marks[row.group][row.file], relPath(t.basepath, g[row.file].Path))
+		}
+		style := ""
+		if i == t.cursor {
+			style = "\x1b[7m"
+		}
+		t.line(s, cols, style)
+	}
+	for _, s := range t.preview() {
+		t.line(s, cols, "\x1b[2m")
+	}
+	t.line(t.status, cols, "\x1b[1m")
+	w.WriteString(truncate(tuiKeys, cols))
+	w.Flush()
+}
+
+// line writes s cut to the screen width, in style.
+func (t *tui) line(s string, cols int, style string) {
+	if style != "" {
+		t.out.WriteString(style)
+	}
+	t.out.WriteString(truncate(s, cols))
+	if style != "" {
+		t.out.WriteString("\x1b[0m")
+	}
+	t.out.WriteString("\r\n")
+}
+
+func truncate(s string, cols int) string {
+	r := []rune(s)
+	if len(r) > cols {
+		return string(r[:cols-1]) + "…"
+	}
+	return s
+}
+
+// preview is the two lines describing the group or file under the cursor.
+func (t *tui) preview() []string {
+	row := t.rows[t.cursor]
+	g := t.groups[row.group]
+	if row.file < 0 {
+		oldest, newest := mtimeSpan(g)
+		return []string{
+			fmt.Sprintf("wasted %d bytes, oldest %s, newest %s", wasted(g),
+				oldest.Format(time.RFC3339), newest.Format(time.RFC3339)),
+			"d or l on a group marks all copies but the one -keep " + opts.keep + " picks",
+		}
+	}
+	r := g[row.file]
+	info := fmt.Sprintf("%d bytes, modified %s", r.Size, r.ModTime.Format(time.RFC3339))
+	if fi, err := os.Lstat(r.Path); err != nil {
+		info += ", " + err.Error()
+	} else {
+		info += fmt.Sprintf(", %v, %d links", fi.Mode(), hashwalk.LinkCount(fi))
+		if changedSince(fi, &r) {
+			info += ", changed since it was hashed"
+		}
+	}
+	return []string{r.Path, info}
+}
+
+// execute deletes and links the marked files, logging each action like
+// -delete-dupes and -link-dupes, and only printing them with -dry-run.
+func (t *tui) execute() error {
+	if !opts.dryRun {
+		if err := openTrash(); err != nil {
+			return err
+		}
+	}
+	var n, failed int
+	var reclaim int64
+	for gi, g := range t.groups {
+		keep := &g[t.keptFile(gi)]
+		k := relPath(t.basepath, keep.Path)
+		for i, m := range t.marks[gi] {
+			if m == markNone {
+				continue
+			}
+			a := deleteAction
+			if m == markLink {
+				a = linkAction
+			}
+			r := &g[i]
+			p := relPath(t.basepath, r.Path)
+			skip, err := dedupTarget(keep, r)
+			if err != nil {
+				logWarning(err)
+				failed++
+				continue
+			}
+			if skip != "" {
+				printLine("SKIP\t%d\t%s\t%s: %s", r.Size, p, k, skip)
+				continue
+			}
+			name := a.name
+			if opts.dryRun {
+				name = "WOULD-" + a.name
+			} else if err := applyAction(a, keep.Path, r.Path); err != nil {
+				logWarning(err)
+				failed++
+				continue
+			}
+			printLine("%s\t%d\t%s\t%s", name, r.Size, p, k)
+			n++
+			reclaim += r.Size
+		}
+	}
+	if opts.dryRun {
+		log("Would act on :", n)
+	} else {
+		log("Acted on     :", n)
+	}
+	log("Reclaim MB   :", float64(reclaim)/1024/1024)
+	if failed > 0 {
+		return fmt.Errorf("%d marked files could not be deleted or linked", failed)
+	}
+	return nil
+}
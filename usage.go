@@ -0,0 +1,89 @@
+package main
+
+import (
+	"bytes"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// usageRow is the space taken by the files of one extension or top level
+// directory for -report usage.
+type usageRow struct {
+	Name     string
+	Files    int
+	Bytes    int64
+	DupBytes int64 // Of the copies beyond the first of each content.
+}
+
+// usageBreakdown aggregates the sorted rs by lower case file extension and
+// by top level directory below basepath. Like the totals, each file after
+// the first with the same sum counts as a duplicate. Rows are ordered by
+// duplicate bytes, then bytes, most first.
+func usageBreakdown(basepath string, rs resultSlice) (exts, dirs []usageRow) {
+	rs = withoutAliases(rs)
+	byExt := make(map[string]*usageRow)
+	byDir := make(map[string]*usageRow)
+	add := func(m map[string]*usageRow, name string, r *result, dup bool) {
+		u := m[name]
+		if u == nil {
+			u = &usageRow{Name: name}
+			m[name] = u
+		}
+		u.Files++
+		u.Bytes += r.Size
+		if dup {
+			u.DupBytes += r.Size
+		}
+	}
+	for i := range rs {
+		r := &rs[i]
+		dup := i > 0 && bytes.Equal(r.Sum, rs[i-1].Sum)
+		p := filepath.ToSlash(relPath(basepath, r.Path))
+		ext := strings.ToLower(filepath.Ext(pathBase(p)))
+		if ext == "" {
+			ext = "(none)"
+		}
+		add(byExt, ext, r, dup)
+		// Absolute paths, of several roots, keep their leading slash.
+		dir := "./"
+		rest := strings.TrimPrefix(p, "/")
+		if i := strings.Index(rest, "/"); i >= 0 {
+			dir = p[:len(p)-len(rest)+i+1]
+		}
+		add(byDir, dir, r, dup)
+	}
+	return sortedUsage(byExt), sortedUsage(byDir)
+}
+
+func sortedUsage(m map[string]*usageRow) []usageRow {
+	rows := make([]usageRow, 0, len(m))
+	for _, u := range m {
+		rows = append(rows, *u)
+	}
+	sort.Slice(rows, func(i, j int) bool {
+		a, b := rows[i], rows[j]
+		if a.DupBytes != b.DupBytes {
+			return a.DupBytes > b.DupBytes
+		}
+		if a.Bytes != b.Bytes {
+			return a.Bytes > b.Bytes
+		}
+		return a.Name < b.Name
+	})
+	return rows
+}
+
+// printUsage prints the -report usage breakdown, an
+// "ext|dir<TAB>files<TAB>bytes<TAB>duplicate bytes<TAB>name" line per
+// extension and per top level directory, followed by the totals.
+func printUsage(basepath string, rs resultSlice) {
+	exts, dirs := usageBreakdown(basepath, rs)
+	for _, u := range exts {
+		printLine("ext\t%d\t%d\t%d\t%s", u.Files, u.Bytes, u.DupBytes, u.Name)
+	}
+	for _, u := range dirs {
+		printLine("dir\t%d\t%d\t%d\t%s", u.Files, u.Bytes, u.DupBytes, u.Name)
+	}
+	printSummary(rs)
+}